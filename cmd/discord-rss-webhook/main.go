@@ -8,15 +8,19 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/facebookgo/flagenv"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/pstuifzand/ekster/pkg/jsonfeed"
 	"github.com/tigrisdata-community/glue/internal"
+	"github.com/tigrisdata-community/glue/internal/lease"
 	"github.com/tigrisdata-community/glue/internal/store"
+	"github.com/tigrisdata-community/glue/internal/tracing"
 	"github.com/tigrisdata-community/glue/web"
 	"github.com/tigrisdata-community/glue/web/discordwebhook"
 	"github.com/tigrisdata-community/glue/web/useragent"
+	"go.opentelemetry.io/otel"
 )
 
 var (
@@ -24,7 +28,11 @@ var (
 	discordUsername   = flag.String("discord-username", "Ty", "Discord pseudo-user username")
 	discordWebhookURL = flag.String("discord-webhook-url", "", "Discord webhook URL")
 	feedURL           = flag.String("feed-url", "https://www.tigrisdata.com/blog/feed.json", "Blog JSONfeed")
-	storeBucket       = flag.String("store-bucket", "", "The Tigris bucket used to store data")
+	leaseTTL          = flag.Duration("lease-ttl", time.Minute, "How long the run lock lasts without being renewed")
+	seenURLTTL        = flag.Duration("seen-url-ttl", 0, "How long to remember posted feed items (0 means forever), must be longer than items stay in the feed")
+	storeBucket       = flag.String("store-bucket", "", "The Tigris bucket used to store data when --store isn't set")
+	storeURL          = flag.String("store", "", "Store URL (s3://bucket/prefix, file:///path, memory://), defaults to s3://<store-bucket>")
+	traceExporter     = flag.String("trace-exporter", "", "Where to send OpenTelemetry traces (stdout, otlp), disabled if empty")
 )
 
 type SeenURL struct {
@@ -42,21 +50,57 @@ func main() {
 		"has-discord-avatar-url", *discordAvatarURL != "",
 		"discord-username", *discordUsername,
 		"has-discord-webhook-url", *discordWebhookURL != "",
+		"seen-url-ttl", (*seenURLTTL).String(),
+		"store", *storeURL,
 		"store-bucket", *storeBucket,
+		"trace-exporter", *traceExporter,
 		"args", flag.Args(),
 	)
 
+	shutdownTracing, err := tracing.Setup(ctx, "discord-rss-webhook", *traceExporter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal: can't set up tracing:", err)
+		return
+	}
+	defer func() {
+		if err := shutdownTracing(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("can't flush traces", "err", err)
+		}
+	}()
+
+	http.DefaultClient.Transport = tracing.Transport(http.DefaultTransport)
+
+	ctx, span := otel.Tracer("discord-rss-webhook").Start(ctx, "run")
+	defer span.End()
+
 	if err := run(ctx); err != nil {
 		fmt.Fprintln(os.Stderr, "fatal:", err)
 	}
 }
 
 func run(ctx context.Context) error {
-	st, err := store.NewS3API(ctx, *storeBucket)
+	st, err := openStore(ctx)
 	if err != nil {
 		return err
 	}
 
+	l, err := lease.Acquire(ctx, st, "discord-rss-webhook", lease.Options{TTL: *leaseTTL})
+	if errors.Is(err, lease.ErrHeld) {
+		slog.Info("another run is in progress, exiting", "err", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't acquire run lock: %w", err)
+	}
+	defer func() {
+		if err := l.Release(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("can't release run lock", "err", err)
+		}
+	}()
+
+	slog.Info("acquired run lock", "token", l.Token())
+	ctx = l.Context()
+
 	seenURLs := &store.JSON[string]{
 		Underlying: st,
 		Prefix:     "seen-urls",
@@ -91,15 +135,18 @@ func run(ctx context.Context) error {
 
 	for _, item := range feed.Items {
 		key := internal.SHA256sum(item.ID)
-		err := seenURLs.Exists(ctx, key)
 
-		if err != nil && !errors.Is(err, store.ErrNotFound) {
-			slog.Error("can't verify item exists in store", "err", err)
+		// Claim the item before posting it so that overlapping runs can't both
+		// post it. ErrConflict means it was already seen or another run has it.
+		err := seenURLs.Create(ctx, key, item.Title)
+
+		if err != nil && !errors.Is(err, store.ErrConflict) {
+			slog.Error("can't claim item in store", "err", err)
 			errs = append(errs, err)
 			continue
 		}
 
-		if err != nil && errors.Is(err, store.ErrNotFound) {
+		if err == nil {
 			// do Discord egress
 
 			req := discordwebhook.Send(*discordWebhookURL, discordwebhook.Webhook{
@@ -111,19 +158,19 @@ func run(ctx context.Context) error {
 			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
 				slog.Error("can't egress discord webhook", "err", err)
-				errs = append(errs, err)
+				errs = append(errs, err, releaseClaim(ctx, seenURLs, key))
 				continue
 			}
 
 			if err := discordwebhook.Validate(resp); err != nil {
 				slog.Error("can't validate discord webhook response", "err", err)
-				errs = append(errs, fmt.Errorf("can't post webhook: %w", err))
+				errs = append(errs, fmt.Errorf("can't post webhook: %w", err), releaseClaim(ctx, seenURLs, key))
 				continue
 			}
 		}
 
 		slog.Info("seen item", "key", key, "title", item.Title, "id", item.ID, "summary", item.Summary)
-		if err := seenURLs.Set(ctx, key, item.Title); err != nil {
+		if err := seenURLs.SetWithTTL(ctx, key, item.Title, *seenURLTTL); err != nil {
 			slog.Error("can't store item info in store", "err", err)
 			errs = append(errs, err)
 			continue
@@ -136,3 +183,23 @@ func run(ctx context.Context) error {
 
 	return nil
 }
+
+// releaseClaim deletes the marker for an item that couldn't be posted so the
+// next run tries again.
+func releaseClaim(ctx context.Context, seenURLs *store.JSON[string], key string) error {
+	if err := seenURLs.Delete(ctx, key); err != nil {
+		slog.Error("can't release claim on item", "key", key, "err", err)
+		return fmt.Errorf("can't release claim on %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func openStore(ctx context.Context) (store.Interface, error) {
+	u := *storeURL
+	if u == "" {
+		u = "s3://" + *storeBucket
+	}
+
+	return store.Open(ctx, u)
+}
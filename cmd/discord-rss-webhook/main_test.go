@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/tigrisdata-community/glue/internal"
+	"github.com/tigrisdata-community/glue/internal/store/s3test"
+	"github.com/tigrisdata-community/glue/web/discordwebhook"
+)
+
+// setFlag sets a flag's value for the rest of the test.
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	old := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = old })
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	bucket := s3test.NewServer(t, "data")
+	t.Setenv("AWS_ACCESS_KEY_ID", s3test.AccessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", s3test.SecretAccessKey)
+	setFlag(t, storeURL, "s3://data/rss?"+url.Values{
+		"endpoint":   {bucket.URL},
+		"region":     {s3test.Region},
+		"path-style": {"true"},
+	}.Encode())
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"version": "https://jsonfeed.org/version/1.1",
+			"title":   "Blog",
+			"items": []map[string]any{
+				{"id": "1", "url": "https://example.com/1", "title": "First"},
+				{"id": "2", "url": "https://example.com/2", "title": "Second"},
+			},
+		})
+	}))
+	t.Cleanup(feed.Close)
+	setFlag(t, feedURL, feed.URL)
+
+	var (
+		lock   sync.Mutex
+		posted []string
+	)
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var wh discordwebhook.Webhook
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &wh); err != nil {
+			t.Errorf("can't decode webhook %q: %v", body, err)
+		}
+
+		lock.Lock()
+		posted = append(posted, wh.Content)
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(discord.Close)
+	setFlag(t, discordWebhookURL, discord.URL)
+
+	// The second run finds both items in the store and posts nothing.
+	for range 2 {
+		if err := run(ctx); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	}
+
+	want := []string{"New blogpost: https://example.com/1", "New blogpost: https://example.com/2"}
+	if !slices.Equal(posted, want) {
+		t.Errorf("posted %q, want %q", posted, want)
+	}
+
+	for _, id := range []string{"1", "2"} {
+		if _, ok := bucket.Object("data", "rss/seen-urls/"+internal.SHA256sum(id)); !ok {
+			t.Errorf("item %s wasn't marked as seen, bucket holds %v", id, bucket.Keys("data"))
+		}
+	}
+}
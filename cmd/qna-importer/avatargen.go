@@ -1,22 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"io"
+	"log/slog"
 	"math/rand"
 	"path"
 	"strconv"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/tigrisdata-community/glue/internal/store"
 	"github.com/tigrisdata-community/glue/web/sdcpp"
-	"github.com/tigrisdata/storage-go"
 )
 
 // SHA256sum computes a cryptographic hash. Still used for proof-of-work challenges
@@ -27,10 +23,11 @@ func SHA256sum(text string) string {
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
+// AvatarGen renders avatars and uploads them as public values so Discord can
+// fetch them. The store must not be encrypted.
 type AvatarGen struct {
 	sd     *sdcpp.Client
-	tigris *storage.Client
-	bucket string
+	store  store.Interface
 	prefix string
 }
 
@@ -54,13 +51,9 @@ func (a *AvatarGen) GenerateAndUpload(ctx context.Context, input string) (string
 
 	key := path.Join(a.prefix, hash+".webp")
 
-	if _, err := a.tigris.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(a.bucket),
-		Key:           aws.String(key),
-		Body:          io.NopCloser(bytes.NewBuffer(data)),
-		ACL:           types.ObjectCannedACLPublicRead,
-		ContentType:   aws.String("image/webp"),
-		ContentLength: aws.Int64(int64(len(data))),
+	if err := store.SetWithOptions(ctx, a.store, key, data, store.SetOptions{
+		ContentType: "image/webp",
+		Public:      true,
 	}); err != nil {
 		return "", fmt.Errorf("can't upload object: %w", err)
 	}
@@ -68,6 +61,18 @@ func (a *AvatarGen) GenerateAndUpload(ctx context.Context, input string) (string
 	return key, nil
 }
 
+// URL returns the public URL of an avatar uploaded by GenerateAndUpload, or an
+// empty string if the store can't make one.
+func (a *AvatarGen) URL(ctx context.Context, key string) string {
+	u, err := store.PublicURL(ctx, a.store, key)
+	if err != nil {
+		slog.Error("can't get avatar URL", "key", key, "err", err)
+		return ""
+	}
+
+	return u
+}
+
 func (a *AvatarGen) hallucinatePrompt(hash string) (string, int) {
 	var sb strings.Builder
 	fmt.Fprint(&sb, "headshot, ")
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+	"github.com/tigrisdata-community/glue/web/sdcpp"
+)
+
+// newTestSDCPP starts a stable-diffusion.cpp stand-in that always renders a
+// blank image.
+func newTestSDCPP(t *testing.T) *sdcpp.Client {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(sdcpp.ImageGenerationResponse{
+			Data:         []sdcpp.ImageData{{B64JSON: base64.StdEncoding.EncodeToString(img.Bytes())}},
+			OutputFormat: "png",
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	return &sdcpp.Client{HTTP: srv.Client(), APIServer: srv.URL}
+}
+
+func TestAvatarGen(t *testing.T) {
+	ctx := context.Background()
+	srv := useS3Test(t)
+
+	avatars, err := store.Open(ctx, *avatarStoreURL)
+	if err != nil {
+		t.Fatalf("store.Open() error = %v", err)
+	}
+
+	a := &AvatarGen{
+		sd:     newTestSDCPP(t),
+		store:  avatars,
+		prefix: "avatars",
+	}
+
+	key, err := a.GenerateAndUpload(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("GenerateAndUpload() error = %v", err)
+	}
+	if want := "avatars/" + SHA256sum("user-1") + ".webp"; key != want {
+		t.Errorf("GenerateAndUpload() key = %q, want %q", key, want)
+	}
+
+	obj, ok := srv.Object("avatars", key)
+	if !ok {
+		t.Fatalf("GenerateAndUpload() didn't upload %s, bucket holds %v", key, srv.Keys("avatars"))
+	}
+	if obj.ACL != "public-read" || obj.ContentType != "image/webp" {
+		t.Errorf("uploaded avatar ACL = %q, content type = %q, want public-read image/webp", obj.ACL, obj.ContentType)
+	}
+
+	u := a.URL(ctx, key)
+	if !strings.HasPrefix(u, srv.URL+"/avatars/") {
+		t.Fatalf("URL() = %q, want it on the avatars bucket", u)
+	}
+
+	// Discord fetches avatars without credentials.
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("http.Get(%s) error = %v", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/webp" {
+		t.Errorf("fetching the avatar = %d %s, want 200 image/webp", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"iter"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -13,26 +14,52 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/go-faker/faker/v4"
 	"github.com/tigrisdata-community/glue/internal/store"
+	"github.com/tigrisdata-community/glue/internal/tracing"
 	"github.com/tigrisdata-community/glue/web/discordwebhook"
 	"github.com/tigrisdata-community/glue/web/sdcpp"
 	"github.com/tigrisdata-community/glue/web/useragent"
-	"github.com/tigrisdata/storage-go"
 )
 
 var (
+	avatarStoreURL    = flag.String("avatar-store", "", "Store URL (s3://bucket/prefix) public avatars are uploaded to, defaults to s3://<store-bucket>; users get no avatars if neither is set")
 	discordToken      = flag.String("discord-token", "", "Discord bot token")
 	discordWebhookURL = flag.String("discord-webhook-url", "", "Discord webhook URL")
 	sdcppURL          = flag.String("sdcpp-url", "", "stable-diffusion.cpp server URL")
+	generatedUserTTL  = flag.Duration("generated-user-ttl", 0, "How long to keep generated Discord users before making new ones (0 means forever)")
 
 	postDelay = flag.Duration("post-delay", 5*time.Second, "delay between post creation attempts")
 )
 
-func discourseImportDiscord(ctx context.Context) error {
-	st, err := store.NewS3API(ctx, *storeBucket)
-	if err != nil {
-		return err
+// importPageSize is how many stored keys the importer reads at once.
+const importPageSize = 100
+
+// pages groups keys into pages of up to size keys as they're listed, so a page
+// can be worked on before the next one is listed.
+func pages(keys iter.Seq2[string, error], size int) iter.Seq2[[]string, error] {
+	return func(yield func([]string, error) bool) {
+		var page []string
+		for key, err := range keys {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			page = append(page, key)
+			if len(page) == size {
+				if !yield(page, nil) {
+					return
+				}
+				page = nil
+			}
+		}
+
+		if len(page) != 0 {
+			yield(page, nil)
+		}
 	}
+}
 
+func discourseImportDiscord(ctx context.Context, st store.Interface) error {
 	discourseThreads := store.JSON[DiscourseQuestion]{
 		Underlying: st,
 		Prefix:     "discourse-thread",
@@ -44,31 +71,36 @@ func discourseImportDiscord(ctx context.Context) error {
 		Prefix:     "discord-thread-mapping",
 	}
 
-	tigris, err := storage.New(ctx)
-	if err != nil {
-		return err
-	}
-
 	ug := &UserGenerator{
 		Storage: store.JSON[FakeUser]{
 			Underlying: st,
 			Prefix:     "discord-generated-usernames",
 		},
-		AvatarGen: &AvatarGen{
+		TTL: *generatedUserTTL,
+	}
+
+	avatars, err := openAvatarStore(ctx)
+	if err != nil {
+		return err
+	}
+	if avatars != nil {
+		ug.AvatarGen = &AvatarGen{
 			sd: &sdcpp.Client{
 				HTTP:      http.DefaultClient,
 				APIServer: *sdcppURL,
 			},
-			tigris: tigris,
-			bucket: *storeBucket,
+			store:  avatars,
 			prefix: "avatars",
-		},
+		}
+	} else {
+		slog.Info("neither --avatar-store nor --store-bucket is set, posting without avatars")
 	}
 
 	dc, err := discordgo.New("Bot " + *discordToken)
 	if err != nil {
 		return fmt.Errorf("can't create discord bot client: %w", err)
 	}
+	dc.Client.Transport = tracing.Transport(http.DefaultTransport)
 
 	if err := dc.Open(); err != nil {
 		return fmt.Errorf("can't open discord connection: %w", err)
@@ -76,11 +108,6 @@ func discourseImportDiscord(ctx context.Context) error {
 
 	defer dc.Close()
 
-	threads, err := discourseThreads.List(ctx, "")
-	if err != nil {
-		return fmt.Errorf("can't list discourse threads: %w", err)
-	}
-
 	u, err := url.Parse(*discordWebhookURL)
 	if err != nil {
 		return fmt.Errorf("discord webhook URL doesn't parse: %w", err)
@@ -88,104 +115,141 @@ func discourseImportDiscord(ctx context.Context) error {
 
 	var errs []error
 
+	var listOpts store.ListOptions
+
 	// // For testing, comment out in prod
-	// threads = append([]string{}, threads[0])
+	// listOpts.Limit = 1
 
 	delayTick := time.NewTicker(*postDelay)
 	defer delayTick.Stop()
 
-	for _, key := range threads {
-		lg := slog.With("key", key)
-		thread, err := discourseThreads.Get(ctx, key)
+	// Read threads and whether they've been imported a page at a time instead
+	// of making two requests per thread.
+	for page, err := range pages(discourseThreads.Iterate(ctx, "", listOpts), importPageSize) {
 		if err != nil {
-			lg.Error("can't fetch thread", "err", err)
-			errs = append(errs, fmt.Errorf("while fetching thread %s: %w", key, err))
-			continue
+			errs = append(errs, fmt.Errorf("can't list discourse threads: %w", err))
+			break
 		}
 
-		if len(thread.Posts) == 0 {
-			lg.Debug("skipping thread with no posts")
-			slog.Info("skipping thread with no posts", "slug", thread.Slug, "title", thread.Title)
-			continue
-		}
+		// Values that can't be decoded are reported per key, so the rest of
+		// the page is still imported.
+		var threadErrs, importedErrs store.KeyErrors
 
-		if discordID, err := discourseToDiscord.Get(ctx, key); err == nil {
-			lg.Info("skipping thread we've already saved", "thread", key, "discord_id", discordID, "err", err)
+		threads, err := discourseThreads.GetMany(ctx, page)
+		if err != nil && !errors.As(err, &threadErrs) {
+			errs = append(errs, fmt.Errorf("can't fetch discourse threads: %w", err))
 			continue
 		}
 
-		op := thread.Posts[0]
-		user := ug.Get(ctx, op.UserID)
-		wh := discordwebhook.Webhook{
-			Content:    op.Body,
-			ThreadName: thread.Title,
-			Username:   user.Username,
-		}
-
-		if user.AvatarKey != "" {
-			wh.AvatarURL = fmt.Sprintf("https://%s.t3.storage.dev/%s", *storeBucket, user.AvatarKey)
-		}
-
-		q := u.Query()
-		q.Del("thread_id")
-		q.Set("wait", "true")
-
-		u.RawQuery = q.Encode()
-
-		<-delayTick.C
-		req := discordwebhook.Send(u.String(), wh)
-		req.Header.Set("User-Agent", useragent.Generate("tigris-gtm-glue", "https://tigrisdata.com"))
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			lg.Error("can't create thread", "err", err)
-			errs = append(errs, fmt.Errorf("can't create thread %s: %w", key, err))
-			continue
-		}
-		tcr, err := discordwebhook.ParseThreadCreation(resp)
-		if err != nil {
-			lg.Error("can't parse thread creation response", "err", err)
-			errs = append(errs, fmt.Errorf("can't parse thread creation response for %s: %w", key, err))
+		imported, err := discourseToDiscord.GetMany(ctx, page)
+		if err != nil && !errors.As(err, &importedErrs) {
+			errs = append(errs, fmt.Errorf("can't fetch discord thread mappings: %w", err))
 			continue
 		}
 
-		discourseToDiscord.Set(ctx, key, tcr.ChannelID)
+		for _, key := range page {
+			lg := slog.With("key", key)
 
-		q = u.Query()
-		q.Del("wait")
-		q.Set("thread_id", tcr.ChannelID)
+			if err := threadErrs[key]; err != nil {
+				lg.Error("can't decode thread", "err", err)
+				errs = append(errs, fmt.Errorf("can't fetch discourse thread %s: %w", key, err))
+				continue
+			}
 
-		u.RawQuery = q.Encode()
+			// Without the mapping there's no telling if the thread was
+			// already posted, so don't post it again.
+			if err := importedErrs[key]; err != nil {
+				lg.Error("can't decode discord thread mapping", "err", err)
+				errs = append(errs, fmt.Errorf("can't fetch discord thread mapping for %s: %w", key, err))
+				continue
+			}
 
-		whurl := u.String()
+			thread, ok := threads[key]
+			if !ok {
+				lg.Error("thread was deleted while importing")
+				continue
+			}
 
-		slog.Info("created discord forum thread", "slug", thread.Slug, "title", thread.Title, "id", tcr.ChannelID)
+			if len(thread.Posts) == 0 {
+				lg.Debug("skipping thread with no posts")
+				slog.Info("skipping thread with no posts", "slug", thread.Slug, "title", thread.Title)
+				continue
+			}
 
-		for i, post := range thread.Posts {
-			if i == 0 {
+			if discordID, ok := imported[key]; ok {
+				lg.Info("skipping thread we've already saved", "thread", key, "discord_id", discordID)
 				continue
 			}
 
-			user := ug.Get(ctx, post.UserID)
+			op := thread.Posts[0]
+			user := ug.Get(ctx, op.UserID)
 			wh := discordwebhook.Webhook{
-				Content:  post.Body,
-				Username: user.Username,
+				Content:    op.Body,
+				ThreadName: thread.Title,
+				Username:   user.Username,
 			}
-			slog.Info("got user", "user", user)
 
-			if user.AvatarKey != "" {
-				wh.AvatarURL = fmt.Sprintf("https://%s.t3.storage.dev/%s", *storeBucket, user.AvatarKey)
-			}
+			wh.AvatarURL = ug.AvatarURL(ctx, user)
+
+			q := u.Query()
+			q.Del("thread_id")
+			q.Set("wait", "true")
+
+			u.RawQuery = q.Encode()
 
 			<-delayTick.C
-			req := discordwebhook.Send(whurl, wh)
+			req := discordwebhook.Send(u.String(), wh)
 			req.Header.Set("User-Agent", useragent.Generate("tigris-gtm-glue", "https://tigrisdata.com"))
 			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
-				errs = append(errs, fmt.Errorf("can't send %s %dth reply: %w", key, i, err))
+				lg.Error("can't create thread", "err", err)
+				errs = append(errs, fmt.Errorf("can't create thread %s: %w", key, err))
+				continue
+			}
+			tcr, err := discordwebhook.ParseThreadCreation(resp)
+			if err != nil {
+				lg.Error("can't parse thread creation response", "err", err)
+				errs = append(errs, fmt.Errorf("can't parse thread creation response for %s: %w", key, err))
+				continue
 			}
 
-			if err := discordwebhook.Validate(resp); err != nil {
-				errs = append(errs, fmt.Errorf("can't post webhook: %w", err))
+			discourseToDiscord.Set(ctx, key, tcr.ChannelID)
+
+			q = u.Query()
+			q.Del("wait")
+			q.Set("thread_id", tcr.ChannelID)
+
+			u.RawQuery = q.Encode()
+
+			whurl := u.String()
+
+			slog.Info("created discord forum thread", "slug", thread.Slug, "title", thread.Title, "id", tcr.ChannelID)
+
+			for i, post := range thread.Posts {
+				if i == 0 {
+					continue
+				}
+
+				user := ug.Get(ctx, post.UserID)
+				wh := discordwebhook.Webhook{
+					Content:  post.Body,
+					Username: user.Username,
+				}
+				slog.Info("got user", "user", user)
+
+				wh.AvatarURL = ug.AvatarURL(ctx, user)
+
+				<-delayTick.C
+				req := discordwebhook.Send(whurl, wh)
+				req.Header.Set("User-Agent", useragent.Generate("tigris-gtm-glue", "https://tigrisdata.com"))
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("can't send %s %dth reply: %w", key, i, err))
+				}
+
+				if err := discordwebhook.Validate(resp); err != nil {
+					errs = append(errs, fmt.Errorf("can't post webhook: %w", err))
+				}
 			}
 		}
 	}
@@ -197,9 +261,42 @@ func discourseImportDiscord(ctx context.Context) error {
 	return nil
 }
 
+// openAvatarStore opens the store avatars are uploaded to, or returns nil if
+// there isn't one. Avatars have to be readable by Discord, so they go to their
+// own store that's never encrypted.
+func openAvatarStore(ctx context.Context) (store.Interface, error) {
+	u := *avatarStoreURL
+	if u == "" && *storeBucket != "" {
+		u = "s3://" + *storeBucket
+	}
+	if u == "" {
+		return nil, nil
+	}
+
+	avatars, err := store.Open(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("can't open avatar store: %w", err)
+	}
+
+	return avatars, nil
+}
+
+// UserGenerator makes up users to post as. AvatarGen is optional, without it
+// users don't get avatars.
 type UserGenerator struct {
 	Storage   store.JSON[FakeUser]
 	AvatarGen *AvatarGen
+	TTL       time.Duration
+}
+
+// AvatarURL returns the URL of user's avatar, or an empty string if they don't
+// have one.
+func (ug *UserGenerator) AvatarURL(ctx context.Context, user FakeUser) string {
+	if ug.AvatarGen == nil || user.AvatarKey == "" {
+		return ""
+	}
+
+	return ug.AvatarGen.URL(ctx, user.AvatarKey)
 }
 
 func (ug *UserGenerator) Get(ctx context.Context, key string) FakeUser {
@@ -212,14 +309,16 @@ func (ug *UserGenerator) Get(ctx context.Context, key string) FakeUser {
 			Username:  faker.Name(),
 		}
 
-		avatarKey, err := ug.AvatarGen.GenerateAndUpload(ctx, key)
-		if err != nil {
-			slog.Error("can't render and upload avatar", "err", err)
-		} else {
-			result.AvatarKey = avatarKey
+		if ug.AvatarGen != nil {
+			avatarKey, err := ug.AvatarGen.GenerateAndUpload(ctx, key)
+			if err != nil {
+				slog.Error("can't render and upload avatar", "err", err)
+			} else {
+				result.AvatarKey = avatarKey
+			}
 		}
 
-		ug.Storage.Set(ctx, key, result)
+		ug.Storage.SetWithTTL(ctx, key, result, ug.TTL)
 	}
 
 	return result
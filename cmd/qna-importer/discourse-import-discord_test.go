@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"testing"
+)
+
+// listKeys yields keys and then err, if it isn't nil.
+func listKeys(keys []string, err error) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for _, key := range keys {
+			if !yield(key, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield("", err)
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	errList := errors.New("can't list")
+
+	tests := []struct {
+		name    string
+		keys    []string
+		err     error
+		want    string
+		wantErr error
+	}{
+		{name: "no keys", want: "[]"},
+		{name: "partial page", keys: []string{"a"}, want: "[[a]]"},
+		{name: "whole pages", keys: []string{"a", "b", "c", "d"}, want: "[[a b] [c d]]"},
+		{name: "last page", keys: []string{"a", "b", "c"}, want: "[[a b] [c]]"},
+		{name: "error", keys: []string{"a", "b", "c"}, err: errList, want: "[[a b]]", wantErr: errList},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := [][]string{}
+			var err error
+			for page, perr := range pages(listKeys(tt.keys, tt.err), 2) {
+				if perr != nil {
+					err = perr
+					break
+				}
+				got = append(got, page)
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("pages() error = %v, want %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("pages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAvatarStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		avatarStore string
+		storeBucket string
+		want        bool
+	}{
+		{name: "avatar store", avatarStore: "memory://", want: true},
+		{name: "no avatar store or bucket", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, avatarStoreURL, tt.avatarStore)
+			setFlag(t, storeBucket, tt.storeBucket)
+
+			avatars, err := openAvatarStore(context.Background())
+			if err != nil {
+				t.Fatalf("openAvatarStore() error = %v", err)
+			}
+			if got := avatars != nil; got != tt.want {
+				t.Errorf("openAvatarStore() opened a store = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserGeneratorAvatarURL(t *testing.T) {
+	ug := &UserGenerator{}
+	if got := ug.AvatarURL(context.Background(), FakeUser{AvatarKey: "avatars/1.webp"}); got != "" {
+		t.Errorf("AvatarURL() without an avatar store = %q, want \"\"", got)
+	}
+}
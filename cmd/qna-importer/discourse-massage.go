@@ -29,20 +29,28 @@ type DiscoursePost struct {
 	Accepted bool   `json:"accepted"`
 }
 
-func discourseMassage(ctx context.Context) error {
-	st, err := store.NewS3API(ctx, *storeBucket)
-	if err != nil {
-		return err
-	}
-
-	discourseTopics := store.JSON[discourse.TopicResult]{
+// openDiscourseThreads opens the anonymized threads discourse-massage writes,
+// keeping the previous few runs' output of each so a bad run can be rolled
+// back.
+func openDiscourseThreads(st store.Interface) (*store.Versioned[DiscourseQuestion], error) {
+	return store.NewVersioned(&store.JSON[DiscourseQuestion]{
 		Underlying: st,
-		Prefix:     "discourse",
+		Prefix:     "discourse-thread",
+	}, store.VersionedOptions{})
+}
+
+func discourseMassage(ctx context.Context, st store.Interface) error {
+	// Topic dumps are large and mostly text, so they're compressed. Dumps
+	// written as plain JSON before this are still readable.
+	discourseTopics := store.Typed[discourse.TopicResult]{
+		Underlying:  st,
+		Prefix:      "discourse",
+		Compression: store.CompressionZstd,
 	}
 
-	discourseThreads := store.JSON[DiscourseQuestion]{
-		Underlying: st,
-		Prefix:     "discourse-thread",
+	discourseThreads, err := openDiscourseThreads(st)
+	if err != nil {
+		return err
 	}
 
 	ai := openai.NewClient(
@@ -51,55 +59,69 @@ func discourseMassage(ctx context.Context) error {
 	)
 	_ = ai
 
-	keys, err := discourseTopics.List(ctx, "")
-	if err != nil {
-		return fmt.Errorf("can't list cached topics: %w", err)
-	}
-
 	var errs []error
 
-	for _, k := range keys {
-		fmt.Println(k)
-
-		topic, err := discourseTopics.Get(ctx, k)
+	for page, err := range pages(discourseTopics.Iterate(ctx, "", store.ListOptions{}), importPageSize) {
 		if err != nil {
-			errs = append(errs, fmt.Errorf("while fetching %s: %w", k, err))
-			continue
+			errs = append(errs, fmt.Errorf("can't list cached topics: %w", err))
+			break
 		}
 
-		thread := DiscourseQuestion{
-			Title: topic.Title,
-			Slug:  k,
+		var topicErrs store.KeyErrors
+		topics, err := discourseTopics.GetMany(ctx, page)
+		if err != nil && !errors.As(err, &topicErrs) {
+			errs = append(errs, fmt.Errorf("while fetching topics: %w", err))
+			continue
 		}
 
-		for i, post := range topic.PostStream.Posts {
-			if post.Username == "system" {
+		for _, k := range page {
+			fmt.Println(k)
+
+			if err := topicErrs[k]; err != nil {
+				errs = append(errs, fmt.Errorf("while fetching %s: %w", k, err))
 				continue
 			}
 
-			params := openai.ChatCompletionNewParams{
-				Messages: []openai.ChatCompletionMessageParamUnion{
-					openai.SystemMessage(cleanupSystemPrompt),
-					openai.UserMessage(post.Cooked),
-				},
+			topic, ok := topics[k]
+			if !ok {
+				errs = append(errs, fmt.Errorf("while fetching %s: %w", k, store.ErrNotFound))
+				continue
 			}
 
-			resp, err := ai.Chat.Completions.New(ctx, params)
-			if err != nil {
-				errs = append(errs, fmt.Errorf("while censoring the %d message in %s: %w", i, k, err))
-				continue
+			thread := DiscourseQuestion{
+				Title: topic.Title,
+				Slug:  k,
 			}
 
-			thread.Posts = append(thread.Posts, DiscoursePost{
-				Body:     resp.Choices[0].Message.Content,
-				UserID:   fmt.Sprint(post.UserTitle, " ", post.UserID),
-				Accepted: post.AcceptedAnswer,
-			})
-		}
+			for i, post := range topic.PostStream.Posts {
+				if post.Username == "system" {
+					continue
+				}
+
+				params := openai.ChatCompletionNewParams{
+					Messages: []openai.ChatCompletionMessageParamUnion{
+						openai.SystemMessage(cleanupSystemPrompt),
+						openai.UserMessage(post.Cooked),
+					},
+				}
+
+				resp, err := ai.Chat.Completions.New(ctx, params)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("while censoring the %d message in %s: %w", i, k, err))
+					continue
+				}
+
+				thread.Posts = append(thread.Posts, DiscoursePost{
+					Body:     resp.Choices[0].Message.Content,
+					UserID:   fmt.Sprint(post.UserTitle, " ", post.UserID),
+					Accepted: post.AcceptedAnswer,
+				})
+			}
 
-		if err := discourseThreads.Set(ctx, k, thread); err != nil {
-			errs = append(errs, fmt.Errorf("while setting thread for %s: %w", k, err))
-			continue
+			if _, err := discourseThreads.Set(ctx, k, thread, "discourse-massage with "+*openAIModel); err != nil {
+				errs = append(errs, fmt.Errorf("while setting thread for %s: %w", k, err))
+				continue
+			}
 		}
 	}
 
@@ -8,16 +8,13 @@ import (
 	"github.com/tigrisdata-community/glue/web/discourse"
 )
 
-func discourseScrape(ctx context.Context) error {
-	st, err := store.NewS3API(ctx, *storeBucket)
-	if err != nil {
-		return err
-	}
-	_ = st
-
-	discourseTopics := store.JSON[discourse.TopicResult]{
-		Underlying: st,
-		Prefix:     "discourse",
+func discourseScrape(ctx context.Context, st store.Interface) error {
+	// Topic dumps are large and mostly text, so they're compressed. Dumps
+	// written as plain JSON before this are still readable.
+	discourseTopics := store.Typed[discourse.TopicResult]{
+		Underlying:  st,
+		Prefix:      "discourse",
+		Compression: store.CompressionZstd,
 	}
 
 	catr, err := discourse.GetCategoryAndTag(ctx, *discourseURL+*discourseTagURL)
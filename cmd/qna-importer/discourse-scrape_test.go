@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+	"github.com/tigrisdata-community/glue/web/discourse"
+)
+
+func TestDiscourseScrape(t *testing.T) {
+	ctx := context.Background()
+	useS3Test(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tags/tigris.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"topic_list": map[string]any{
+				"topics": []map[string]any{{"id": 1, "slug": "hello", "title": "Hello"}},
+			},
+		})
+	})
+	mux.HandleFunc("GET /t/hello/1.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"title": "Hello",
+			"post_stream": map[string]any{
+				"posts": []map[string]any{{"username": "someone", "cooked": "<p>How do I use buckets?</p>"}},
+			},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	setFlag(t, discourseURL, srv.URL)
+	setFlag(t, discourseTagURL, "/tags/tigris.json")
+
+	st, err := openStore(ctx)
+	if err != nil {
+		t.Fatalf("openStore() error = %v", err)
+	}
+
+	if err := discourseScrape(ctx, st); err != nil {
+		t.Fatalf("discourseScrape() error = %v", err)
+	}
+
+	topics := store.Typed[discourse.TopicResult]{
+		Underlying:  st,
+		Prefix:      "discourse",
+		Compression: store.CompressionZstd,
+	}
+
+	topic, err := topics.Get(ctx, "1-hello")
+	if err != nil {
+		t.Fatalf("Get() of the scraped topic error = %v", err)
+	}
+	if topic.Title != "Hello" || len(topic.PostStream.Posts) != 1 {
+		t.Errorf("scraped topic = %q with %d posts, want %q with 1 post", topic.Title, len(topic.PostStream.Posts), "Hello")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+)
+
+// discourseThreadHistory logs the revisions kept of an anonymized thread,
+// newest first, along with what each one changed from the one before it, eg:
+// qna-importer discourse-thread-history some-topic-slug.
+func discourseThreadHistory(ctx context.Context, st store.Interface, key string) error {
+	if key == "" {
+		return errors.New("usage: discourse-thread-history <key>")
+	}
+
+	threads, err := openDiscourseThreads(st)
+	if err != nil {
+		return err
+	}
+
+	history, err := threads.History(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for i, rev := range history {
+		args := []any{"key", key, "revision", rev.ID, "time", rev.Time, "note", rev.Note}
+
+		if i+1 < len(history) {
+			changes, err := store.Diff(history[i+1].Value, rev.Value)
+			if err != nil {
+				return fmt.Errorf("can't compare revision %d of %s: %w", rev.ID, key, err)
+			}
+
+			paths := make([]string, len(changes))
+			for j, c := range changes {
+				paths[j] = fmt.Sprint(c.Op, " ", c.Path)
+			}
+			args = append(args, "changes", paths)
+		}
+
+		slog.Info("thread revision", args...)
+	}
+
+	return nil
+}
+
+// discourseThreadRollback makes an earlier revision of an anonymized thread
+// the current one again, eg: qna-importer discourse-thread-rollback
+// some-topic-slug 3.
+func discourseThreadRollback(ctx context.Context, st store.Interface, key, revision string) error {
+	id, err := strconv.Atoi(revision)
+	if key == "" || err != nil {
+		return errors.New("usage: discourse-thread-rollback <key> <revision>")
+	}
+
+	threads, err := openDiscourseThreads(st)
+	if err != nil {
+		return err
+	}
+
+	newID, err := threads.Rollback(ctx, key, id, "")
+	if err != nil {
+		return err
+	}
+
+	slog.Info("rolled back thread", "key", key, "to", id, "revision", newID)
+	return nil
+}
@@ -4,26 +4,72 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
+	"time"
 
 	"github.com/facebookgo/flagenv"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/tigrisdata-community/glue/internal/lease"
+	"github.com/tigrisdata-community/glue/internal/store"
+	"github.com/tigrisdata-community/glue/internal/tracing"
+	"go.opentelemetry.io/otel"
 )
 
 var (
 	discourseURL    = flag.String("discourse-url", "https://community.fly.io", "Base Discourse URL")
 	discourseTagURL = flag.String("discourse-tag-url", "/tags/c/questions-and-help/11/tigris.json", "Discourse server URL")
+	leaseTTL        = flag.Duration("lease-ttl", time.Minute, "How long the run lock lasts without being renewed")
 	openAIAPIBase   = flag.String("openai-api-base", "", "OpenAI API base URL")
 	openAIAPIKey    = flag.String("openai-api-key", "", "OpenAI API key")
 	openAIModel     = flag.String("openai-model", "gpt-oss-120b", "OpenAI model")
-	storeBucket     = flag.String("store-bucket", "", "The Tigris bucket used to store data")
+	storeAllowPlain = flag.Bool("store-allow-plaintext", false, "Read stored values that aren't encrypted yet when --store-keys is set")
+	storeBucket     = flag.String("store-bucket", "", "The Tigris bucket used to store data when --store isn't set")
+	storeKeys       = flag.String("store-keys", "", "Comma-separated id:base64-key AES keys to encrypt stored values with, newest first")
+	storeSyncDryRun = flag.Bool("store-sync-dry-run", false, "Only report what sync-store would copy instead of copying it")
+	storeURL        = flag.String("store", "", "Store URL (s3://bucket/prefix, file:///path, memory://), defaults to s3://<store-bucket>")
+	traceExporter   = flag.String("trace-exporter", "", "Where to send OpenTelemetry traces (stdout, otlp), disabled if empty")
 )
 
+// commands are the subcommands, named by the first argument.
+var commands = map[string]func(ctx context.Context, st store.Interface) error{
+	"discourse-scrape":         discourseScrape,
+	"discourse-massage":        discourseMassage,
+	"discourse-import-discord": discourseImportDiscord,
+	"discourse-thread-history": func(ctx context.Context, st store.Interface) error {
+		return discourseThreadHistory(ctx, st, flag.Arg(1))
+	},
+	"discourse-thread-rollback": func(ctx context.Context, st store.Interface) error {
+		return discourseThreadRollback(ctx, st, flag.Arg(1), flag.Arg(2))
+	},
+	"move-store-prefix": func(ctx context.Context, st store.Interface) error {
+		return moveStorePrefix(ctx, st, flag.Arg(1), flag.Arg(2))
+	},
+	"sync-store": func(ctx context.Context, st store.Interface) error {
+		return syncStore(ctx, st, flag.Arg(1), *storeSyncDryRun)
+	},
+	"rotate-store-keys": rotateStoreKeys,
+}
+
 func main() {
 	flagenv.Parse()
 	flag.Parse()
 
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		log.Fatalf("ERROR unknown command: %q", flag.Arg(0))
+	}
+
+	// Exit only once run has returned, so the run lock is released and traces
+	// are flushed even when the command fails.
+	if err := run(command); err != nil {
+		log.Fatal("error: ", err)
+	}
+}
+
+func run(command func(ctx context.Context, st store.Interface) error) error {
 	ctx, cancel := context.WithCancelCause(context.Background())
 	defer cancel(errors.New("main exited"))
 
@@ -36,28 +82,150 @@ func main() {
 		"openai-api-base", *openAIAPIBase,
 		"has-openai-api-key", *openAIAPIKey != "",
 		"openai-model", *openAIModel,
+		"store", *storeURL,
 		"store-bucket", *storeBucket,
+		"has-store-keys", *storeKeys != "",
+		"store-allow-plaintext", *storeAllowPlain,
+		"store-sync-dry-run", *storeSyncDryRun,
+		"generated-user-ttl", (*generatedUserTTL).String(),
 		"post-delay", (*postDelay).String(),
+		"trace-exporter", *traceExporter,
 		"args", flag.Args(),
 	)
 
-	switch flag.Arg(0) {
-	case "discourse-scrape":
-		if err := discourseScrape(ctx); err != nil {
-			log.Fatal("error:", err)
+	shutdownTracing, err := tracing.Setup(ctx, "qna-importer", *traceExporter)
+	if err != nil {
+		return fmt.Errorf("can't set up tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("can't flush traces", "err", err)
 		}
+	}()
 
-	case "discourse-massage":
-		if err := discourseMassage(ctx); err != nil {
-			log.Fatal("error:", err)
-		}
+	http.DefaultClient.Transport = tracing.Transport(http.DefaultTransport)
+
+	ctx, span := otel.Tracer("qna-importer").Start(ctx, flag.Arg(0))
+	defer span.End()
 
-	case "discourse-import-discord":
-		if err := discourseImportDiscord(ctx); err != nil {
-			log.Fatal("error:", err)
+	st, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	l, err := lease.Acquire(ctx, st, "qna-importer", lease.Options{TTL: *leaseTTL})
+	if errors.Is(err, lease.ErrHeld) {
+		slog.Info("another run is in progress, exiting", "err", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't acquire run lock: %w", err)
+	}
+	defer func() {
+		if err := l.Release(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("can't release run lock", "err", err)
 		}
+	}()
 
-	default:
-		log.Fatalf("ERROR unknown command: %q", flag.Arg(0))
+	slog.Info("acquired run lock", "token", l.Token())
+
+	return command(l.Context(), st)
+}
+
+func openStore(ctx context.Context) (store.Interface, error) {
+	u := *storeURL
+	if u == "" {
+		u = "s3://" + *storeBucket
+	}
+
+	return openStoreURL(ctx, u)
+}
+
+// openStoreURL opens the store at u, encrypting it with --store-keys if set.
+func openStoreURL(ctx context.Context, u string) (store.Interface, error) {
+	st, err := store.Open(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if *storeKeys == "" {
+		return st, nil
+	}
+
+	keys, err := store.ParseEncryptionKeys(*storeKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.NewEncrypted(st, store.EncryptedOptions{
+		Keys:           keys,
+		AllowPlaintext: *storeAllowPlain,
+	})
+}
+
+// rotateStoreKeys re-encrypts every stored value with the newest key in
+// --store-keys, including values that aren't encrypted yet.
+func rotateStoreKeys(ctx context.Context, st store.Interface) error {
+	enc, ok := st.(*store.Encrypted)
+	if !ok {
+		return errors.New("--store-keys must be set to rotate keys")
+	}
+
+	n, err := enc.Rotate(ctx, "")
+	slog.Info("rotated store keys", "values", n)
+	return err
+}
+
+// moveStorePrefix moves every stored value under one prefix to another, eg:
+// qna-importer move-store-prefix discourse/ forums/fly/discourse/. Values are
+// copied server-side where the store supports it.
+func moveStorePrefix(ctx context.Context, st store.Interface, from, to string) error {
+	if from == "" || to == "" {
+		return errors.New("usage: move-store-prefix <from> <to>")
+	}
+
+	n, err := store.MovePrefix(ctx, st, from, to, store.CopyOptions{
+		Progress: func(p store.CopyProgress) {
+			slog.Info("moving stored values", "values", p.Keys, "last-key", p.LastKey)
+		},
+	})
+	slog.Info("moved stored values", "from", from, "to", to, "values", n)
+	return err
+}
+
+// syncStore copies every stored value that is missing or different in the
+// store at dstURL there, eg: qna-importer sync-store s3://new-bucket/qna, so a
+// new store can be filled before --store is pointed at it. The destination is
+// encrypted with --store-keys too. Keys only in the destination are reported
+// but kept.
+func syncStore(ctx context.Context, st store.Interface, dstURL string, dryRun bool) error {
+	if dstURL == "" {
+		return errors.New("usage: sync-store <store-url>")
 	}
+
+	dst, err := openStoreURL(ctx, dstURL)
+	if err != nil {
+		return err
+	}
+
+	report, err := store.Sync(ctx, st, dst, store.SyncOptions{
+		DryRun: dryRun,
+		OnDivergence: func(key string, d store.Divergence) {
+			slog.Debug("stored value diverged", "key", key, "divergence", d)
+		},
+		Progress: func(r store.SyncReport) {
+			slog.Info("syncing stored values", "checked", r.Checked, "copied", r.Copied)
+		},
+	})
+	slog.Info(
+		"synced stored values",
+		"dry-run", dryRun,
+		"checked", report.Checked,
+		"missing", report.Missing,
+		"different", report.Different,
+		"extra", report.Extra,
+		"copied", report.Copied,
+		"skipped", report.Skipped,
+	)
+	return err
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+	"github.com/tigrisdata-community/glue/internal/store/s3test"
+)
+
+// setFlag sets a flag's value for the rest of the test.
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	old := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = old })
+}
+
+// useS3Test points --store at the qna prefix of the data bucket and
+// --avatar-store at the avatars bucket of an s3test server for the rest of the
+// test.
+func useS3Test(t *testing.T) *s3test.Server {
+	t.Helper()
+
+	srv := s3test.NewServer(t, "data", "avatars")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", s3test.AccessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", s3test.SecretAccessKey)
+
+	query := url.Values{
+		"endpoint":   {srv.URL},
+		"region":     {s3test.Region},
+		"path-style": {"true"},
+	}.Encode()
+	setFlag(t, storeURL, "s3://data/qna?"+query)
+	setFlag(t, avatarStoreURL, "s3://avatars?"+query)
+
+	return srv
+}
+
+func TestOpenStore(t *testing.T) {
+	ctx := context.Background()
+	srv := useS3Test(t)
+	setFlag(t, storeKeys, "test:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+
+	st, err := openStore(ctx)
+	if err != nil {
+		t.Fatalf("openStore() error = %v", err)
+	}
+
+	if err := st.Set(ctx, "secret", []byte("plaintext")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	obj, ok := srv.Object("data", "qna/secret")
+	if !ok {
+		t.Fatalf("Set() didn't write qna/secret, bucket holds %v", srv.Keys("data"))
+	}
+	if bytes.Contains(obj.Body, []byte("plaintext")) {
+		t.Errorf("stored value %q isn't encrypted", obj.Body)
+	}
+
+	got, err := st.Get(ctx, "secret")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "plaintext" {
+		t.Errorf("Get() = %q, want %q", got, "plaintext")
+	}
+
+	if _, ok := st.(*store.Encrypted); !ok {
+		t.Errorf("openStore() = %T, want *store.Encrypted", st)
+	}
+}
+
+func TestMoveStorePrefix(t *testing.T) {
+	ctx := context.Background()
+	srv := useS3Test(t)
+
+	st, err := openStore(ctx)
+	if err != nil {
+		t.Fatalf("openStore() error = %v", err)
+	}
+
+	for _, key := range []string{"discourse/1", "discourse/2", "discourse-thread/1"} {
+		if err := st.Set(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+
+	if err := moveStorePrefix(ctx, st, "discourse/", "forums/fly/discourse/"); err != nil {
+		t.Fatalf("moveStorePrefix() error = %v", err)
+	}
+
+	want := []string{"qna/discourse-thread/1", "qna/forums/fly/discourse/1", "qna/forums/fly/discourse/2"}
+	if got := srv.Keys("data"); !slices.Equal(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+
+	if err := moveStorePrefix(ctx, st, "", "elsewhere/"); err == nil {
+		t.Error("moveStorePrefix() without a source prefix succeeded")
+	}
+}
+
+func TestSyncStore(t *testing.T) {
+	ctx := context.Background()
+	srv := useS3Test(t)
+
+	st, err := openStore(ctx)
+	if err != nil {
+		t.Fatalf("openStore() error = %v", err)
+	}
+
+	for _, key := range []string{"discourse/1", "discourse/2"} {
+		if err := st.Set(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+
+	dstURL := strings.Replace(*storeURL, "s3://data/qna", "s3://data/migrated", 1)
+
+	if err := syncStore(ctx, st, dstURL, true); err != nil {
+		t.Fatalf("syncStore() dry run error = %v", err)
+	}
+	if got := srv.Keys("data"); !slices.Equal(got, []string{"qna/discourse/1", "qna/discourse/2"}) {
+		t.Errorf("bucket holds %v after a dry run, want only the source's values", got)
+	}
+
+	if err := syncStore(ctx, st, dstURL, false); err != nil {
+		t.Fatalf("syncStore() error = %v", err)
+	}
+	want := []string{"migrated/discourse/1", "migrated/discourse/2", "qna/discourse/1", "qna/discourse/2"}
+	if got := srv.Keys("data"); !slices.Equal(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+
+	if err := syncStore(ctx, st, "", false); err == nil {
+		t.Error("syncStore() without a destination succeeded")
+	}
+}
+
+func TestDiscourseThreadRollback(t *testing.T) {
+	ctx := context.Background()
+	useS3Test(t)
+
+	st, err := openStore(ctx)
+	if err != nil {
+		t.Fatalf("openStore() error = %v", err)
+	}
+
+	threads, err := openDiscourseThreads(st)
+	if err != nil {
+		t.Fatalf("openDiscourseThreads() error = %v", err)
+	}
+
+	for _, title := range []string{"first run", "bad run"} {
+		if _, err := threads.Set(ctx, "topic", DiscourseQuestion{Title: title}, ""); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	if err := discourseThreadHistory(ctx, st, "topic"); err != nil {
+		t.Fatalf("discourseThreadHistory() error = %v", err)
+	}
+
+	if err := discourseThreadRollback(ctx, st, "topic", "1"); err != nil {
+		t.Fatalf("discourseThreadRollback() error = %v", err)
+	}
+
+	// The importer reads threads without their history.
+	plain := store.JSON[DiscourseQuestion]{Underlying: st, Prefix: "discourse-thread"}
+	if got, err := plain.Get(ctx, "topic"); err != nil || got.Title != "first run" {
+		t.Errorf("Get() = %+v, %v, want the first run's thread", got, err)
+	}
+
+	if err := discourseThreadRollback(ctx, st, "topic", "latest"); err == nil {
+		t.Error("discourseThreadRollback() with a bad revision succeeded")
+	}
+}
@@ -0,0 +1,265 @@
+// Package lease implements named, expiring locks on top of a store.Interface so
+// that overlapping runs of the same command can tell that one of them is
+// already doing the work.
+//
+// Leases need a store that supports conditional writes (store.ConditionalSetter).
+// Every time a lease changes hands its fencing token goes up by one, so work
+// done under an older token can be told apart from work done under a newer one.
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+)
+
+var (
+	// ErrHeld is returned by Acquire when another owner holds an unexpired lease.
+	ErrHeld = errors.New("lease: held by another owner")
+
+	// ErrLost is the cause of a lease's context being canceled when it could not
+	// be renewed before it expired or another owner took it over.
+	ErrLost = errors.New("lease: lost")
+
+	// ErrReleased is the cause of a lease's context being canceled by Release.
+	ErrReleased = errors.New("lease: released")
+)
+
+// Prefix is the store prefix that lease records are kept under.
+const Prefix = "leases"
+
+// Record is the value stored for each lease.
+type Record struct {
+	Owner   string    `json:"owner"`
+	Token   uint64    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Options configure how a lease is acquired and renewed.
+type Options struct {
+	// TTL is how long the lease lasts without being renewed. Defaults to one
+	// minute.
+	TTL time.Duration
+
+	// RenewInterval is how often the lease is renewed in the background.
+	// Defaults to a third of TTL.
+	RenewInterval time.Duration
+
+	// Owner identifies the holder of the lease in its record. Defaults to the
+	// hostname and process ID.
+	Owner string
+
+	now func() time.Time
+}
+
+func (o *Options) setDefaults() {
+	if o.TTL <= 0 {
+		o.TTL = time.Minute
+	}
+
+	if o.RenewInterval <= 0 {
+		o.RenewInterval = o.TTL / 3
+	}
+
+	if o.Owner == "" {
+		hostname, _ := os.Hostname()
+		o.Owner = hostname + "/" + strconv.Itoa(os.Getpid())
+	}
+
+	if o.now == nil {
+		o.now = time.Now
+	}
+}
+
+// Lease is a held lock. It is renewed in the background until Release is
+// called or it is lost.
+type Lease struct {
+	st   store.Interface
+	key  string
+	opts Options
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	lock    sync.Mutex
+	record  Record
+	version string
+}
+
+// Acquire takes the lease called name if nobody else holds it, returning
+// ErrHeld if somebody does. The returned lease is renewed in the background
+// until Release is called.
+func Acquire(ctx context.Context, st store.Interface, name string, opts Options) (*Lease, error) {
+	opts.setDefaults()
+	key := Prefix + "/" + name
+
+	var (
+		rec  Record
+		cond store.Condition
+	)
+
+	data, version, err := store.GetVersion(ctx, st, key)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		cond.IfNotExists = true
+	case err != nil:
+		return nil, fmt.Errorf("can't read lease %s: %w", name, err)
+	default:
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("can't read lease %s: %w: %w", name, store.ErrCantDecode, err)
+		}
+
+		if rec.Owner != opts.Owner && rec.Expires.After(opts.now()) {
+			return nil, fmt.Errorf("%w: %s is held by %s until %s", ErrHeld, name, rec.Owner, rec.Expires.Format(time.RFC3339))
+		}
+
+		cond.IfVersion = version
+	}
+
+	rec = Record{
+		Owner:   opts.Owner,
+		Token:   rec.Token + 1,
+		Expires: opts.now().Add(opts.TTL),
+	}
+
+	version, err = write(ctx, st, key, rec, cond)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) || errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s was taken while acquiring it", ErrHeld, name)
+		}
+		return nil, fmt.Errorf("can't acquire lease %s: %w", name, err)
+	}
+
+	lctx, cancel := context.WithCancelCause(ctx)
+
+	l := &Lease{
+		st:      st,
+		key:     key,
+		opts:    opts,
+		ctx:     lctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		record:  rec,
+		version: version,
+	}
+
+	go l.renewLoop()
+
+	return l, nil
+}
+
+// Token returns the lease's fencing token.
+func (l *Lease) Token() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.record.Token
+}
+
+// Context returns a context that is canceled when the lease is lost or
+// released, or when the context passed to Acquire is. Use context.Cause to tell
+// these apart.
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+// Release stops renewing the lease and marks it as expired so the next Acquire
+// succeeds immediately. Releasing a lost lease returns ErrLost.
+func (l *Lease) Release(ctx context.Context) error {
+	if err := context.Cause(l.ctx); errors.Is(err, ErrLost) {
+		<-l.done
+		return err
+	}
+
+	l.cancel(ErrReleased)
+	<-l.done
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Keep the token in the record so the next holder's token is still higher.
+	rec := l.record
+	rec.Expires = time.Time{}
+
+	if _, err := write(ctx, l.st, l.key, rec, store.Condition{IfVersion: l.version}); err != nil {
+		if errors.Is(err, store.ErrConflict) || errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("%w: %s changed hands before release", ErrLost, l.key)
+		}
+		return fmt.Errorf("can't release lease %s: %w", l.key, err)
+	}
+
+	return nil
+}
+
+func (l *Lease) renewLoop() {
+	defer close(l.done)
+
+	t := time.NewTicker(l.opts.RenewInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if err := l.renew(); err != nil {
+			if errors.Is(err, ErrLost) {
+				slog.Error("lease lost", "key", l.key, "err", err)
+				l.cancel(err)
+				return
+			}
+
+			slog.Warn("can't renew lease, will retry", "key", l.key, "err", err)
+		}
+	}
+}
+
+func (l *Lease) renew() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.opts.now()
+	if !now.Before(l.record.Expires) {
+		return fmt.Errorf("%w: %s expired at %s before it could be renewed", ErrLost, l.key, l.record.Expires.Format(time.RFC3339))
+	}
+
+	rec := l.record
+	rec.Expires = now.Add(l.opts.TTL)
+
+	version, err := write(l.ctx, l.st, l.key, rec, store.Condition{IfVersion: l.version})
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) || errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("%w: %s was taken over: %w", ErrLost, l.key, err)
+		}
+		return err
+	}
+
+	l.record = rec
+	l.version = version
+
+	return nil
+}
+
+func write(ctx context.Context, st store.Interface, key string, rec Record, cond store.Condition) (string, error) {
+	cs, ok := st.(store.ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, st)
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", store.ErrCantEncode, err)
+	}
+
+	return cs.SetIf(ctx, key, data, cond)
+}
@@ -0,0 +1,174 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+)
+
+// fakeClock is a manually advanced clock for testing expiry.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func readRecord(t *testing.T, st store.Interface, name string) Record {
+	t.Helper()
+
+	data, err := st.Get(context.Background(), Prefix+"/"+name)
+	if err != nil {
+		t.Fatalf("can't read lease record: %v", err)
+	}
+
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("can't decode lease record: %v", err)
+	}
+
+	return rec
+}
+
+func TestAcquire(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	clock := newFakeClock()
+
+	a, err := Acquire(ctx, st, "job", Options{Owner: "a", TTL: time.Minute, RenewInterval: time.Hour, now: clock.Now})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	if got := a.Token(); got != 1 {
+		t.Errorf("Token() = %d, want 1", got)
+	}
+
+	if _, err := Acquire(ctx, st, "job", Options{Owner: "b", TTL: time.Minute, now: clock.Now}); !errors.Is(err, ErrHeld) {
+		t.Errorf("Acquire() while held error = %v, want %v", err, ErrHeld)
+	}
+
+	other, err := Acquire(ctx, st, "other-job", Options{Owner: "b", TTL: time.Minute, RenewInterval: time.Hour, now: clock.Now})
+	if err != nil {
+		t.Fatalf("Acquire() of a different name error = %v", err)
+	}
+	defer other.Release(ctx)
+
+	if err := a.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	if cause := context.Cause(a.Context()); !errors.Is(cause, ErrReleased) {
+		t.Errorf("Context() cause after Release() = %v, want %v", cause, ErrReleased)
+	}
+
+	b, err := Acquire(ctx, st, "job", Options{Owner: "b", TTL: time.Minute, RenewInterval: time.Hour, now: clock.Now})
+	if err != nil {
+		t.Fatalf("Acquire() after Release() error = %v", err)
+	}
+	defer b.Release(ctx)
+
+	if got := b.Token(); got != 2 {
+		t.Errorf("Token() after handover = %d, want 2", got)
+	}
+}
+
+func TestAcquire_Expired(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	clock := newFakeClock()
+
+	a, err := Acquire(ctx, st, "job", Options{Owner: "a", TTL: time.Minute, RenewInterval: 5 * time.Millisecond, now: clock.Now})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	clock.Advance(time.Minute)
+
+	b, err := Acquire(ctx, st, "job", Options{Owner: "b", TTL: time.Minute, RenewInterval: time.Hour, now: clock.Now})
+	if err != nil {
+		t.Fatalf("Acquire() of expired lease error = %v", err)
+	}
+	defer b.Release(ctx)
+
+	if got := b.Token(); got != 2 {
+		t.Errorf("Token() after takeover = %d, want 2", got)
+	}
+
+	select {
+	case <-a.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Context() wasn't canceled after the lease was lost")
+	}
+
+	if cause := context.Cause(a.Context()); !errors.Is(cause, ErrLost) {
+		t.Errorf("Context() cause = %v, want %v", cause, ErrLost)
+	}
+
+	if err := a.Release(ctx); !errors.Is(err, ErrLost) {
+		t.Errorf("Release() of lost lease error = %v, want %v", err, ErrLost)
+	}
+
+	if rec := readRecord(t, st, "job"); rec.Owner != "b" {
+		t.Errorf("lease owner after lost Release() = %q, want %q", rec.Owner, "b")
+	}
+}
+
+func TestLease_Renew(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	clock := newFakeClock()
+
+	l, err := Acquire(ctx, st, "job", Options{Owner: "a", TTL: time.Minute, RenewInterval: 5 * time.Millisecond, now: clock.Now})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer l.Release(ctx)
+
+	first := readRecord(t, st, "job").Expires
+	clock.Advance(30 * time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for readRecord(t, st, "job").Expires.Equal(first) {
+		if time.Now().After(deadline) {
+			t.Fatal("lease wasn't renewed in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if want := clock.Now().Add(time.Minute); readRecord(t, st, "job").Expires.Before(want) {
+		t.Errorf("renewed expiry = %s, want at least %s", readRecord(t, st, "job").Expires, want)
+	}
+
+	if err := l.Context().Err(); err != nil {
+		t.Errorf("Context() error while renewing = %v", err)
+	}
+}
+
+func TestAcquire_Unsupported(t *testing.T) {
+	// Embedding only the interface hides Memory's conditional write support.
+	st := struct{ store.Interface }{store.NewMemory()}
+
+	if _, err := Acquire(context.Background(), st, "job", Options{}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Acquire() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// batchConcurrency is the most operations GetMany, SetMany, DeleteMany and
+// DeletePrefix run at once when they fall back to one call per key.
+const batchConcurrency = 16
+
+// Batcher is implemented by stores that can work on many keys at once faster
+// than one call per key.
+type Batcher interface {
+	// GetMany returns the values of the keys that exist. Keys that don't exist
+	// are left out of the result rather than being an error.
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+
+	// SetMany puts every value into the store. If some writes fail, the rest
+	// may still have been made, and the error wraps every failure.
+	SetMany(ctx context.Context, values map[string][]byte) error
+
+	// DeleteMany removes every key from the store. Keys that don't exist are
+	// ignored. If some deletes fail, the error wraps every failure.
+	DeleteMany(ctx context.Context, keys []string) error
+
+	// DeletePrefix removes every key matching prefix and returns how many it
+	// removed. An empty prefix removes every key in the store.
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// KeyErrors holds why each of some keys in a batch couldn't be read, eg: the
+// values Typed.GetMany couldn't decode. It unwraps to every error, so
+// errors.Is(err, ErrCantDecode) works on it.
+type KeyErrors map[string]error
+
+func (e KeyErrors) Error() string {
+	keys := slices.Sorted(maps.Keys(e))
+	msgs := make([]string, len(keys))
+	for i, key := range keys {
+		msgs[i] = fmt.Sprintf("%s: %v", key, e[key])
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func (e KeyErrors) Unwrap() []error {
+	return slices.Collect(maps.Values(e))
+}
+
+// GetMany returns the values of the keys in s that exist. Stores that don't
+// implement Batcher are read a few keys at a time.
+func GetMany(ctx context.Context, s Interface, keys []string) (map[string][]byte, error) {
+	if b, ok := s.(Batcher); ok {
+		return b.GetMany(ctx, keys)
+	}
+
+	return getEach(ctx, s, keys)
+}
+
+// SetMany puts every value into s. Stores that don't implement Batcher are
+// written a few keys at a time.
+func SetMany(ctx context.Context, s Interface, values map[string][]byte) error {
+	if b, ok := s.(Batcher); ok {
+		return b.SetMany(ctx, values)
+	}
+
+	return setEach(ctx, s, values)
+}
+
+// DeleteMany removes every key from s, ignoring keys that don't exist. Stores
+// that don't implement Batcher have keys deleted a few at a time.
+func DeleteMany(ctx context.Context, s Interface, keys []string) error {
+	if b, ok := s.(Batcher); ok {
+		return b.DeleteMany(ctx, keys)
+	}
+
+	_, err := deleteEach(ctx, s, keys)
+	return err
+}
+
+// DeletePrefix removes every key in s matching prefix and returns how many it
+// removed. Stores that don't implement Batcher are listed in full and then
+// have keys deleted a few at a time.
+func DeletePrefix(ctx context.Context, s Interface, prefix string) (int, error) {
+	if b, ok := s.(Batcher); ok {
+		return b.DeletePrefix(ctx, prefix)
+	}
+
+	var keys []string
+	for key, err := range Iterate(ctx, s, prefix, ListOptions{}) {
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+
+	return deleteEach(ctx, s, keys)
+}
+
+// each calls fn for every key, batchConcurrency at a time, and returns the
+// errors it returned joined together. Once ctx is done no more keys are
+// started, and if any were left out ctx's error is returned with the rest.
+func each(ctx context.Context, keys []string, fn func(key string) error) error {
+	var (
+		g       errgroup.Group
+		lock    sync.Mutex
+		errs    []error
+		stopped bool
+	)
+
+	stop := func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if !stopped {
+			stopped = true
+			errs = append([]error{ctx.Err()}, errs...)
+		}
+	}
+
+	g.SetLimit(batchConcurrency)
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			stop()
+			break
+		}
+
+		g.Go(func() error {
+			// The key may have waited for a free slot until after ctx was done.
+			if ctx.Err() != nil {
+				stop()
+				return nil
+			}
+
+			if err := fn(key); err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+			}
+			return nil
+		})
+	}
+
+	g.Wait()
+
+	return errors.Join(errs...)
+}
+
+// getEach implements GetMany with one Get per key.
+func getEach(ctx context.Context, s Interface, keys []string) (map[string][]byte, error) {
+	var lock sync.Mutex
+	result := make(map[string][]byte, len(keys))
+
+	err := each(ctx, keys, func(key string) error {
+		value, err := s.Get(ctx, key)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return nil
+		case err != nil:
+			return fmt.Errorf("can't get %s: %w", key, err)
+		}
+
+		lock.Lock()
+		result[key] = value
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// setEach implements SetMany with one Set per key.
+func setEach(ctx context.Context, s Interface, values map[string][]byte) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	return each(ctx, keys, func(key string) error {
+		if err := s.Set(ctx, key, values[key]); err != nil {
+			return fmt.Errorf("can't set %s: %w", key, err)
+		}
+		return nil
+	})
+}
+
+// deleteEach implements DeleteMany with one Delete per key and returns how
+// many keys it deleted.
+func deleteEach(ctx context.Context, s Interface, keys []string) (int, error) {
+	var deleted atomic.Int64
+
+	err := each(ctx, keys, func(key string) error {
+		switch err := s.Delete(ctx, key); {
+		case errors.Is(err, ErrNotFound):
+			return nil
+		case err != nil:
+			return fmt.Errorf("can't delete %s: %w", key, err)
+		}
+
+		deleted.Add(1)
+		return nil
+	})
+
+	return int(deleted.Load()), err
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// permissionStore fails every operation on denied keys with ErrPermission.
+type permissionStore struct {
+	*Memory
+	denied map[string]bool
+}
+
+func (p *permissionStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if p.denied[key] {
+		return nil, fmt.Errorf("%w: %s", ErrPermission, key)
+	}
+	return p.Memory.Get(ctx, key)
+}
+
+func (p *permissionStore) Set(ctx context.Context, key string, value []byte) error {
+	if p.denied[key] {
+		return fmt.Errorf("%w: %s", ErrPermission, key)
+	}
+	return p.Memory.Set(ctx, key, value)
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		wrap func(t *testing.T, m *Memory) Interface
+	}{
+		{
+			name: "fallback",
+			wrap: func(t *testing.T, m *Memory) Interface { return m },
+		},
+		{
+			name: "prefixed",
+			wrap: func(t *testing.T, m *Memory) Interface { return NewPrefixed(m, "scope") },
+		},
+		{
+			name: "lru",
+			wrap: func(t *testing.T, m *Memory) Interface {
+				l, err := NewLRU(m, LRUOptions{NegativeTTL: time.Hour})
+				if err != nil {
+					t.Fatalf("NewLRU() error = %v", err)
+				}
+				return l
+			},
+		},
+		{
+			name: "encrypted",
+			wrap: func(t *testing.T, m *Memory) Interface {
+				return newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+			},
+		},
+		{
+			name: "observed",
+			wrap: func(t *testing.T, m *Memory) Interface { return observed(m, "test-batch") },
+		},
+		{
+			name: "retry",
+			wrap: func(t *testing.T, m *Memory) Interface {
+				r, _ := newTestRetry(t, m, RetryOptions{})
+				return r
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.wrap(t, NewMemory())
+
+			if _, ok := s.(Batcher); !ok && tt.name != "fallback" {
+				t.Errorf("%T doesn't implement Batcher", s)
+			}
+
+			values := map[string][]byte{
+				"a/1": []byte("one"),
+				"a/2": []byte("two"),
+				"b/1": []byte("three"),
+			}
+			if err := SetMany(ctx, s, values); err != nil {
+				t.Fatalf("SetMany() error = %v", err)
+			}
+
+			got, err := GetMany(ctx, s, []string{"a/1", "a/2", "b/1", "missing"})
+			if err != nil {
+				t.Fatalf("GetMany() error = %v", err)
+			}
+			if !maps.EqualFunc(got, values, equalBytes) {
+				t.Errorf("GetMany() = %q, want %q", got, values)
+			}
+
+			if err := DeleteMany(ctx, s, []string{"b/1", "missing"}); err != nil {
+				t.Fatalf("DeleteMany() error = %v", err)
+			}
+			if err := s.Exists(ctx, "b/1"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Exists() after DeleteMany error = %v, want %v", err, ErrNotFound)
+			}
+
+			if err := s.Set(ctx, "ab", []byte("four")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			deleted, err := DeletePrefix(ctx, s, "a/")
+			if err != nil {
+				t.Fatalf("DeletePrefix() error = %v", err)
+			}
+			if deleted != 2 {
+				t.Errorf("DeletePrefix() deleted %d keys, want 2", deleted)
+			}
+
+			got, err = GetMany(ctx, s, []string{"a/1", "a/2", "ab"})
+			if err != nil {
+				t.Fatalf("GetMany() error = %v", err)
+			}
+			if keys := slices.Sorted(maps.Keys(got)); !slices.Equal(keys, []string{"ab"}) {
+				t.Errorf("GetMany() after DeletePrefix found %v, want [ab]", keys)
+			}
+		})
+	}
+}
+
+func TestBatch_Errors(t *testing.T) {
+	ctx := context.Background()
+	s := &permissionStore{Memory: NewMemory(), denied: map[string]bool{"denied": true}}
+
+	err := SetMany(ctx, s, map[string][]byte{"ok": []byte("1"), "denied": []byte("2")})
+	if !errors.Is(err, ErrPermission) {
+		t.Errorf("SetMany() error = %v, want %v", err, ErrPermission)
+	}
+	if _, err := s.Memory.Get(ctx, "ok"); err != nil {
+		t.Errorf("SetMany() didn't write the other key: %v", err)
+	}
+
+	if _, err := GetMany(ctx, s, []string{"ok", "denied"}); !errors.Is(err, ErrPermission) {
+		t.Errorf("GetMany() error = %v, want %v", err, ErrPermission)
+	}
+}
+
+// cancelingStore cancels a context once it has been asked for a value.
+type cancelingStore struct {
+	*Memory
+	cancel context.CancelFunc
+	gets   atomic.Int32
+}
+
+func (c *cancelingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	c.gets.Add(1)
+	c.cancel()
+	return c.Memory.Get(ctx, key)
+}
+
+func TestBatch_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &cancelingStore{Memory: NewMemory(), cancel: cancel}
+
+	keys := make([]string, 10*batchConcurrency)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key/%d", i)
+	}
+
+	if _, err := GetMany(ctx, s, keys); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetMany() error = %v, want %v", err, context.Canceled)
+	}
+	if got := int(s.gets.Load()); got > batchConcurrency {
+		t.Errorf("GetMany() made %d Get calls after ctx was canceled, want at most %d", got, batchConcurrency)
+	}
+}
+
+func TestLRU_Batch(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	l, err := NewLRU(m, LRUOptions{})
+	if err != nil {
+		t.Fatalf("NewLRU() error = %v", err)
+	}
+
+	if err := l.SetMany(ctx, map[string][]byte{"a": []byte("1"), "b": []byte("2")}); err != nil {
+		t.Fatalf("SetMany() error = %v", err)
+	}
+
+	// Changing the underlying store behind the cache's back shows which values
+	// are served from memory.
+	if err := m.Set(ctx, "a", []byte("changed")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := m.Set(ctx, "c", []byte("3")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := l.GetMany(ctx, []string{"a", "c"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if string(got["a"]) != "1" || string(got["c"]) != "3" {
+		t.Errorf("GetMany() = %q, want a from the cache and c from the store", got)
+	}
+
+	if _, err := l.DeletePrefix(ctx, ""); err != nil {
+		t.Fatalf("DeletePrefix() error = %v", err)
+	}
+	if l.cache.Len() != 0 {
+		t.Errorf("cache holds %d entries after DeletePrefix, want 0", l.cache.Len())
+	}
+}
+
+func TestJSON_Batch(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	s := &JSON[codecTestValue]{Underlying: m, Prefix: "test"}
+
+	values := map[string]codecTestValue{
+		"a": {Name: "a", Value: 1},
+		"b": {Name: "b", Value: 2},
+	}
+	if err := s.SetMany(ctx, values); err != nil {
+		t.Fatalf("SetMany() error = %v", err)
+	}
+	if err := m.Set(ctx, "other/a", []byte("{}")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := s.GetMany(ctx, []string{"a", "b", "missing"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if !maps.Equal(got, values) {
+		t.Errorf("GetMany() = %v, want %v", got, values)
+	}
+
+	if err := m.Set(ctx, "test/broken", []byte("not json")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	// Values that decode are still returned along with why the rest didn't.
+	got, err = s.GetMany(ctx, []string{"a", "broken"})
+	var keyErrs KeyErrors
+	if !errors.Is(err, ErrCantDecode) || !errors.As(err, &keyErrs) || keyErrs["broken"] == nil || len(keyErrs) != 1 {
+		t.Errorf("GetMany() error = %v, want %v for broken", err, ErrCantDecode)
+	}
+	if _, ok := got["a"]; !ok || len(got) != 1 {
+		t.Errorf("GetMany() = %v, want the value of a", got)
+	}
+
+	if err := s.DeleteMany(ctx, []string{"a"}); err != nil {
+		t.Fatalf("DeleteMany() error = %v", err)
+	}
+	if err := s.Exists(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() after DeleteMany error = %v, want %v", err, ErrNotFound)
+	}
+
+	deleted, err := s.DeletePrefix(ctx, "")
+	if err != nil {
+		t.Fatalf("DeletePrefix() error = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeletePrefix() deleted %d keys, want 2", deleted)
+	}
+	if err := m.Exists(ctx, "other/a"); err != nil {
+		t.Errorf("DeletePrefix() deleted a key outside of the store's prefix: %v", err)
+	}
+}
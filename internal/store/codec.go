@@ -0,0 +1,234 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec converts values to and from the bytes kept in a store.
+type Codec interface {
+	// ID identifies the codec in the header of encoded values so readers can
+	// tell which codec wrote them. IDs below 128 are reserved for the codecs in
+	// this package.
+	ID() byte
+
+	// Encode writes v to w.
+	Encode(w io.Writer, v any) error
+
+	// Decode reads a value from r into the pointer v.
+	Decode(r io.Reader, v any) error
+}
+
+var (
+	// JSONCodec encodes values with encoding/json.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec encodes values with encoding/gob.
+	GobCodec Codec = gobCodec{}
+
+	// CBORCodec encodes values as CBOR (RFC 8949), a compact binary format that
+	// follows the same struct tags as encoding/json.
+	CBORCodec Codec = cborCodec{}
+
+	// ProtobufCodec encodes values that implement proto.Message.
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// codecs are the codecs a reader can pick from based on a value's header.
+var codecs = map[byte]Codec{
+	JSONCodec.ID():     JSONCodec,
+	GobCodec.ID():      GobCodec,
+	CBORCodec.ID():     CBORCodec,
+	ProtobufCodec.ID(): ProtobufCodec,
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte { return 1 }
+
+// Encode uses json.Marshal rather than json.Encoder so that values don't get a
+// trailing newline.
+func (jsonCodec) Encode(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (jsonCodec) Decode(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte                        { return 2 }
+func (gobCodec) Encode(w io.Writer, v any) error { return gob.NewEncoder(w).Encode(v) }
+func (gobCodec) Decode(r io.Reader, v any) error { return gob.NewDecoder(r).Decode(v) }
+
+type cborCodec struct{}
+
+func (cborCodec) ID() byte                        { return 3 }
+func (cborCodec) Encode(w io.Writer, v any) error { return cbor.NewEncoder(w).Encode(v) }
+func (cborCodec) Decode(r io.Reader, v any) error { return cbor.NewDecoder(r).Decode(v) }
+
+type protobufCodec struct{}
+
+func (protobufCodec) ID() byte { return 4 }
+
+func (protobufCodec) Encode(w io.Writer, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Decode reads into v, which is either a proto.Message or a pointer to one, as
+// Typed passes for message types like *pb.Foo. Nil messages are allocated.
+func (protobufCodec) Decode(r io.Reader, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Pointer {
+			return fmt.Errorf("%T is not a proto.Message", v)
+		}
+
+		elem := rv.Elem()
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+
+		if msg, ok = elem.Interface().(proto.Message); !ok {
+			return fmt.Errorf("%T is not a proto.Message", elem.Interface())
+		}
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(data, msg)
+}
+
+// Compression is how a Typed store compresses encoded values.
+type Compression byte
+
+const (
+	CompressionNone Compression = 0
+	CompressionGzip Compression = 1
+	CompressionZstd Compression = 2
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", byte(c))
+	}
+}
+
+// compressWriter wraps w so that writes are compressed with c. The returned
+// writer must be closed to flush it, which doesn't close w.
+func compressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown compression %s", c)
+	}
+}
+
+// decompressReader wraps r so that reads are decompressed with c.
+func decompressReader(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %s", c)
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Values written by Typed stores that set a Codec or Compression start with a
+// header: typedMagic, typedHeaderVersion, the codec ID and the compression.
+// The magic starts with a byte that can't begin a JSON document, so values
+// without a header are read as plain JSON.
+var typedMagic = []byte{0xff, 'g', 'l', 'u', 'e'}
+
+const (
+	typedHeaderVersion = 1
+	typedHeaderLen     = 8
+)
+
+func typedHeader(codec Codec, c Compression) []byte {
+	return append(bytes.Clone(typedMagic), typedHeaderVersion, codec.ID(), byte(c))
+}
+
+// readTypedHeader reads the header from br if there is one, returning the
+// codec and compression it names. Values without a header are plain JSON.
+// preferred is used for codec IDs this package doesn't know about.
+func readTypedHeader(br *bufio.Reader, preferred Codec) (Codec, Compression, error) {
+	if magic, err := br.Peek(len(typedMagic)); err != nil || !bytes.Equal(magic, typedMagic) {
+		return JSONCodec, CompressionNone, nil
+	}
+
+	var hdr [typedHeaderLen]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, 0, fmt.Errorf("can't read header: %w", err)
+	}
+
+	if v := hdr[len(typedMagic)]; v != typedHeaderVersion {
+		return nil, 0, fmt.Errorf("unknown header version %d", v)
+	}
+
+	id := hdr[len(typedMagic)+1]
+
+	codec, ok := codecs[id]
+	if preferred != nil && preferred.ID() == id {
+		codec, ok = preferred, true
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown codec ID %d", id)
+	}
+
+	return codec, Compression(hdr[len(typedMagic)+2]), nil
+}
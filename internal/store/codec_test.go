@@ -0,0 +1,196 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestValue struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
+func TestTyped_Codecs(t *testing.T) {
+	ctx := context.Background()
+	want := codecTestValue{Name: strings.Repeat("compressible ", 100), Value: 42}
+
+	stores := map[string]func(t *testing.T) Interface{
+		"memory":    func(t *testing.T) Interface { return NewMemory() },
+		"directory": func(t *testing.T) Interface { return newTestDirectory(t, nil) },
+	}
+
+	for storeName, newStore := range stores {
+		for _, codec := range []Codec{nil, JSONCodec, GobCodec, CBORCodec} {
+			for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+				name := storeName + "/" + codecName(codec) + "/" + compression.String()
+
+				t.Run(name, func(t *testing.T) {
+					st := newStore(t)
+					typed := &Typed[codecTestValue]{Underlying: st, Prefix: "things", Codec: codec, Compression: compression}
+
+					if err := typed.Set(ctx, "key", want); err != nil {
+						t.Fatalf("Set() error = %v", err)
+					}
+
+					got, err := typed.Get(ctx, "key")
+					if err != nil {
+						t.Fatalf("Get() error = %v", err)
+					}
+					if got != want {
+						t.Errorf("Get() got = %+v, want %+v", got, want)
+					}
+
+					raw, err := st.Get(ctx, "things/key")
+					if err != nil {
+						t.Fatalf("underlying Get() error = %v", err)
+					}
+
+					if hasHeader := bytes.HasPrefix(raw, typedMagic); hasHeader == typed.plain() {
+						t.Errorf("stored value has header = %v, want %v", hasHeader, !typed.plain())
+					}
+
+					if compression != CompressionNone && len(raw) >= len(want.Name) {
+						t.Errorf("compressed value is %d bytes, want less than %d", len(raw), len(want.Name))
+					}
+
+					// Readers detect the codec, so a plain JSON store can read it too.
+					plain := &JSON[codecTestValue]{Underlying: st, Prefix: "things"}
+					if got, err := plain.Get(ctx, "key"); err != nil || got != want {
+						t.Errorf("JSON.Get() got = %+v, %v, want %+v", got, err, want)
+					}
+
+					if err := typed.SetStream(ctx, "streamed", want); err != nil {
+						t.Fatalf("SetStream() error = %v", err)
+					}
+					if got, err := typed.Get(ctx, "streamed"); err != nil || got != want {
+						t.Errorf("Get() after SetStream() got = %+v, %v, want %+v", got, err, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+// Set writes encoded values whole, so decorators can cache them.
+func TestTyped_SetCaches(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := NewLRUCache(NewMemory())
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+
+	typed := &Typed[codecTestValue]{Underlying: l, Prefix: "things"}
+	if err := typed.Set(ctx, "key", codecTestValue{Name: "cached"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if !l.cache.Contains("things/key") {
+		t.Error("Set() didn't cache the value")
+	}
+}
+
+func codecName(c Codec) string {
+	switch c {
+	case nil:
+		return "default"
+	case JSONCodec:
+		return "json"
+	case GobCodec:
+		return "gob"
+	case CBORCodec:
+		return "cbor"
+	case ProtobufCodec:
+		return "protobuf"
+	default:
+		return "unknown"
+	}
+}
+
+func TestTyped_ReadsPlainJSON(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemory()
+
+	if err := st.Set(ctx, "key", []byte(`{"name":"legacy","value":1}`)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	typed := &Typed[codecTestValue]{Underlying: st, Codec: CBORCodec, Compression: CompressionZstd}
+
+	got, err := typed.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if want := (codecTestValue{Name: "legacy", Value: 1}); got != want {
+		t.Errorf("Get() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestTyped_Protobuf(t *testing.T) {
+	ctx := context.Background()
+
+	typed := &Typed[*wrapperspb.StringValue]{Underlying: NewMemory(), Codec: ProtobufCodec, Compression: CompressionGzip}
+	want := wrapperspb.String("hello")
+
+	if err := typed.Set(ctx, "key", want); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := typed.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if !proto.Equal(got, want) {
+		t.Errorf("Get() got = %v, want %v", got, want)
+	}
+
+	notProto := &Typed[codecTestValue]{Underlying: NewMemory(), Codec: ProtobufCodec}
+	if err := notProto.Set(ctx, "key", codecTestValue{}); !errors.Is(err, ErrCantEncode) {
+		t.Errorf("Set() of non-proto value error = %v, want %v", err, ErrCantEncode)
+	}
+}
+
+func TestTyped_BadHeader(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		value []byte
+	}{
+		{name: "unknown version", value: append(bytes.Clone(typedMagic), 99, 1, 0)},
+		{name: "unknown codec", value: append(bytes.Clone(typedMagic), typedHeaderVersion, 200, 0)},
+		{name: "unknown compression", value: append(bytes.Clone(typedMagic), typedHeaderVersion, 1, 200)},
+		{name: "truncated", value: append(bytes.Clone(typedMagic), typedHeaderVersion)},
+		{name: "corrupt gzip", value: append(typedHeader(JSONCodec, CompressionGzip), "not gzip"...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := NewMemory()
+			if err := st.Set(ctx, "key", tt.value); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			typed := &Typed[codecTestValue]{Underlying: st}
+			if _, err := typed.Get(ctx, "key"); !errors.Is(err, ErrCantDecode) {
+				t.Errorf("Get() error = %v, want %v", err, ErrCantDecode)
+			}
+		})
+	}
+}
+
+func TestTyped_UnknownCompression(t *testing.T) {
+	typed := &Typed[codecTestValue]{Underlying: NewMemory(), Compression: Compression(200)}
+
+	if err := typed.Set(context.Background(), "key", codecTestValue{}); !errors.Is(err, ErrCantEncode) {
+		t.Errorf("Set() error = %v, want %v", err, ErrCantEncode)
+	}
+}
@@ -0,0 +1,87 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+	"github.com/tigrisdata-community/glue/internal/store/s3test"
+	"github.com/tigrisdata-community/glue/internal/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	drivers := map[string]func(t *testing.T) store.Interface{
+		"memory": func(t *testing.T) store.Interface { return store.NewMemory() },
+		"directory": func(t *testing.T) store.Interface {
+			d, err := store.NewDirectory(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewDirectory() error = %v", err)
+			}
+			return d
+		},
+		"s3api": func(t *testing.T) store.Interface {
+			srv := s3test.NewServer(t, "bucket")
+			s, err := store.NewS3API(context.Background(), "bucket", store.S3Options{
+				Endpoint:        srv.URL,
+				Region:          s3test.Region,
+				AccessKeyID:     s3test.AccessKeyID,
+				SecretAccessKey: s3test.SecretAccessKey,
+				UsePathStyle:    true,
+			})
+			if err != nil {
+				t.Fatalf("NewS3API() error = %v", err)
+			}
+			return s
+		},
+		"open": func(t *testing.T) store.Interface {
+			s, err := store.Open(context.Background(), "memory://?lru=true")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			return s
+		},
+		"lru": func(t *testing.T) store.Interface {
+			l, err := store.NewLRU(store.NewMemory(), store.LRUOptions{CacheExists: true})
+			if err != nil {
+				t.Fatalf("NewLRU() error = %v", err)
+			}
+			return l
+		},
+		"prefixed": func(t *testing.T) store.Interface {
+			return store.NewPrefixed(store.NewMemory(), "scope")
+		},
+		"encrypted": func(t *testing.T) store.Interface {
+			e, err := store.NewEncrypted(store.NewMemory(), store.EncryptedOptions{
+				Keys: []store.EncryptionKey{{ID: "test", Key: bytes.Repeat([]byte{1}, 32)}},
+			})
+			if err != nil {
+				t.Fatalf("NewEncrypted() error = %v", err)
+			}
+			return e
+		},
+		"retry": func(t *testing.T) store.Interface {
+			r, err := store.NewRetry(store.NewMemory(), store.RetryOptions{})
+			if err != nil {
+				t.Fatalf("NewRetry() error = %v", err)
+			}
+			return r
+		},
+		"mirror": func(t *testing.T) store.Interface {
+			m, err := store.NewMirror(store.NewMemory(), store.MirrorOptions{
+				Secondaries: []store.Interface{store.NewMemory()},
+			})
+			if err != nil {
+				t.Fatalf("NewMirror() error = %v", err)
+			}
+			return m
+		},
+	}
+
+	for name, newStore := range drivers {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			storetest.Run(t, newStore)
+		})
+	}
+}
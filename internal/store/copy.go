@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// copyBatchSize is how many keys CopyPrefix and MovePrefix list before
+// copying them, unless CopyOptions say otherwise.
+const copyBatchSize = 1000
+
+// Copier is implemented by stores that can copy a value without sending it
+// through the caller.
+type Copier interface {
+	// Copy puts the value of src at dst, replacing any value already there,
+	// along with its content type, metadata and expiry. It returns ErrNotFound
+	// if src doesn't exist.
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// Mover is implemented by stores that can move a value more cheaply than
+// copying it and deleting the original.
+type Mover interface {
+	// Move puts the value of src at dst, replacing any value already there,
+	// and removes src. It returns ErrNotFound if src doesn't exist.
+	Move(ctx context.Context, src, dst string) error
+}
+
+// Copy puts the value of src in s at dst. Stores that don't implement Copier
+// have the value streamed through GetReader and SetReader, which keeps its
+// content type but not its metadata or expiry.
+func Copy(ctx context.Context, s Interface, src, dst string) error {
+	if c, ok := s.(Copier); ok {
+		return c.Copy(ctx, src, dst)
+	}
+
+	r, info, err := GetReader(ctx, s, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return SetReader(ctx, s, dst, r, info)
+}
+
+// Move puts the value of src in s at dst and removes src. Moving a key onto
+// itself leaves it alone. Stores that don't implement Mover have the value
+// copied with Copy and then deleted, so a failed delete can leave it in both
+// places.
+func Move(ctx context.Context, s Interface, src, dst string) error {
+	if src == dst {
+		return s.Exists(ctx, src)
+	}
+
+	if m, ok := s.(Mover); ok {
+		return m.Move(ctx, src, dst)
+	}
+
+	if err := Copy(ctx, s, src, dst); err != nil {
+		return err
+	}
+
+	if err := s.Delete(ctx, src); err != nil {
+		return fmt.Errorf("copied %s to %s but can't delete it: %w", src, dst, err)
+	}
+
+	return nil
+}
+
+// CopyOptions configure CopyPrefix and MovePrefix.
+type CopyOptions struct {
+	// BatchSize is how many keys are listed before they are copied, at most
+	// batchConcurrency at a time. Defaults to 1000.
+	BatchSize int
+
+	// StartAfter skips the keys under the source prefix that sort at or before
+	// it, eg: the LastKey of the last batch an interrupted copy finished.
+	StartAfter string
+
+	// Progress is called after each batch has been copied.
+	Progress func(CopyProgress)
+}
+
+// CopyProgress is how far CopyPrefix or MovePrefix have got.
+type CopyProgress struct {
+	// Keys is how many keys have been copied or moved so far.
+	Keys int
+
+	// Batches is how many batches have been finished so far.
+	Batches int
+
+	// LastKey is the last source key of the batch that was just finished.
+	// Every key under the source prefix up to and including it is done.
+	LastKey string
+}
+
+// CopyPrefix copies every key in s under the prefix src to the same key under
+// dst, eg: CopyPrefix(ctx, s, "discourse/", "forums/tigris/discourse/", ...)
+// copies discourse/123 to forums/tigris/discourse/123, and returns how many
+// keys it copied. Keys are listed a batch at a time, and CopyPrefix stops
+// after the first batch with a key it couldn't copy.
+//
+// src can't be dst or inside it, since copies could overwrite keys that
+// haven't been copied yet, and dst can't be nested inside src, eg: "a/" and
+// "a/b/". dst can otherwise start with src, eg: "discourse" and
+// "discourse-thread/", or "" and "backup/", in which case the keys already
+// under dst are left out rather than being copied again.
+func CopyPrefix(ctx context.Context, s Interface, src, dst string, opts CopyOptions) (int, error) {
+	return transferPrefix(ctx, s, src, dst, opts, Copy)
+}
+
+// MovePrefix moves every key in s under the prefix src to the same key under
+// dst, the same way as CopyPrefix, and returns how many keys it moved.
+func MovePrefix(ctx context.Context, s Interface, src, dst string, opts CopyOptions) (int, error) {
+	return transferPrefix(ctx, s, src, dst, opts, Move)
+}
+
+// transferPrefix calls fn for every key under src and its counterpart under
+// dst, one batch at a time.
+func transferPrefix(ctx context.Context, s Interface, src, dst string, opts CopyOptions, fn func(ctx context.Context, s Interface, src, dst string) error) (int, error) {
+	switch {
+	case strings.HasPrefix(src, dst):
+		return 0, fmt.Errorf("%w: can't copy keys under %q to %q around them", ErrInvalidKey, src, dst)
+	case src != "" && strings.HasPrefix(dst, src) && (strings.HasSuffix(src, "/") || dst[len(src)] == '/'):
+		return 0, fmt.Errorf("%w: can't copy keys under %q to %q inside it", ErrInvalidKey, src, dst)
+	}
+	skipDst := strings.HasPrefix(dst, src)
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = copyBatchSize
+	}
+
+	progress := CopyProgress{LastKey: opts.StartAfter}
+
+	flush := func(batch []string) error {
+		var done atomic.Int64
+
+		err := each(ctx, batch, func(key string) error {
+			target := dst + strings.TrimPrefix(key, src)
+			if err := fn(ctx, s, key, target); err != nil {
+				return fmt.Errorf("can't copy %s to %s: %w", key, target, err)
+			}
+
+			done.Add(1)
+			return nil
+		})
+
+		progress.Keys += int(done.Load())
+		if err != nil {
+			return err
+		}
+
+		progress.Batches++
+		progress.LastKey = batch[len(batch)-1]
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		return nil
+	}
+
+	batch := make([]string, 0, opts.BatchSize)
+
+	for key, err := range Iterate(ctx, s, src, ListOptions{StartAfter: opts.StartAfter}) {
+		if err != nil {
+			return progress.Keys, err
+		}
+		if skipDst && strings.HasPrefix(key, dst) {
+			continue
+		}
+
+		batch = append(batch, key)
+		if len(batch) < opts.BatchSize {
+			continue
+		}
+
+		if err := flush(batch); err != nil {
+			return progress.Keys, err
+		}
+		batch = batch[:0]
+	}
+
+	if len(batch) > 0 {
+		if err := flush(batch); err != nil {
+			return progress.Keys, err
+		}
+	}
+
+	return progress.Keys, nil
+}
@@ -0,0 +1,414 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+
+	stores := []struct {
+		name string
+		new  func(t *testing.T) Interface
+	}{
+		{name: "memory", new: func(t *testing.T) Interface { return NewMemory() }},
+		{name: "directory", new: func(t *testing.T) Interface { return newTestDirectory(t, nil) }},
+		{
+			name: "s3api",
+			new: func(t *testing.T) Interface {
+				s, _ := newTestS3API(t)
+				return s
+			},
+		},
+		{name: "mock", new: func(t *testing.T) Interface { return newMockStore() }},
+		{name: "prefixed", new: func(t *testing.T) Interface { return NewPrefixed(NewMemory(), "scope") }},
+		{
+			name: "lru",
+			new: func(t *testing.T) Interface {
+				l, err := NewLRU(NewMemory(), LRUOptions{})
+				if err != nil {
+					t.Fatalf("NewLRU() error = %v", err)
+				}
+				return l
+			},
+		},
+		{
+			name: "encrypted",
+			new: func(t *testing.T) Interface {
+				return newTestEncrypted(t, NewMemory(), EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+			},
+		},
+		{name: "observed", new: func(t *testing.T) Interface { return observed(NewMemory(), "test-copy") }},
+		{
+			name: "retry",
+			new: func(t *testing.T) Interface {
+				r, _ := newTestRetry(t, NewMemory(), RetryOptions{})
+				return r
+			},
+		},
+	}
+
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			s := st.new(t)
+
+			check := func(key, want string) {
+				t.Helper()
+
+				got, err := s.Get(ctx, key)
+				if err != nil {
+					t.Fatalf("Get(%q) error = %v", key, err)
+				}
+				if string(got) != want {
+					t.Errorf("Get(%q) = %q, want %q", key, got, want)
+				}
+			}
+
+			if err := s.Set(ctx, "a/src", []byte("first")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := s.Set(ctx, "b/dst", []byte("stale")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			check("b/dst", "stale")
+
+			if err := Copy(ctx, s, "a/src", "b/dst"); err != nil {
+				t.Fatalf("Copy() error = %v", err)
+			}
+			check("a/src", "first")
+			check("b/dst", "first")
+
+			if err := Move(ctx, s, "a/src", "c/moved"); err != nil {
+				t.Fatalf("Move() error = %v", err)
+			}
+			check("c/moved", "first")
+			if err := s.Exists(ctx, "a/src"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Exists() after Move() error = %v, want %v", err, ErrNotFound)
+			}
+
+			if err := Move(ctx, s, "c/moved", "c/moved"); err != nil {
+				t.Errorf("Move() onto itself error = %v", err)
+			}
+			check("c/moved", "first")
+
+			if err := Copy(ctx, s, "a/src", "d/dst"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Copy() of a missing key error = %v, want %v", err, ErrNotFound)
+			}
+			if err := Move(ctx, s, "a/src", "d/dst"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Move() of a missing key error = %v, want %v", err, ErrNotFound)
+			}
+			if err := s.Exists(ctx, "d/dst"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Exists() after failed copies error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestCopy_KeepsOptions(t *testing.T) {
+	ctx := context.Background()
+
+	stores := []struct {
+		name string
+		new  func(t *testing.T) Interface
+	}{
+		{name: "memory", new: func(t *testing.T) Interface { return NewMemory() }},
+		{
+			name: "s3api",
+			new: func(t *testing.T) Interface {
+				s, _ := newTestS3API(t)
+				return s
+			},
+		},
+	}
+
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			s := st.new(t)
+
+			opts := SetOptions{
+				TTL:         time.Hour,
+				ContentType: "text/plain",
+				Metadata:    map[string]string{"source": "test"},
+			}
+			if err := SetWithOptions(ctx, s, "src", []byte("value"), opts); err != nil {
+				t.Fatalf("SetWithOptions() error = %v", err)
+			}
+
+			if err := Copy(ctx, s, "src", "dst"); err != nil {
+				t.Fatalf("Copy() error = %v", err)
+			}
+
+			src, err := Stat(ctx, s, "src")
+			if err != nil {
+				t.Fatalf("Stat(src) error = %v", err)
+			}
+			dst, err := Stat(ctx, s, "dst")
+			if err != nil {
+				t.Fatalf("Stat(dst) error = %v", err)
+			}
+
+			if dst.ContentType != "text/plain" || !maps.Equal(dst.Metadata, opts.Metadata) {
+				t.Errorf("Stat(dst) = %+v, want the content type and metadata of src", dst)
+			}
+			if !dst.Expires.Equal(src.Expires) {
+				t.Errorf("Stat(dst).Expires = %v, want %v", dst.Expires, src.Expires)
+			}
+		})
+	}
+}
+
+func TestCopyPrefix(t *testing.T) {
+	ctx := context.Background()
+	keys := []string{"discourse/1", "discourse/2", "discourse/3", "discourse/4", "discourse/5"}
+
+	tests := []struct {
+		name         string
+		move         bool
+		opts         CopyOptions
+		want         int
+		wantProgress []CopyProgress
+	}{
+		{
+			name: "copies in batches",
+			opts: CopyOptions{BatchSize: 2},
+			want: 5,
+			wantProgress: []CopyProgress{
+				{Keys: 2, Batches: 1, LastKey: "discourse/2"},
+				{Keys: 4, Batches: 2, LastKey: "discourse/4"},
+				{Keys: 5, Batches: 3, LastKey: "discourse/5"},
+			},
+		},
+		{
+			name:         "moves in batches",
+			move:         true,
+			opts:         CopyOptions{BatchSize: 3},
+			want:         5,
+			wantProgress: []CopyProgress{{Keys: 3, Batches: 1, LastKey: "discourse/3"}, {Keys: 5, Batches: 2, LastKey: "discourse/5"}},
+		},
+		{
+			name:         "resumes after a key",
+			opts:         CopyOptions{StartAfter: "discourse/3"},
+			want:         2,
+			wantProgress: []CopyProgress{{Keys: 2, Batches: 1, LastKey: "discourse/5"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			for _, key := range keys {
+				m.Set(ctx, key, []byte(key))
+			}
+			m.Set(ctx, "other/1", []byte("other"))
+
+			var progress []CopyProgress
+			tt.opts.Progress = func(p CopyProgress) { progress = append(progress, p) }
+
+			transfer := CopyPrefix
+			if tt.move {
+				transfer = MovePrefix
+			}
+
+			n, err := transfer(ctx, m, "discourse/", "forums/tigris/", tt.opts)
+			if err != nil {
+				t.Fatalf("transfer error = %v", err)
+			}
+			if n != tt.want {
+				t.Errorf("transfer = %d keys, want %d", n, tt.want)
+			}
+			if !slices.Equal(progress, tt.wantProgress) {
+				t.Errorf("progress = %+v, want %+v", progress, tt.wantProgress)
+			}
+
+			for _, key := range keys[len(keys)-tt.want:] {
+				got, err := m.Get(ctx, "forums/tigris/"+key[len("discourse/"):])
+				if err != nil || string(got) != key {
+					t.Errorf("Get() of the copy of %s = %q, %v", key, got, err)
+				}
+
+				err = m.Exists(ctx, key)
+				if tt.move && !errors.Is(err, ErrNotFound) {
+					t.Errorf("Exists(%q) after moving error = %v, want %v", key, err, ErrNotFound)
+				}
+				if !tt.move && err != nil {
+					t.Errorf("Exists(%q) after copying error = %v", key, err)
+				}
+			}
+
+			if err := m.Exists(ctx, "other/1"); err != nil {
+				t.Errorf("Exists(other/1) error = %v, want keys outside the prefix left alone", err)
+			}
+		})
+	}
+}
+
+func TestCopyPrefix_IntoItself(t *testing.T) {
+	m := NewMemory()
+	m.Set(context.Background(), "a/1", []byte("1"))
+
+	for _, dst := range []string{"a/", "a/b/", "a", ""} {
+		if _, err := CopyPrefix(context.Background(), m, "a/", dst, CopyOptions{}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CopyPrefix(a/, %s) error = %v, want %v", dst, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestCopyPrefix_Overlap(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		src, dst string
+		want     map[string]string
+	}{
+		{
+			name: "longer prefix",
+			src:  "discourse",
+			dst:  "discourse-thread/",
+			want: map[string]string{"discourse/1": "1", "discourse-thread/1": "old", "discourse-thread//1": "1", "other": "other"},
+		},
+		{
+			name: "whole store",
+			src:  "",
+			dst:  "backup/",
+			want: map[string]string{
+				"discourse/1": "1", "discourse-thread/1": "old", "other": "other",
+				"backup/discourse/1": "1", "backup/discourse-thread/1": "old", "backup/other": "other",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			m.Set(ctx, "discourse/1", []byte("1"))
+			m.Set(ctx, "discourse-thread/1", []byte("old"))
+			m.Set(ctx, "other", []byte("other"))
+
+			if _, err := CopyPrefix(ctx, m, tt.src, tt.dst, CopyOptions{BatchSize: 1}); err != nil {
+				t.Fatalf("CopyPrefix() error = %v", err)
+			}
+
+			if got := storeContents(t, m); !maps.Equal(got, tt.want) {
+				t.Errorf("store holds %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingCopier is a Memory whose copies of one key fail.
+type failingCopier struct {
+	*Memory
+	fail string
+}
+
+func (f *failingCopier) Copy(ctx context.Context, src, dst string) error {
+	if src == f.fail {
+		return fmt.Errorf("%w: can't copy %s", ErrPermission, src)
+	}
+	return f.Memory.Copy(ctx, src, dst)
+}
+
+func TestCopyPrefix_StopsAtFailedBatch(t *testing.T) {
+	ctx := context.Background()
+
+	f := &failingCopier{Memory: NewMemory(), fail: "src/3"}
+	for i := range 6 {
+		f.Set(ctx, fmt.Sprintf("src/%d", i), []byte("value"))
+	}
+
+	var progress []CopyProgress
+	n, err := CopyPrefix(ctx, f, "src/", "dst/", CopyOptions{
+		BatchSize: 2,
+		Progress:  func(p CopyProgress) { progress = append(progress, p) },
+	})
+	if !errors.Is(err, ErrPermission) {
+		t.Fatalf("CopyPrefix() error = %v, want %v", err, ErrPermission)
+	}
+
+	// The second batch copied src/2 before giving up, but the batch isn't
+	// reported as done.
+	if n != 3 {
+		t.Errorf("CopyPrefix() = %d keys, want 3", n)
+	}
+	if want := []CopyProgress{{Keys: 2, Batches: 1, LastKey: "src/1"}}; !slices.Equal(progress, want) {
+		t.Errorf("progress = %+v, want %+v", progress, want)
+	}
+	if err := f.Exists(ctx, "dst/4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists(dst/4) error = %v, want later batches left alone", err)
+	}
+}
+
+// lostMoveStore is a Memory whose first Move works but reports that it
+// failed, like a request whose response is lost.
+type lostMoveStore struct {
+	*Memory
+	lost bool
+}
+
+func (l *lostMoveStore) Move(ctx context.Context, src, dst string) error {
+	if err := l.Memory.Move(ctx, src, dst); err != nil {
+		return err
+	}
+	if !l.lost {
+		l.lost = true
+		return fmt.Errorf("%w: lost response", ErrUnavailable)
+	}
+	return nil
+}
+
+func TestRetry_Move(t *testing.T) {
+	ctx := context.Background()
+
+	s := &lostMoveStore{Memory: NewMemory()}
+	s.Set(ctx, "src", []byte("value"))
+
+	r, delays := newTestRetry(t, s, RetryOptions{})
+	if err := r.Move(ctx, "src", "dst"); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if len(*delays) != 1 {
+		t.Errorf("Move() retried %d times, want 1", len(*delays))
+	}
+
+	if err := r.Move(ctx, "src", "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Move() of a missing key error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestJSON_Copy(t *testing.T) {
+	ctx := context.Background()
+
+	m := NewMemory()
+	s := &JSON[codecTestValue]{Underlying: m, Prefix: "discourse"}
+
+	if err := s.Set(ctx, "1", codecTestValue{Name: "one"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Copy(ctx, "1", "2"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := s.Move(ctx, "1", "3"); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	keys, err := m.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	slices.Sort(keys)
+	if want := []string{"discourse/2", "discourse/3"}; !slices.Equal(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+
+	got, err := s.Get(ctx, "3")
+	if err != nil || got.Name != "one" {
+		t.Errorf("Get() of the moved value = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"iter"
+	"strings"
+)
+
+// Entry is one item of a delimited listing: either a key or a common prefix
+// standing in for every key nested under it.
+type Entry struct {
+	// Name is the full key, or the common prefix including the delimiter it
+	// ends in, eg: discourse-thread/tigris/.
+	Name string
+
+	// IsPrefix reports whether Name is a common prefix rather than a key.
+	IsPrefix bool
+}
+
+// DelimitedIterator is implemented by stores that can list one level of a
+// keyspace at a time, like a directory listing, without walking every key
+// nested under it.
+type DelimitedIterator interface {
+	// IterateDelimited yields the entries under prefix in lexicographic order.
+	// Keys with delimiter after prefix are collapsed into a single common
+	// prefix entry that ends at the first delimiter. An empty delimiter lists
+	// every key.
+	//
+	// opts.StartAfter skips every entry whose name sorts at or before it, so
+	// the name of the last entry seen resumes a listing. opts.Limit counts
+	// entries, not the keys under them.
+	IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error]
+}
+
+// IterateDelimited yields the keys and common prefixes directly under prefix
+// in s. Stores that don't implement DelimitedIterator have every key under
+// prefix walked and collapsed.
+func IterateDelimited(ctx context.Context, s Interface, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	if di, ok := s.(DelimitedIterator); ok {
+		return di.IterateDelimited(ctx, prefix, delimiter, opts)
+	}
+
+	return func(yield func(Entry, error) bool) {
+		var (
+			last  Entry
+			count int
+		)
+
+		for key, err := range Iterate(ctx, s, prefix, ListOptions{StartAfter: opts.StartAfter}) {
+			if err != nil {
+				yield(Entry{}, err)
+				return
+			}
+
+			entry := delimit(prefix, delimiter, key)
+			if entry == last || entry.Name <= opts.StartAfter {
+				continue
+			}
+			last = entry
+
+			if !yield(entry, nil) {
+				return
+			}
+
+			count++
+			if opts.Limit > 0 && count >= opts.Limit {
+				return
+			}
+		}
+	}
+}
+
+// delimit returns the entry key is listed as under prefix: the key itself, or
+// the common prefix ending at the first delimiter after prefix.
+func delimit(prefix, delimiter, key string) Entry {
+	if delimiter == "" {
+		return Entry{Name: key}
+	}
+
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i == -1 {
+		return Entry{Name: key}
+	}
+
+	return Entry{Name: key[:len(prefix)+i+len(delimiter)], IsPrefix: true}
+}
+
+// Listing is one level of a keyspace.
+type Listing struct {
+	// Keys are the keys directly under the listed prefix.
+	Keys []string
+
+	// Prefixes are the common prefixes of the keys nested deeper, each ending
+	// in the delimiter.
+	Prefixes []string
+}
+
+// ListDelimited lists the keys and common prefixes directly under prefix in s,
+// eg: ListDelimited(ctx, s, "discourse-thread/", "/") lists the threads kept
+// directly under discourse-thread/ and the categories nested under it.
+func ListDelimited(ctx context.Context, s Interface, prefix, delimiter string) (Listing, error) {
+	var result Listing
+
+	for entry, err := range IterateDelimited(ctx, s, prefix, delimiter, ListOptions{}) {
+		if err != nil {
+			return Listing{}, err
+		}
+
+		if entry.IsPrefix {
+			result.Prefixes = append(result.Prefixes, entry.Name)
+		} else {
+			result.Keys = append(result.Keys, entry.Name)
+		}
+	}
+
+	return result, nil
+}
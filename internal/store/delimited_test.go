@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+var delimitedTestKeys = []string{"a/1", "a/2", "a/b/3", "b", "c/1", "d"}
+
+func TestIterateDelimited(t *testing.T) {
+	ctx := context.Background()
+
+	stores := []struct {
+		name string
+		new  func(t *testing.T) Interface
+	}{
+		{name: "memory", new: func(t *testing.T) Interface { return NewMemory() }},
+		{name: "directory", new: func(t *testing.T) Interface { return newTestDirectory(t, nil) }},
+		{
+			name: "s3api",
+			new: func(t *testing.T) Interface {
+				s, _ := newTestS3API(t)
+				return s
+			},
+		},
+		{
+			name: "s3api paged",
+			new: func(t *testing.T) Interface {
+				s, srv := newTestS3API(t)
+				srv.SetMaxKeys(2)
+				return s
+			},
+		},
+		{name: "prefixed", new: func(t *testing.T) Interface { return NewPrefixed(NewMemory(), "scope") }},
+		{
+			name: "lru",
+			new: func(t *testing.T) Interface {
+				l, err := NewLRU(NewMemory(), LRUOptions{})
+				if err != nil {
+					t.Fatalf("NewLRU() error = %v", err)
+				}
+				return l
+			},
+		},
+		{
+			name: "encrypted",
+			new: func(t *testing.T) Interface {
+				return newTestEncrypted(t, NewMemory(), EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+			},
+		},
+		{name: "observed", new: func(t *testing.T) Interface { return observed(NewMemory(), "test-delimited") }},
+		{
+			name: "retry",
+			new: func(t *testing.T) Interface {
+				r, _ := newTestRetry(t, NewMemory(), RetryOptions{})
+				return r
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		prefix    string
+		delimiter string
+		opts      ListOptions
+		want      []Entry
+	}{
+		{
+			name:      "collapses nested keys at the top level",
+			delimiter: "/",
+			want:      []Entry{{"a/", true}, {"b", false}, {"c/", true}, {"d", false}},
+		},
+		{
+			name:      "lists the children of a prefix",
+			prefix:    "a/",
+			delimiter: "/",
+			want:      []Entry{{"a/1", false}, {"a/2", false}, {"a/b/", true}},
+		},
+		{
+			name:      "lists a prefix without its delimiter",
+			prefix:    "a",
+			delimiter: "/",
+			want:      []Entry{{"a/", true}},
+		},
+		{
+			name:   "lists every key without a delimiter",
+			prefix: "a/",
+			want:   []Entry{{"a/1", false}, {"a/2", false}, {"a/b/3", false}},
+		},
+		{
+			name:      "resumes after a common prefix",
+			delimiter: "/",
+			opts:      ListOptions{StartAfter: "a/"},
+			want:      []Entry{{"b", false}, {"c/", true}, {"d", false}},
+		},
+		{
+			name:      "skips the common prefix a key inside it starts after",
+			delimiter: "/",
+			opts:      ListOptions{StartAfter: "a/1"},
+			want:      []Entry{{"b", false}, {"c/", true}, {"d", false}},
+		},
+		{
+			name:      "counts entries towards the limit",
+			delimiter: "/",
+			opts:      ListOptions{Limit: 3},
+			want:      []Entry{{"a/", true}, {"b", false}, {"c/", true}},
+		},
+		{
+			name:      "lists nothing under a missing prefix",
+			prefix:    "nope/",
+			delimiter: "/",
+		},
+	}
+
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			s := st.new(t)
+			for _, key := range delimitedTestKeys {
+				if err := s.Set(ctx, key, []byte(key)); err != nil {
+					t.Fatalf("Set(%q) error = %v", key, err)
+				}
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					var got []Entry
+					for entry, err := range IterateDelimited(ctx, s, tt.prefix, tt.delimiter, tt.opts) {
+						if err != nil {
+							t.Fatalf("IterateDelimited() error = %v", err)
+						}
+						got = append(got, entry)
+					}
+
+					if !slices.Equal(got, tt.want) {
+						t.Errorf("IterateDelimited() = %v, want %v", got, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestListDelimited(t *testing.T) {
+	m := NewMemory()
+	for _, key := range delimitedTestKeys {
+		m.Set(context.Background(), key, []byte(key))
+	}
+
+	got, err := ListDelimited(context.Background(), m, "", "/")
+	if err != nil {
+		t.Fatalf("ListDelimited() error = %v", err)
+	}
+
+	if want := []string{"b", "d"}; !slices.Equal(got.Keys, want) {
+		t.Errorf("ListDelimited() keys = %v, want %v", got.Keys, want)
+	}
+	if want := []string{"a/", "c/"}; !slices.Equal(got.Prefixes, want) {
+		t.Errorf("ListDelimited() prefixes = %v, want %v", got.Prefixes, want)
+	}
+}
+
+func TestRetry_IterateDelimited(t *testing.T) {
+	f := newFlakyStore(map[string]int{"Iterate": 1})
+	f.iterateFailAfter = 2
+	for _, key := range delimitedTestKeys {
+		f.Memory.Set(context.Background(), key, []byte(key))
+	}
+
+	r, delays := newTestRetry(t, f, RetryOptions{})
+
+	var got []Entry
+	for entry, err := range r.IterateDelimited(context.Background(), "", "/", ListOptions{}) {
+		if err != nil {
+			t.Fatalf("IterateDelimited() error = %v", err)
+		}
+		got = append(got, entry)
+	}
+
+	if want := []Entry{{"a/", true}, {"b", false}, {"c/", true}, {"d", false}}; !slices.Equal(got, want) {
+		t.Errorf("IterateDelimited() = %v, want %v", got, want)
+	}
+	if len(*delays) != 1 {
+		t.Errorf("IterateDelimited() retried %d times, want 1", len(*delays))
+	}
+}
+
+func TestJSON_IterateDelimited(t *testing.T) {
+	ctx := context.Background()
+
+	m := NewMemory()
+	for _, key := range []string{"discourse-thread/1", "discourse-thread/tigris/2", "discourse-thread/tigris/3", "discourse-thread/tigris/sub/4", "other/5"} {
+		m.Set(ctx, key, []byte(`{}`))
+	}
+
+	threads := &JSON[struct{}]{Underlying: m, Prefix: "discourse-thread"}
+
+	got, err := threads.ListDelimited(ctx, "", "/")
+	if err != nil {
+		t.Fatalf("ListDelimited() error = %v", err)
+	}
+	if !slices.Equal(got.Keys, []string{"1"}) || !slices.Equal(got.Prefixes, []string{"tigris/"}) {
+		t.Errorf("ListDelimited() = %+v, want keys [1] and prefixes [tigris/]", got)
+	}
+
+	tigris := threads.Sub("tigris")
+	if tigris.Prefix != "discourse-thread/tigris" {
+		t.Errorf("Sub() prefix = %q, want discourse-thread/tigris", tigris.Prefix)
+	}
+
+	var entries []Entry
+	for entry, err := range tigris.IterateDelimited(ctx, "", "/", ListOptions{StartAfter: "2"}) {
+		if err != nil {
+			t.Fatalf("IterateDelimited() error = %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	if want := []Entry{{"3", false}, {"sub/", true}}; !slices.Equal(entries, want) {
+		t.Errorf("IterateDelimited() = %v, want %v", entries, want)
+	}
+
+	root := &JSON[struct{}]{Underlying: m}
+	got, err = root.ListDelimited(ctx, "", "/")
+	if err != nil {
+		t.Fatalf("ListDelimited() error = %v", err)
+	}
+	if want := []string{"discourse-thread/", "other/"}; !slices.Equal(got.Prefixes, want) || len(got.Keys) != 0 {
+		t.Errorf("ListDelimited() without a prefix = %+v, want prefixes %v", got, want)
+	}
+}
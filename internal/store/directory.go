@@ -0,0 +1,398 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// tempPrefix marks in-flight writes so that List never reports them as keys.
+const tempPrefix = ".glue-tmp-"
+
+// NewDirectory creates a store backed by files in the given root directory,
+// creating it if it does not already exist. Keys containing slashes are stored
+// in subdirectories, so the key "seen-urls/abcd" becomes the file
+// root/seen-urls/abcd.
+//
+// Because keys map directly onto files, a key can't be both a value and the
+// parent of other keys (eg: "foo" and "foo/bar"). This is fine for the
+// prefix/key layout the commands in this repo use.
+func NewDirectory(root string) (*Directory, error) {
+	if root == "" {
+		return nil, fmt.Errorf("%w: directory root must not be empty", ErrBadConfig)
+	}
+
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("%w: can't create directory %s: %w", ErrBadConfig, root, err)
+	}
+
+	return &Directory{root: root}, nil
+}
+
+// Directory is a store.Interface backed by a directory on the local filesystem.
+// Writes are atomic: values are written to a temporary file and then renamed
+// into place.
+type Directory struct {
+	root string
+	lock sync.Mutex // serializes renames so SetIf can check then write
+}
+
+// path converts a key into a filesystem path under the root directory.
+func (d *Directory) path(key string) (string, error) {
+	if key == "" || path.Clean(key) != key || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	if slices.ContainsFunc(strings.Split(key, "/"), func(s string) bool { return strings.HasPrefix(s, tempPrefix) }) {
+		return "", fmt.Errorf("%w: %q uses reserved prefix %s", ErrInvalidKey, key, tempPrefix)
+	}
+
+	return filepath.Join(d.root, filepath.FromSlash(key)), nil
+}
+
+func (d *Directory) Delete(ctx context.Context, key string) error {
+	fname, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := d.exists(fname); err != nil {
+		return err
+	}
+
+	if err := os.Remove(fname); err != nil {
+		return fmt.Errorf("can't delete %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (d *Directory) Exists(ctx context.Context, key string) error {
+	fname, err := d.path(key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	return d.exists(fname)
+}
+
+func (d *Directory) exists(fname string) error {
+	st, err := os.Stat(fname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return fmt.Errorf("can't stat %s: %w", fname, err)
+	}
+
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s is not a regular file", ErrNotFound, fname)
+	}
+
+	return nil
+}
+
+func (d *Directory) Get(ctx context.Context, key string) ([]byte, error) {
+	fname, err := d.path(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	if err := d.exists(fname); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return nil, fmt.Errorf("can't read %s: %w", key, err)
+	}
+
+	return data, nil
+}
+
+func (d *Directory) Set(ctx context.Context, key string, value []byte) error {
+	return d.SetReader(ctx, key, bytes.NewReader(value), StreamInfo{ContentLength: int64(len(value))})
+}
+
+// GetReader opens the file holding a value for reading.
+func (d *Directory) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	fname, err := d.path(key)
+	if err != nil {
+		return nil, StreamInfo{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	if err := d.exists(fname); err != nil {
+		return nil, StreamInfo{}, err
+	}
+
+	fin, err := os.Open(fname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, StreamInfo{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return nil, StreamInfo{}, fmt.Errorf("can't open %s: %w", key, err)
+	}
+
+	st, err := fin.Stat()
+	if err != nil {
+		fin.Close()
+		return nil, StreamInfo{}, fmt.Errorf("can't stat %s: %w", key, err)
+	}
+
+	return fin, StreamInfo{ContentLength: st.Size()}, nil
+}
+
+// SetReader copies r into a temporary file and renames it into place. The
+// content type isn't stored.
+func (d *Directory) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	fname, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmpName, err := writeTemp(filepath.Dir(fname), r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := os.Rename(tmpName, fname); err != nil {
+		return fmt.Errorf("can't rename %s to %s: %w", tmpName, fname, err)
+	}
+
+	return nil
+}
+
+// Move renames the file holding src's value, so it is atomic and doesn't
+// copy the value.
+func (d *Directory) Move(ctx context.Context, src, dst string) error {
+	srcName, err := d.path(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	dstName, err := d.path(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstName), 0o755); err != nil {
+		return fmt.Errorf("can't create directory %s: %w", filepath.Dir(dstName), err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := d.exists(srcName); err != nil {
+		return err
+	}
+
+	if err := os.Rename(srcName, dstName); err != nil {
+		return fmt.Errorf("can't rename %s to %s: %w", srcName, dstName, err)
+	}
+
+	return nil
+}
+
+// Stat describes the file holding a value. Directory doesn't keep content
+// types or metadata, and Version is left empty since it would mean reading
+// the whole file.
+func (d *Directory) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	fname, err := d.path(key)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	st, err := os.Stat(fname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return ObjectInfo{}, fmt.Errorf("can't stat %s: %w", key, err)
+	}
+
+	if !st.Mode().IsRegular() {
+		return ObjectInfo{}, fmt.Errorf("%w: %s is not a regular file", ErrNotFound, fname)
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         st.Size(),
+		LastModified: st.ModTime(),
+	}, nil
+}
+
+// GetVersion returns a value along with its version, the SHA-256 hash of its
+// contents.
+func (d *Directory) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	data, err := d.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, contentVersion(data), nil
+}
+
+// SetIf writes a value if cond holds and returns its new version.
+// cond.IfNotExists is atomic across processes sharing the directory, but
+// cond.IfVersion is only atomic between users of this Directory. Directory
+// doesn't keep TTLs or metadata, so cond.Options are ignored.
+func (d *Directory) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	fname, err := d.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	tmpName, err := writeTemp(filepath.Dir(fname), bytes.NewReader(value))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpName)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if cond.IfVersion != "" {
+		current, err := os.ReadFile(fname)
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		if err != nil || contentVersion(current) != cond.IfVersion {
+			return "", fmt.Errorf("%w: %s is not at version %s", ErrConflict, key, cond.IfVersion)
+		}
+	}
+
+	if cond.IfNotExists {
+		// Hard links fail if the target exists, unlike renames.
+		if err := os.Link(tmpName, fname); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return "", fmt.Errorf("%w: %s already exists", ErrConflict, key)
+			}
+			return "", fmt.Errorf("can't link %s to %s: %w", tmpName, fname, err)
+		}
+
+		return contentVersion(value), nil
+	}
+
+	if err := os.Rename(tmpName, fname); err != nil {
+		return "", fmt.Errorf("can't rename %s to %s: %w", tmpName, fname, err)
+	}
+
+	return contentVersion(value), nil
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTemp copies r to a new temporary file in dir and returns its name. The
+// caller renames it into place so readers never see a partially written value.
+func writeTemp(dir string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("can't create directory %s: %w", dir, err)
+	}
+
+	fout, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("can't create temporary file: %w", err)
+	}
+	tmpName := fout.Name()
+
+	if _, err := io.Copy(fout, r); err != nil {
+		fout.Close()
+		os.Remove(tmpName)
+		return "", fmt.Errorf("can't write %s: %w", tmpName, err)
+	}
+
+	if err := fout.Sync(); err != nil {
+		fout.Close()
+		os.Remove(tmpName)
+		return "", fmt.Errorf("can't sync %s: %w", tmpName, err)
+	}
+
+	if err := fout.Close(); err != nil {
+		os.Remove(tmpName)
+		return "", fmt.Errorf("can't close %s: %w", tmpName, err)
+	}
+
+	return tmpName, nil
+}
+
+func (d *Directory) List(ctx context.Context, prefix string) ([]string, error) {
+	// Only walk the deepest directory the prefix names, eg: "foo/ba" only needs
+	// to look in "foo".
+	start := d.root
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		dir := prefix[:i]
+		if !filepath.IsLocal(filepath.FromSlash(dir)) {
+			return nil, nil
+		}
+		start = filepath.Join(d.root, filepath.FromSlash(dir))
+	}
+
+	var result []string
+
+	err := filepath.WalkDir(start, func(fname string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(de.Name(), tempPrefix) {
+			if de.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !de.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.root, fname)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list items: %w", err)
+	}
+
+	// Match the lexicographic ordering S3 uses.
+	slices.Sort(result)
+
+	return result, nil
+}
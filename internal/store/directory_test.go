@@ -0,0 +1,465 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDirectory(t *testing.T, data map[string][]byte) *Directory {
+	t.Helper()
+
+	d, err := NewDirectory(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirectory() error = %v", err)
+	}
+
+	for k, v := range data {
+		if err := d.Set(context.Background(), k, v); err != nil {
+			t.Fatalf("Set(%q) error = %v", k, err)
+		}
+	}
+
+	return d
+}
+
+func TestNewDirectory(t *testing.T) {
+	t.Run("creates missing root", func(t *testing.T) {
+		root := filepath.Join(t.TempDir(), "a", "b")
+		if _, err := NewDirectory(root); err != nil {
+			t.Fatalf("NewDirectory() error = %v", err)
+		}
+
+		if st, err := os.Stat(root); err != nil || !st.IsDir() {
+			t.Errorf("NewDirectory() didn't create %s: %v", root, err)
+		}
+	})
+
+	t.Run("rejects empty root", func(t *testing.T) {
+		if _, err := NewDirectory(""); !errors.Is(err, ErrBadConfig) {
+			t.Errorf("NewDirectory() error = %v, want %v", err, ErrBadConfig)
+		}
+	})
+}
+
+func TestDirectory_Exists(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		key     string
+		wantErr error
+	}{
+		{
+			name:    "returns nil when key exists",
+			data:    map[string][]byte{"existing-key": []byte("value")},
+			key:     "existing-key",
+			wantErr: nil,
+		},
+		{
+			name:    "returns nil when nested key exists",
+			data:    map[string][]byte{"foo/bar/baz": []byte("value")},
+			key:     "foo/bar/baz",
+			wantErr: nil,
+		},
+		{
+			name:    "returns ErrNotFound when key does not exist",
+			key:     "non-existent-key",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "returns ErrNotFound for a directory",
+			data:    map[string][]byte{"foo/bar": []byte("value")},
+			key:     "foo",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "returns ErrNotFound for empty key",
+			data:    map[string][]byte{"other-key": []byte("value")},
+			key:     "",
+			wantErr: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDirectory(t, tt.data)
+
+			err := d.Exists(context.Background(), tt.key)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDirectory_Delete(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		key     string
+		wantErr error
+	}{
+		{
+			name:    "deletes existing key",
+			data:    map[string][]byte{"delete-me": []byte("value")},
+			key:     "delete-me",
+			wantErr: nil,
+		},
+		{
+			name:    "deletes nested key",
+			data:    map[string][]byte{"foo/delete-me": []byte("value")},
+			key:     "foo/delete-me",
+			wantErr: nil,
+		},
+		{
+			name:    "returns ErrNotFound for non-existent key",
+			key:     "non-existent",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "rejects keys outside the root",
+			key:     "../escape",
+			wantErr: ErrInvalidKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDirectory(t, tt.data)
+
+			err := d.Delete(context.Background(), tt.key)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err := d.Exists(context.Background(), tt.key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Delete() key %q still exists in store", tt.key)
+			}
+		})
+	}
+}
+
+func TestDirectory_Get(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		key     string
+		want    []byte
+		wantErr error
+	}{
+		{
+			name: "returns value for existing key",
+			data: map[string][]byte{"mykey": []byte("myvalue")},
+			key:  "mykey",
+			want: []byte("myvalue"),
+		},
+		{
+			name:    "returns ErrNotFound for non-existent key",
+			key:     "non-existent",
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "returns empty byte slice for key with empty value",
+			data: map[string][]byte{"empty-val": {}},
+			key:  "empty-val",
+			want: []byte{},
+		},
+		{
+			name: "returns binary data correctly",
+			data: map[string][]byte{"binary": {0x00, 0x01, 0x02, 0xff, 0xfe, 0xfd}},
+			key:  "binary",
+			want: []byte{0x00, 0x01, 0x02, 0xff, 0xfe, 0xfd},
+		},
+		{
+			name:    "returns ErrNotFound for keys outside the root",
+			key:     "../../etc/passwd",
+			wantErr: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDirectory(t, tt.data)
+
+			got, err := d.Get(context.Background(), tt.key)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.want != nil && !equalBytes(got, tt.want) {
+				t.Errorf("Get() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectory_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		key     string
+		value   []byte
+		wantErr error
+	}{
+		{
+			name:  "sets new key",
+			key:   "new-key",
+			value: []byte("new-value"),
+		},
+		{
+			name:  "overwrites existing key",
+			data:  map[string][]byte{"existing-key": []byte("old-value")},
+			key:   "existing-key",
+			value: []byte("new-value"),
+		},
+		{
+			name:  "sets nested key",
+			key:   "foo/bar/baz",
+			value: []byte("nested-value"),
+		},
+		{
+			name:  "sets empty value",
+			key:   "empty-value-key",
+			value: []byte{},
+		},
+		{
+			name:    "rejects empty key",
+			key:     "",
+			value:   []byte("empty-key-value"),
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "rejects keys with trailing slash",
+			key:     "foo/",
+			value:   []byte("value"),
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "rejects reserved temporary file names",
+			key:     "foo/" + tempPrefix + "1234",
+			value:   []byte("value"),
+			wantErr: ErrInvalidKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDirectory(t, tt.data)
+
+			err := d.Set(context.Background(), tt.key, tt.value)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			got, err := d.Get(context.Background(), tt.key)
+			if err != nil {
+				t.Fatalf("Get() unexpected error = %v", err)
+			}
+
+			if !equalBytes(got, tt.value) {
+				t.Errorf("Set() stored value = %v, want %v", got, tt.value)
+			}
+
+			filepath.WalkDir(d.root, func(fname string, de fs.DirEntry, err error) error {
+				if strings.HasPrefix(de.Name(), tempPrefix) {
+					t.Errorf("Set() left temporary file %s behind", fname)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestDirectory_List(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   map[string][]byte
+		prefix string
+		want   []string
+	}{
+		{
+			name: "lists keys with matching prefix",
+			data: map[string][]byte{
+				"foo/a": []byte("1"),
+				"foo/b": []byte("2"),
+				"foo/c": []byte("3"),
+				"bar/a": []byte("4"),
+			},
+			prefix: "foo/",
+			want:   []string{"foo/a", "foo/b", "foo/c"},
+		},
+		{
+			name: "walks subdirectories",
+			data: map[string][]byte{
+				"foo/a":     []byte("1"),
+				"foo/b/c":   []byte("2"),
+				"foo/b/d/e": []byte("3"),
+				"foobar":    []byte("4"),
+			},
+			prefix: "foo/",
+			want:   []string{"foo/a", "foo/b/c", "foo/b/d/e"},
+		},
+		{
+			name: "matches partial path segments",
+			data: map[string][]byte{
+				"foo/bar/a": []byte("1"),
+				"foo/baz":   []byte("2"),
+				"foo/qux":   []byte("3"),
+			},
+			prefix: "foo/ba",
+			want:   []string{"foo/bar/a", "foo/baz"},
+		},
+		{
+			name:   "returns empty list for non-existent prefix",
+			data:   map[string][]byte{"other/key": []byte("value")},
+			prefix: "noprefix/",
+			want:   []string{},
+		},
+		{
+			name: "lists all keys with empty prefix",
+			data: map[string][]byte{
+				"a": []byte("1"),
+				"b": []byte("2"),
+				"c": []byte("3"),
+			},
+			prefix: "",
+			want:   []string{"a", "b", "c"},
+		},
+		{
+			name:   "returns empty list for empty store",
+			prefix: "",
+			want:   []string{},
+		},
+		{
+			name: "lists keys with single character prefix",
+			data: map[string][]byte{
+				"a1": []byte("1"),
+				"a2": []byte("2"),
+				"b1": []byte("3"),
+			},
+			prefix: "a",
+			want:   []string{"a1", "a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDirectory(t, tt.data)
+
+			got, err := d.List(context.Background(), tt.prefix)
+			if err != nil {
+				t.Errorf("List() error = %v", err)
+			}
+
+			if !equalStringSlicesUnordered(got, tt.want) {
+				t.Errorf("List() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectory_JSON(t *testing.T) {
+	type testStruct struct {
+		Name string `json:"name"`
+	}
+
+	d := newTestDirectory(t, nil)
+	j := &JSON[testStruct]{
+		Underlying: d,
+		Prefix:     "testprefix",
+	}
+
+	ctx := context.Background()
+
+	if err := j.Set(ctx, "mykey", testStruct{Name: "test"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := j.Get(ctx, "mykey")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if got.Name != "test" {
+		t.Errorf("Get() got = %v, want name %q", got, "test")
+	}
+
+	keys, err := j.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	if !equalStringSlicesUnordered(keys, []string{"mykey"}) {
+		t.Errorf("List() got = %v, want [mykey]", keys)
+	}
+}
+
+func TestDirectory_Stream(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDirectory(t, nil)
+
+	want := strings.Repeat("streamed ", 1024)
+	if err := d.SetReader(ctx, "foo/stream", strings.NewReader(want), StreamInfo{ContentLength: -1}); err != nil {
+		t.Fatalf("SetReader() error = %v", err)
+	}
+
+	rc, info, err := d.GetReader(ctx, "foo/stream")
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer rc.Close()
+
+	if info.ContentLength != int64(len(want)) {
+		t.Errorf("GetReader() content length = %d, want %d", info.ContentLength, len(want))
+	}
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("GetReader() returned %d bytes, want %d", len(got), len(want))
+	}
+
+	if _, _, err := d.GetReader(ctx, "foo/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetReader() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDirectory_Stat(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDirectory(t, map[string][]byte{"dir/key": []byte("value")})
+
+	before := time.Now().Add(-time.Minute)
+
+	info, err := d.Stat(ctx, "dir/key")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Key != "dir/key" || info.Size != 5 {
+		t.Errorf("Stat() = %+v, want key dir/key and size 5", info)
+	}
+	if info.LastModified.Before(before) {
+		t.Errorf("Stat() LastModified = %v, want after %v", info.LastModified, before)
+	}
+
+	for _, key := range []string{"missing", "dir", "../escape"} {
+		if _, err := d.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat(%q) error = %v, want %v", key, err, ErrNotFound)
+		}
+	}
+}
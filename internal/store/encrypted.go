@@ -0,0 +1,570 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"time"
+)
+
+// Encrypted values start with encryptedMagic and encryptedVersion, followed
+// by:
+//
+//   - the length of the key ID (one byte) and the key ID,
+//   - a nonce and the value's data key encrypted with that key,
+//   - a nonce and the value encrypted with the data key.
+//
+// Everything before each ciphertext is authenticated along with it, followed
+// by the store key the value was written to, so neither the key ID nor the
+// value's place in the store can be swapped out. Version 1 values didn't
+// authenticate the store key; they can still be read, and Rotate rewrites
+// them.
+var encryptedMagic = []byte{0xff, 'g', 'e', 'n', 'c'}
+
+const (
+	encryptedVersion = 2
+	dataKeySize      = 32
+)
+
+// EncryptionKey is a named AES key used to encrypt values.
+type EncryptionKey struct {
+	// ID names the key in every value it encrypts so the right key can be found
+	// to decrypt it. It must be 1 to 255 bytes long.
+	ID string
+
+	// Key is a 16, 24 or 32 byte AES key.
+	Key []byte
+}
+
+// ParseEncryptionKeys parses a comma-separated list of id:key pairs with
+// base64-encoded keys, eg: "2025-06:c2VjcmV0...,2024-01:b2xkZXI...", in the
+// order EncryptedOptions.Keys expects.
+func ParseEncryptionKeys(s string) ([]EncryptionKey, error) {
+	var result []EncryptionKey
+
+	for i, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		// Don't put the pair in errors, it's a secret.
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: encryption key %d isn't in id:key form", ErrBadConfig, i+1)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: encryption key %q isn't valid base64: %w", ErrBadConfig, id, err)
+		}
+
+		result = append(result, EncryptionKey{ID: id, Key: key})
+	}
+
+	return result, nil
+}
+
+// EncryptedOptions configure an Encrypted store.
+type EncryptedOptions struct {
+	// Keys are the keys values can be encrypted with. New values are encrypted
+	// with the first key, the rest are only used to decrypt values written
+	// before it was added. To rotate keys, put the new key first and run
+	// Rotate.
+	Keys []EncryptionKey
+
+	// AllowPlaintext makes values without encryption readable as they are, so
+	// that a store with existing values can start being encrypted. Rotate
+	// encrypts them.
+	AllowPlaintext bool
+}
+
+// Encrypted is a store.Interface that encrypts values before writing them to
+// another store. Each value is encrypted with AES-GCM using its own random data
+// key, which is in turn encrypted with one of the configured keys and stored
+// alongside it. Keys and listings aren't encrypted, but values are bound to
+// their keys: a value copied to another key in the underlying store won't
+// decrypt, so copy and move them through Encrypted instead.
+type Encrypted struct {
+	underlying     Interface
+	primary        string
+	keys           map[string]cipher.AEAD
+	allowPlaintext bool
+}
+
+// NewEncrypted wraps underlying so that values are encrypted with opts.Keys.
+func NewEncrypted(underlying Interface, opts EncryptedOptions) (*Encrypted, error) {
+	if len(opts.Keys) == 0 {
+		return nil, fmt.Errorf("%w: at least one encryption key is needed", ErrBadConfig)
+	}
+
+	e := &Encrypted{
+		underlying:     underlying,
+		primary:        opts.Keys[0].ID,
+		keys:           map[string]cipher.AEAD{},
+		allowPlaintext: opts.AllowPlaintext,
+	}
+
+	for _, k := range opts.Keys {
+		if len(k.ID) == 0 || len(k.ID) > 255 {
+			return nil, fmt.Errorf("%w: encryption key ID %q must be 1 to 255 bytes long", ErrBadConfig, k.ID)
+		}
+
+		if _, ok := e.keys[k.ID]; ok {
+			return nil, fmt.Errorf("%w: encryption key ID %q is used more than once", ErrBadConfig, k.ID)
+		}
+
+		aead, err := newGCM(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: encryption key %q: %w", ErrBadConfig, k.ID, err)
+		}
+
+		e.keys[k.ID] = aead
+	}
+
+	return e, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the value of key with a new data key under the primary key.
+func (e *Encrypted) seal(key string, value []byte) ([]byte, error) {
+	kek := e.keys[e.primary]
+
+	dataKey := make([]byte, dataKeySize)
+	rand.Read(dataKey)
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCantEncode, err)
+	}
+
+	result := append(bytes.Clone(encryptedMagic), encryptedVersion, byte(len(e.primary)))
+	result = append(result, e.primary...)
+
+	// Seal's dst and additional data must not overlap.
+	aad := append(bytes.Clone(result), key...)
+	nonce := randomNonce(kek)
+	result = append(result, nonce...)
+	result = kek.Seal(result, nonce, dataKey, aad)
+
+	aad = append(bytes.Clone(result), key...)
+	nonce = randomNonce(aead)
+	result = append(result, nonce...)
+	result = aead.Seal(result, nonce, value, aad)
+
+	return result, nil
+}
+
+func randomNonce(aead cipher.AEAD) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return nonce
+}
+
+// keyID returns the ID of the key data was encrypted with and the version of
+// its format, or false if it isn't encrypted.
+func keyID(data []byte) (string, byte, bool, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return "", 0, false, nil
+	}
+
+	rest := data[len(encryptedMagic):]
+	if len(rest) < 2 {
+		return "", 0, true, errors.New("encryption header is truncated")
+	}
+
+	version := rest[0]
+	if version != 1 && version != encryptedVersion {
+		return "", 0, true, fmt.Errorf("unknown encryption version %d", version)
+	}
+
+	n := int(rest[1])
+	if len(rest) < 2+n {
+		return "", 0, true, errors.New("encryption header is truncated")
+	}
+
+	return string(rest[2 : 2+n]), version, true, nil
+}
+
+// open decrypts a value written by seal.
+func (e *Encrypted) open(key string, data []byte) ([]byte, error) {
+	id, version, encrypted, err := keyID(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrCantDecode, key, err)
+	}
+
+	if !encrypted {
+		if e.allowPlaintext {
+			return data, nil
+		}
+		return nil, fmt.Errorf("%w: %s isn't encrypted", ErrCantDecode, key)
+	}
+
+	kek, ok := e.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is encrypted with unknown key %q", ErrCantDecode, key, id)
+	}
+
+	off := len(encryptedMagic) + 2 + len(id)
+	wrappedLen := kek.NonceSize() + dataKeySize + kek.Overhead()
+	if len(data) < off+wrappedLen {
+		return nil, fmt.Errorf("%w: %s: encrypted data key is truncated", ErrCantDecode, key)
+	}
+
+	// Version 1 values aren't bound to their key.
+	aad := func(n int) []byte {
+		if version == 1 {
+			return data[:n]
+		}
+		return append(bytes.Clone(data[:n]), key...)
+	}
+
+	nonce := data[off : off+kek.NonceSize()]
+	dataKey, err := kek.Open(nil, nonce, data[off+kek.NonceSize():off+wrappedLen], aad(off))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: can't decrypt data key: %w", ErrCantDecode, key, err)
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrCantDecode, key, err)
+	}
+
+	off += wrappedLen
+	if len(data) < off+aead.NonceSize() {
+		return nil, fmt.Errorf("%w: %s: encrypted value is truncated", ErrCantDecode, key)
+	}
+
+	nonce = data[off : off+aead.NonceSize()]
+	value, err := aead.Open(nil, nonce, data[off+aead.NonceSize():], aad(off))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: can't decrypt value: %w", ErrCantDecode, key, err)
+	}
+
+	return value, nil
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key string) error {
+	return e.underlying.Delete(ctx, key)
+}
+
+func (e *Encrypted) Exists(ctx context.Context, key string) error {
+	return e.underlying.Exists(ctx, key)
+}
+
+func (e *Encrypted) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := e.underlying.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.open(key, data)
+}
+
+func (e *Encrypted) Set(ctx context.Context, key string, value []byte) error {
+	data, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return e.underlying.Set(ctx, key, data)
+}
+
+func (e *Encrypted) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	data, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return SetWithTTL(ctx, e.underlying, key, data, ttl)
+}
+
+// GetVersion returns a decrypted value along with the version of its encrypted
+// form in the underlying store.
+func (e *Encrypted) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	data, version, err := GetVersion(ctx, e.underlying, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	value, err := e.open(key, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return value, version, nil
+}
+
+// SetIf encrypts a value and writes it if cond holds. cond.Options are
+// treated the same as by SetWithOptions.
+func (e *Encrypted) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	cs, ok := e.underlying.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, e.underlying)
+	}
+	if cond.Options.Public {
+		return "", fmt.Errorf("%w: encrypted values can't be public", errors.ErrUnsupported)
+	}
+
+	data, err := e.seal(key, value)
+	if err != nil {
+		return "", err
+	}
+
+	cond.Options.ContentType = ""
+	return cs.SetIf(ctx, key, data, cond)
+}
+
+// GetReader decrypts a value in memory, since it can't be authenticated until
+// it has been read in full.
+func (e *Encrypted) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	value, err := e.Get(ctx, key)
+	if err != nil {
+		return nil, StreamInfo{}, err
+	}
+
+	return io.NopCloser(bytes.NewReader(value)), StreamInfo{ContentLength: int64(len(value))}, nil
+}
+
+// SetReader reads r into memory and encrypts it. The content type isn't
+// stored, since it would describe the value rather than its encrypted form.
+func (e *Encrypted) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	value, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("can't read value for %s: %w", key, err)
+	}
+
+	data, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return SetReader(ctx, e.underlying, key, bytes.NewReader(data), StreamInfo{ContentLength: int64(len(data))})
+}
+
+func (e *Encrypted) List(ctx context.Context, prefix string) ([]string, error) {
+	return e.underlying.List(ctx, prefix)
+}
+
+func (e *Encrypted) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return Iterate(ctx, e.underlying, prefix, opts)
+}
+
+func (e *Encrypted) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	return IterateDelimited(ctx, e.underlying, prefix, delimiter, opts)
+}
+
+// Stat describes the encrypted form of a value, so Size includes the
+// encryption overhead.
+func (e *Encrypted) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return Stat(ctx, e.underlying, key)
+}
+
+// SetWithOptions encrypts a value and writes it with opts.Metadata and
+// opts.TTL. Metadata isn't encrypted. The content type isn't stored, for the
+// same reason as in SetReader, and values can't be public since nobody could
+// decrypt them.
+func (e *Encrypted) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	if opts.Public {
+		return fmt.Errorf("%w: encrypted values can't be public", errors.ErrUnsupported)
+	}
+
+	data, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	opts.ContentType = ""
+	return SetWithOptions(ctx, e.underlying, key, data, opts)
+}
+
+// PublicURL always fails, since a URL would serve the encrypted value.
+func (e *Encrypted) PublicURL(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("%w: encrypted values can't be read through URLs", errors.ErrUnsupported)
+}
+
+// PresignedURL always fails, since a URL would serve the encrypted value.
+func (e *Encrypted) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("%w: encrypted values can't be read through URLs", errors.ErrUnsupported)
+}
+
+// GetMany decrypts the values of the keys that exist. Values that the
+// underlying store couldn't read or that can't be decrypted are left out, and
+// the rest are returned along with KeyErrors saying why.
+func (e *Encrypted) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values, err := GetMany(ctx, e.underlying, keys)
+	var underlyingErrs KeyErrors
+	if err != nil && !errors.As(err, &underlyingErrs) {
+		return nil, err
+	}
+
+	errs := KeyErrors{}
+	for key, err := range underlyingErrs {
+		errs[key] = err
+	}
+
+	for key, data := range values {
+		value, err := e.open(key, data)
+		if err != nil {
+			errs[key] = err
+			delete(values, key)
+			continue
+		}
+		values[key] = value
+	}
+
+	if len(errs) != 0 {
+		return values, errs
+	}
+
+	return values, nil
+}
+
+func (e *Encrypted) SetMany(ctx context.Context, values map[string][]byte) error {
+	sealed := make(map[string][]byte, len(values))
+	for key, value := range values {
+		data, err := e.seal(key, value)
+		if err != nil {
+			return err
+		}
+		sealed[key] = data
+	}
+
+	return SetMany(ctx, e.underlying, sealed)
+}
+
+func (e *Encrypted) DeleteMany(ctx context.Context, keys []string) error {
+	return DeleteMany(ctx, e.underlying, keys)
+}
+
+func (e *Encrypted) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	return DeletePrefix(ctx, e.underlying, prefix)
+}
+
+// Copy decrypts the value of src and encrypts it again for dst, since values
+// are bound to their keys. The copy keeps its metadata and expiry.
+func (e *Encrypted) Copy(ctx context.Context, src, dst string) error {
+	value, err := e.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	data, err := e.seal(dst, value)
+	if err != nil {
+		return err
+	}
+
+	opts, err := storedOptions(ctx, e.underlying, src)
+	if err != nil {
+		return err
+	}
+
+	return SetWithOptions(ctx, e.underlying, dst, data, opts)
+}
+
+// Move copies the value of src to dst with Copy and then deletes src, so a
+// failed delete can leave it in both places.
+func (e *Encrypted) Move(ctx context.Context, src, dst string) error {
+	if src == dst {
+		return e.Exists(ctx, src)
+	}
+
+	if err := e.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+
+	if err := e.underlying.Delete(ctx, src); err != nil {
+		return fmt.Errorf("copied %s to %s but can't delete it: %w", src, dst, err)
+	}
+
+	return nil
+}
+
+// Rotate re-encrypts every value under prefix that isn't encrypted with the
+// first key in the current format, including plaintext values if
+// AllowPlaintext is set, and returns
+// how many values it rewrote. If the underlying store supports conditional
+// writes, values that change while Rotate is running are left alone;
+// otherwise nothing else should write to the store while it runs. Rewritten
+// values keep their metadata and expiry.
+func (e *Encrypted) Rotate(ctx context.Context, prefix string) (int, error) {
+	cs, conditional := e.underlying.(ConditionalSetter)
+	rotated := 0
+
+	for key, err := range Iterate(ctx, e.underlying, prefix, ListOptions{}) {
+		if err != nil {
+			return rotated, err
+		}
+
+		var (
+			data    []byte
+			version string
+		)
+
+		if conditional {
+			data, version, err = cs.GetVersion(ctx, key)
+		} else {
+			data, err = e.underlying.Get(ctx, key)
+		}
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return rotated, err
+		}
+
+		if id, version, _, _ := keyID(data); id == e.primary && version == encryptedVersion {
+			continue
+		}
+
+		value, err := e.open(key, data)
+		if err != nil {
+			return rotated, err
+		}
+
+		sealed, err := e.seal(key, value)
+		if err != nil {
+			return rotated, err
+		}
+
+		opts, err := storedOptions(ctx, e.underlying, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return rotated, err
+		}
+
+		if conditional {
+			_, err = cs.SetIf(ctx, key, sealed, Condition{IfVersion: version, Options: opts})
+			if errors.Is(err, ErrConflict) || errors.Is(err, ErrNotFound) {
+				continue
+			}
+		} else {
+			err = SetWithOptions(ctx, e.underlying, key, sealed, opts)
+		}
+		if err != nil {
+			return rotated, err
+		}
+
+		rotated++
+	}
+
+	return rotated, nil
+}
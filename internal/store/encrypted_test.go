@@ -0,0 +1,430 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testKey(id string, b byte) EncryptionKey {
+	return EncryptionKey{ID: id, Key: bytes.Repeat([]byte{b}, 32)}
+}
+
+func newTestEncrypted(t *testing.T, underlying Interface, opts EncryptedOptions) *Encrypted {
+	t.Helper()
+
+	e, err := NewEncrypted(underlying, opts)
+	if err != nil {
+		t.Fatalf("NewEncrypted() error = %v", err)
+	}
+
+	return e
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	e := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+
+	if err := e.Set(ctx, "key", []byte("secret value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	raw, err := m.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("underlying Get() error = %v", err)
+	}
+	if bytes.Contains(raw, []byte("secret value")) {
+		t.Error("underlying store holds the plaintext value")
+	}
+
+	got, err := e.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "secret value" {
+		t.Errorf("Get() got = %q, want %q", got, "secret value")
+	}
+
+	// Every value gets its own data key and nonces.
+	if err := e.Set(ctx, "other", []byte("secret value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if other, _ := m.Get(ctx, "other"); bytes.Equal(raw, other) {
+		t.Error("encrypting the same value twice gave the same result")
+	}
+
+	// Values are bound to their key, so they can't be swapped in the
+	// underlying store, only copied through Encrypted.
+	if err := m.Set(ctx, "other", raw); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := e.Get(ctx, "other"); !errors.Is(err, ErrCantDecode) {
+		t.Errorf("Get() of a value copied in the underlying store error = %v, want %v", err, ErrCantDecode)
+	}
+
+	if err := e.Move(ctx, "key", "moved"); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if got, err := e.Get(ctx, "moved"); err != nil || string(got) != "secret value" {
+		t.Errorf("Get() of moved value got = %q, %v", got, err)
+	}
+	if err := m.Exists(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() after Move() error = %v, want %v", err, ErrNotFound)
+	}
+
+	values, err := e.GetMany(ctx, []string{"moved", "other"})
+	var keyErrs KeyErrors
+	if !errors.As(err, &keyErrs) || !errors.Is(keyErrs["other"], ErrCantDecode) {
+		t.Errorf("GetMany() error = %v, want %v for other", err, ErrCantDecode)
+	}
+	if string(values["moved"]) != "secret value" || len(values) != 1 {
+		t.Errorf("GetMany() = %q, want the value that decrypts", values)
+	}
+}
+
+// sealVersion1 encrypts value the way version 1 did, without binding it to
+// its key.
+func sealVersion1(t *testing.T, k EncryptionKey, value []byte) []byte {
+	t.Helper()
+
+	kek, err := newGCM(k.Key)
+	if err != nil {
+		t.Fatalf("newGCM() error = %v", err)
+	}
+	dataKey := bytes.Repeat([]byte{9}, dataKeySize)
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		t.Fatalf("newGCM() error = %v", err)
+	}
+
+	result := append(bytes.Clone(encryptedMagic), 1, byte(len(k.ID)))
+	result = append(result, k.ID...)
+
+	aad := bytes.Clone(result)
+	nonce := randomNonce(kek)
+	result = append(result, nonce...)
+	result = kek.Seal(result, nonce, dataKey, aad)
+
+	aad = bytes.Clone(result)
+	nonce = randomNonce(aead)
+	result = append(result, nonce...)
+	return aead.Seal(result, nonce, value, aad)
+}
+
+// keyErrorStore fails to read some keys in GetMany, reporting them in a
+// KeyErrors along with the values of the rest.
+type keyErrorStore struct {
+	*Memory
+	errs KeyErrors
+}
+
+func (k *keyErrorStore) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	for _, key := range keys {
+		if _, ok := k.errs[key]; ok {
+			continue
+		}
+		if value, err := k.Memory.Get(ctx, key); err == nil {
+			values[key] = value
+		}
+	}
+
+	return values, k.errs
+}
+
+func (k *keyErrorStore) SetMany(ctx context.Context, values map[string][]byte) error {
+	return SetMany(ctx, k.Memory, values)
+}
+
+func (k *keyErrorStore) DeleteMany(ctx context.Context, keys []string) error {
+	return DeleteMany(ctx, k.Memory, keys)
+}
+
+func (k *keyErrorStore) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	return DeletePrefix(ctx, k.Memory, prefix)
+}
+
+func TestEncrypted_GetManyKeyErrors(t *testing.T) {
+	ctx := context.Background()
+	errRead := errors.New("can't read")
+
+	u := &keyErrorStore{Memory: NewMemory(), errs: KeyErrors{"broken": errRead}}
+	e := newTestEncrypted(t, u, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+
+	for _, key := range []string{"ok", "broken"} {
+		if err := e.Set(ctx, key, []byte("value")); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	values, err := e.GetMany(ctx, []string{"ok", "broken"})
+	var keyErrs KeyErrors
+	if !errors.As(err, &keyErrs) || !errors.Is(keyErrs["broken"], errRead) || len(keyErrs) != 1 {
+		t.Errorf("GetMany() error = %v, want %v for broken", err, errRead)
+	}
+	if string(values["ok"]) != "value" || len(values) != 1 {
+		t.Errorf("GetMany() = %q, want the value that could be read", values)
+	}
+}
+
+func TestEncrypted_Version1(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	k := testKey("a", 1)
+
+	if err := m.Set(ctx, "key", sealVersion1(t, k, []byte("old value"))); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	e := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{k}})
+	if got, err := e.Get(ctx, "key"); err != nil || string(got) != "old value" {
+		t.Errorf("Get() of a version 1 value got = %q, %v", got, err)
+	}
+
+	// Rotate upgrades values to the current version even if the key is the
+	// same.
+	if n, err := e.Rotate(ctx, ""); err != nil || n != 1 {
+		t.Errorf("Rotate() = %d, %v, want 1", n, err)
+	}
+	raw, _ := m.Get(ctx, "key")
+	if _, version, _, _ := keyID(raw); version != encryptedVersion {
+		t.Errorf("value is version %d after Rotate(), want %d", version, encryptedVersion)
+	}
+	if got, err := e.Get(ctx, "key"); err != nil || string(got) != "old value" {
+		t.Errorf("Get() after Rotate() got = %q, %v", got, err)
+	}
+}
+
+func TestEncrypted_Composition(t *testing.T) {
+	ctx := context.Background()
+	opts := EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}}
+
+	type user struct {
+		ActualUID string `json:"actual_uid"`
+	}
+
+	stores := map[string]func(t *testing.T) Interface{
+		"lru over encrypted": func(t *testing.T) Interface {
+			l, err := NewLRUCache(newTestEncrypted(t, NewMemory(), opts))
+			if err != nil {
+				t.Fatalf("NewLRUCache() error = %v", err)
+			}
+			return l
+		},
+		"encrypted over lru": func(t *testing.T) Interface {
+			l, err := NewLRUCache(NewMemory())
+			if err != nil {
+				t.Fatalf("NewLRUCache() error = %v", err)
+			}
+			return newTestEncrypted(t, l, opts)
+		},
+		"encrypted over directory": func(t *testing.T) Interface {
+			return newTestEncrypted(t, newTestDirectory(t, nil), opts)
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			users := &JSON[user]{Underlying: newStore(t), Prefix: "users"}
+			want := user{ActualUID: "1234"}
+
+			if err := users.Set(ctx, "a", want); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			if got, err := users.Get(ctx, "a"); err != nil || got != want {
+				t.Errorf("Get() got = %+v, %v, want %+v", got, err, want)
+			}
+
+			if err := users.Create(ctx, "b", want); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			got, version, err := users.GetVersion(ctx, "b")
+			if err != nil || got != want {
+				t.Fatalf("GetVersion() got = %+v, %v, want %+v", got, err, want)
+			}
+
+			if _, err := users.CompareAndSwap(ctx, "b", version, user{ActualUID: "5678"}); err != nil {
+				t.Errorf("CompareAndSwap() error = %v", err)
+			}
+
+			if err := users.Create(ctx, "b", want); !errors.Is(err, ErrConflict) {
+				t.Errorf("Create() of existing key error = %v, want %v", err, ErrConflict)
+			}
+		})
+	}
+}
+
+func TestEncrypted_Rotation(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	old := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("old", 1)}})
+	for _, key := range []string{"things/a", "things/b"} {
+		if err := old.SetWithTTL(ctx, key, []byte("value of "+key), time.Hour); err != nil {
+			t.Fatalf("SetWithTTL() error = %v", err)
+		}
+	}
+
+	rotating := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("new", 2), testKey("old", 1)}})
+
+	if got, err := rotating.Get(ctx, "things/a"); err != nil || string(got) != "value of things/a" {
+		t.Errorf("Get() of value with old key got = %q, %v", got, err)
+	}
+
+	if err := rotating.Set(ctx, "things/b", []byte("new value of things/b")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	n, err := rotating.Rotate(ctx, "things/")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Rotate() rewrote %d values, want 1", n)
+	}
+
+	rotated := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("new", 2)}})
+
+	for key, want := range map[string]string{
+		"things/a": "value of things/a",
+		"things/b": "new value of things/b",
+	} {
+		if got, err := rotated.Get(ctx, key); err != nil || string(got) != want {
+			t.Errorf("Get(%q) after Rotate() got = %q, %v, want %q", key, got, err, want)
+		}
+	}
+
+	if _, err := old.Get(ctx, "things/a"); !errors.Is(err, ErrCantDecode) {
+		t.Errorf("Get() with only the old key error = %v, want %v", err, ErrCantDecode)
+	}
+
+	if info, err := m.Stat(ctx, "things/a"); err != nil || info.Expires.IsZero() {
+		t.Errorf("Stat() after Rotate() = %+v, %v, want the value to still expire", info, err)
+	}
+}
+
+func TestEncrypted_Plaintext(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if err := m.Set(ctx, "key", []byte("plaintext")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	strict := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+	if _, err := strict.Get(ctx, "key"); !errors.Is(err, ErrCantDecode) {
+		t.Errorf("Get() of plaintext error = %v, want %v", err, ErrCantDecode)
+	}
+
+	lenient := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}, AllowPlaintext: true})
+	if got, err := lenient.Get(ctx, "key"); err != nil || string(got) != "plaintext" {
+		t.Errorf("Get() of plaintext with AllowPlaintext got = %q, %v", got, err)
+	}
+
+	if n, err := lenient.Rotate(ctx, ""); err != nil || n != 1 {
+		t.Errorf("Rotate() = %d, %v, want 1", n, err)
+	}
+
+	if got, err := strict.Get(ctx, "key"); err != nil || string(got) != "plaintext" {
+		t.Errorf("Get() after Rotate() got = %q, %v", got, err)
+	}
+}
+
+func TestEncrypted_Tampering(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	e := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1), testKey("b", 2)}})
+
+	if err := e.Set(ctx, "key", []byte("secret value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	raw, _ := m.Get(ctx, "key")
+	idOffset := len(encryptedMagic) + 2
+
+	tests := []struct {
+		name   string
+		mangle func(data []byte) []byte
+	}{
+		{name: "flipped ciphertext bit", mangle: func(data []byte) []byte { data[len(data)-1] ^= 1; return data }},
+		{name: "flipped data key bit", mangle: func(data []byte) []byte { data[idOffset+5] ^= 1; return data }},
+		{name: "swapped key ID", mangle: func(data []byte) []byte { data[idOffset] = 'b'; return data }},
+		{name: "unknown key ID", mangle: func(data []byte) []byte { data[idOffset] = 'z'; return data }},
+		{name: "unknown version", mangle: func(data []byte) []byte { data[len(encryptedMagic)] = 99; return data }},
+		{name: "truncated", mangle: func(data []byte) []byte { return data[:idOffset+10] }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Set(ctx, "key", tt.mangle(bytes.Clone(raw))); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			if _, err := e.Get(ctx, "key"); !errors.Is(err, ErrCantDecode) {
+				t.Errorf("Get() error = %v, want %v", err, ErrCantDecode)
+			}
+		})
+	}
+}
+
+func TestNewEncrypted_BadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []EncryptionKey
+	}{
+		{name: "no keys"},
+		{name: "empty ID", keys: []EncryptionKey{testKey("", 1)}},
+		{name: "duplicate ID", keys: []EncryptionKey{testKey("a", 1), testKey("a", 2)}},
+		{name: "bad key size", keys: []EncryptionKey{{ID: "a", Key: []byte("short")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewEncrypted(NewMemory(), EncryptedOptions{Keys: tt.keys}); !errors.Is(err, ErrBadConfig) {
+				t.Errorf("NewEncrypted() error = %v, want %v", err, ErrBadConfig)
+			}
+		})
+	}
+}
+
+func TestParseEncryptionKeys(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	tests := []struct {
+		name    string
+		input   string
+		wantIDs []string
+		wantErr error
+	}{
+		{name: "empty", input: ""},
+		{name: "one key", input: "a:" + encoded, wantIDs: []string{"a"}},
+		{name: "two keys", input: "new:" + encoded + ", old:" + encoded, wantIDs: []string{"new", "old"}},
+		{name: "missing colon", input: encoded, wantErr: ErrBadConfig},
+		{name: "bad base64", input: "a:!!!", wantErr: ErrBadConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseEncryptionKeys(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseEncryptionKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(keys) != len(tt.wantIDs) {
+				t.Fatalf("ParseEncryptionKeys() returned %d keys, want %d", len(keys), len(tt.wantIDs))
+			}
+
+			for i, k := range keys {
+				if k.ID != tt.wantIDs[i] || !bytes.Equal(k.Key, key) {
+					t.Errorf("key %d = %q, want %q with the decoded key", i, k.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
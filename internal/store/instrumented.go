@@ -0,0 +1,284 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	storeOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tigris_gtm",
+		Subsystem: "glue",
+		Name:      "store_operations_total",
+		Help:      "The number of store operations by driver and operation",
+	}, []string{"driver", "op"})
+
+	storeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tigris_gtm",
+		Subsystem: "glue",
+		Name:      "store_errors_total",
+		Help:      "The number of failed store operations by driver, operation and error class",
+	}, []string{"driver", "op", "class"})
+
+	storeLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tigris_gtm",
+		Subsystem: "glue",
+		Name:      "store_operation_duration_seconds",
+		Help:      "How long store operations take by driver and operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"driver", "op"})
+)
+
+// errorClass buckets an error from a store into a small set of classes for
+// use as a metric label.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrInvalidKey), errors.Is(err, ErrBadConfig):
+		return "invalid"
+	case errors.Is(err, ErrCantDecode), errors.Is(err, ErrCantEncode):
+		return "codec"
+	case errors.Is(err, errors.ErrUnsupported):
+		return "unsupported"
+	case errors.Is(err, ErrPermission):
+		return "permission"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "transport"
+	}
+}
+
+// NewInstrumented wraps underlying so that every operation is recorded in
+// Prometheus metrics labeled with driver, eg: "s3api" or "lru".
+func NewInstrumented(underlying Interface, driver string) *Instrumented {
+	return &Instrumented{
+		underlying: underlying,
+		driver:     driver,
+	}
+}
+
+// Instrumented is a store.Interface that counts and times the operations on
+// another store, along with their errors by class.
+type Instrumented struct {
+	underlying Interface
+	driver     string
+}
+
+// observe records a call to op that started at start and returned err.
+func (i *Instrumented) observe(op string, start time.Time, err error) {
+	storeOperations.WithLabelValues(i.driver, op).Inc()
+	storeLatency.WithLabelValues(i.driver, op).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		storeErrors.WithLabelValues(i.driver, op, errorClass(err)).Inc()
+	}
+}
+
+func (i *Instrumented) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := i.underlying.Delete(ctx, key)
+	i.observe("Delete", start, err)
+	return err
+}
+
+func (i *Instrumented) Exists(ctx context.Context, key string) error {
+	start := time.Now()
+	err := i.underlying.Exists(ctx, key)
+	i.observe("Exists", start, err)
+	return err
+}
+
+func (i *Instrumented) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	value, err := i.underlying.Get(ctx, key)
+	i.observe("Get", start, err)
+	return value, err
+}
+
+func (i *Instrumented) Set(ctx context.Context, key string, value []byte) error {
+	start := time.Now()
+	err := i.underlying.Set(ctx, key, value)
+	i.observe("Set", start, err)
+	return err
+}
+
+func (i *Instrumented) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := SetWithTTL(ctx, i.underlying, key, value, ttl)
+	i.observe("SetWithTTL", start, err)
+	return err
+}
+
+func (i *Instrumented) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	start := time.Now()
+	value, version, err := GetVersion(ctx, i.underlying, key)
+	i.observe("GetVersion", start, err)
+	return value, version, err
+}
+
+func (i *Instrumented) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	cs, ok := i.underlying.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, i.underlying)
+	}
+
+	start := time.Now()
+	version, err := cs.SetIf(ctx, key, value, cond)
+	i.observe("SetIf", start, err)
+	return version, err
+}
+
+// GetReader records how long it takes to open a value, not to read it.
+func (i *Instrumented) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	start := time.Now()
+	rc, info, err := GetReader(ctx, i.underlying, key)
+	i.observe("GetReader", start, err)
+	return rc, info, err
+}
+
+func (i *Instrumented) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	start := time.Now()
+	err := SetReader(ctx, i.underlying, key, r, info)
+	i.observe("SetReader", start, err)
+	return err
+}
+
+func (i *Instrumented) List(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	keys, err := i.underlying.List(ctx, prefix)
+	i.observe("List", start, err)
+	return keys, err
+}
+
+// Iterate records how long it takes to walk the listing, including the time
+// spent by the caller between keys.
+func (i *Instrumented) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		start := time.Now()
+		var err error
+
+		defer func() {
+			i.observe("Iterate", start, err)
+		}()
+
+		for key, ierr := range Iterate(ctx, i.underlying, prefix, opts) {
+			if ierr != nil {
+				err = ierr
+				yield("", ierr)
+				return
+			}
+
+			if !yield(key, nil) {
+				return
+			}
+		}
+	}
+}
+
+// IterateDelimited records how long it takes to walk the listing, including
+// the time spent by the caller between entries.
+func (i *Instrumented) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		start := time.Now()
+		var err error
+
+		defer func() {
+			i.observe("IterateDelimited", start, err)
+		}()
+
+		for entry, ierr := range IterateDelimited(ctx, i.underlying, prefix, delimiter, opts) {
+			if ierr != nil {
+				err = ierr
+				yield(Entry{}, ierr)
+				return
+			}
+
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (i *Instrumented) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	start := time.Now()
+	values, err := GetMany(ctx, i.underlying, keys)
+	i.observe("GetMany", start, err)
+	return values, err
+}
+
+func (i *Instrumented) SetMany(ctx context.Context, values map[string][]byte) error {
+	start := time.Now()
+	err := SetMany(ctx, i.underlying, values)
+	i.observe("SetMany", start, err)
+	return err
+}
+
+func (i *Instrumented) DeleteMany(ctx context.Context, keys []string) error {
+	start := time.Now()
+	err := DeleteMany(ctx, i.underlying, keys)
+	i.observe("DeleteMany", start, err)
+	return err
+}
+
+func (i *Instrumented) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	start := time.Now()
+	deleted, err := DeletePrefix(ctx, i.underlying, prefix)
+	i.observe("DeletePrefix", start, err)
+	return deleted, err
+}
+
+func (i *Instrumented) Copy(ctx context.Context, src, dst string) error {
+	start := time.Now()
+	err := Copy(ctx, i.underlying, src, dst)
+	i.observe("Copy", start, err)
+	return err
+}
+
+func (i *Instrumented) Move(ctx context.Context, src, dst string) error {
+	start := time.Now()
+	err := Move(ctx, i.underlying, src, dst)
+	i.observe("Move", start, err)
+	return err
+}
+
+func (i *Instrumented) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	start := time.Now()
+	info, err := Stat(ctx, i.underlying, key)
+	i.observe("Stat", start, err)
+	return info, err
+}
+
+func (i *Instrumented) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	start := time.Now()
+	err := SetWithOptions(ctx, i.underlying, key, value, opts)
+	i.observe("SetWithOptions", start, err)
+	return err
+}
+
+func (i *Instrumented) PublicURL(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	u, err := PublicURL(ctx, i.underlying, key)
+	i.observe("PublicURL", start, err)
+	return u, err
+}
+
+func (i *Instrumented) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	start := time.Now()
+	u, err := PresignedURL(ctx, i.underlying, key, ttl)
+	i.observe("PresignedURL", start, err)
+	return u, err
+}
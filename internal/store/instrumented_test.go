@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func histogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+
+	m, ok := o.(prometheus.Metric)
+	if !ok {
+		t.Fatalf("%T is not a prometheus.Metric", o)
+	}
+
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		t.Fatalf("can't read histogram: %v", err)
+	}
+
+	return pb.GetHistogram().GetSampleCount()
+}
+
+func TestInstrumented(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		driver    string
+		op        string
+		call      func(s Interface) error
+		wantClass string
+	}{
+		{
+			name:   "successful set",
+			driver: "test-set",
+			op:     "Set",
+			call:   func(s Interface) error { return s.Set(ctx, "key", []byte("value")) },
+		},
+		{
+			name:      "missing key",
+			driver:    "test-get",
+			op:        "Get",
+			call:      func(s Interface) error { _, err := s.Get(ctx, "missing"); return err },
+			wantClass: "not_found",
+		},
+		{
+			name:      "conflict",
+			driver:    "test-create",
+			op:        "SetIf",
+			call:      func(s Interface) error { return Create(ctx, s, "existing", []byte("value")) },
+			wantClass: "conflict",
+		},
+		{
+			name:   "iterate",
+			driver: "test-iterate",
+			op:     "Iterate",
+			call: func(s Interface) error {
+				for _, err := range Iterate(ctx, s, "", ListOptions{}) {
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			if err := m.Set(ctx, "existing", []byte("value")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			s := NewInstrumented(m, tt.driver)
+
+			err := tt.call(s)
+			if (err != nil) != (tt.wantClass != "") {
+				t.Fatalf("call error = %v, want class %q", err, tt.wantClass)
+			}
+
+			if got := counterValue(t, storeOperations.WithLabelValues(tt.driver, tt.op)); got != 1 {
+				t.Errorf("operations counter = %v, want 1", got)
+			}
+
+			if got := histogramCount(t, storeLatency.WithLabelValues(tt.driver, tt.op)); got != 1 {
+				t.Errorf("latency histogram count = %d, want 1", got)
+			}
+
+			if tt.wantClass != "" {
+				if got := counterValue(t, storeErrors.WithLabelValues(tt.driver, tt.op, tt.wantClass)); got != 1 {
+					t.Errorf("errors counter for class %q = %v, want 1", tt.wantClass, got)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: fmt.Errorf("%w: foo", ErrNotFound), want: "not_found"},
+		{err: fmt.Errorf("%w: foo", ErrConflict), want: "conflict"},
+		{err: ErrInvalidKey, want: "invalid"},
+		{err: ErrCantDecode, want: "codec"},
+		{err: fmt.Errorf("%w: no CAS", errors.ErrUnsupported), want: "unsupported"},
+		{err: context.DeadlineExceeded, want: "canceled"},
+		{err: fmt.Errorf("%w: access denied", ErrPermission), want: "permission"},
+		{err: fmt.Errorf("%w: slow down", ErrUnavailable), want: "unavailable"},
+		{err: errors.New("connection reset by peer"), want: "transport"},
+	}
+
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
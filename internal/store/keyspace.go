@@ -0,0 +1,74 @@
+package store
+
+import "strings"
+
+// Keyspace is a path of nested prefixes in a store separated by "/", eg:
+// discourse-thread/tigris. It turns keys relative to the keyspace into the
+// full keys of the store and back. The zero value is the whole store.
+type Keyspace struct {
+	path string
+}
+
+// NewKeyspace joins parts into a keyspace. Slashes at the ends of parts and
+// empty parts are dropped, so NewKeyspace("a/", "", "/b") is a/b.
+func NewKeyspace(parts ...string) Keyspace {
+	var k Keyspace
+	return k.Sub(parts...)
+}
+
+// Sub returns the keyspace nested under k by parts, the same way as
+// NewKeyspace.
+func (k Keyspace) Sub(parts ...string) Keyspace {
+	elems := make([]string, 0, len(parts)+1)
+	if k.path != "" {
+		elems = append(elems, k.path)
+	}
+
+	for _, part := range parts {
+		if part = strings.Trim(part, "/"); part != "" {
+			elems = append(elems, part)
+		}
+	}
+
+	return Keyspace{path: strings.Join(elems, "/")}
+}
+
+// Parent returns the keyspace k is nested in. The parent of the whole store is
+// itself.
+func (k Keyspace) Parent() Keyspace {
+	i := strings.LastIndex(k.path, "/")
+	if i == -1 {
+		return Keyspace{}
+	}
+	return Keyspace{path: k.path[:i]}
+}
+
+// Base returns the last part of k's path, or an empty string for the whole
+// store.
+func (k Keyspace) Base() string {
+	return k.path[strings.LastIndex(k.path, "/")+1:]
+}
+
+// IsRoot reports whether k is the whole store.
+func (k Keyspace) IsRoot() bool {
+	return k.path == ""
+}
+
+// String returns k's path without a trailing slash.
+func (k Keyspace) String() string {
+	return k.path
+}
+
+// Key returns the full key of key in k. Key("") is the prefix every key in k
+// starts with, which is empty for the whole store.
+func (k Keyspace) Key(key string) string {
+	if k.path == "" {
+		return key
+	}
+	return k.path + "/" + key
+}
+
+// Rel returns the key full is in k, and false if full isn't in k.
+func (k Keyspace) Rel(full string) (string, bool) {
+	return strings.CutPrefix(full, k.Key(""))
+}
@@ -0,0 +1,92 @@
+package store
+
+import "testing"
+
+func TestKeyspace(t *testing.T) {
+	tests := []struct {
+		name       string
+		keyspace   Keyspace
+		wantString string
+		wantKey    string
+		wantParent string
+		wantBase   string
+	}{
+		{
+			name:       "whole store",
+			keyspace:   Keyspace{},
+			wantString: "",
+			wantKey:    "k",
+			wantParent: "",
+			wantBase:   "",
+		},
+		{
+			name:       "one part",
+			keyspace:   NewKeyspace("discourse-thread"),
+			wantString: "discourse-thread",
+			wantKey:    "discourse-thread/k",
+			wantParent: "",
+			wantBase:   "discourse-thread",
+		},
+		{
+			name:       "nested",
+			keyspace:   NewKeyspace("discourse-thread").Sub("tigris"),
+			wantString: "discourse-thread/tigris",
+			wantKey:    "discourse-thread/tigris/k",
+			wantParent: "discourse-thread",
+			wantBase:   "tigris",
+		},
+		{
+			name:       "slashes and empty parts are dropped",
+			keyspace:   NewKeyspace("/a/", "", "b/c/"),
+			wantString: "a/b/c",
+			wantKey:    "a/b/c/k",
+			wantParent: "a/b",
+			wantBase:   "c",
+		},
+		{
+			name:       "from a common prefix",
+			keyspace:   NewKeyspace("discourse-thread/tigris/"),
+			wantString: "discourse-thread/tigris",
+			wantKey:    "discourse-thread/tigris/k",
+			wantParent: "discourse-thread",
+			wantBase:   "tigris",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := tt.keyspace
+
+			if got := k.String(); got != tt.wantString {
+				t.Errorf("String() = %q, want %q", got, tt.wantString)
+			}
+			if got := k.IsRoot(); got != (tt.wantString == "") {
+				t.Errorf("IsRoot() = %v, want %v", got, tt.wantString == "")
+			}
+			if got := k.Key("k"); got != tt.wantKey {
+				t.Errorf("Key() = %q, want %q", got, tt.wantKey)
+			}
+			if got := k.Parent().String(); got != tt.wantParent {
+				t.Errorf("Parent() = %q, want %q", got, tt.wantParent)
+			}
+			if got := k.Base(); got != tt.wantBase {
+				t.Errorf("Base() = %q, want %q", got, tt.wantBase)
+			}
+
+			rel, ok := k.Rel(tt.wantKey)
+			if !ok || rel != "k" {
+				t.Errorf("Rel(%q) = %q, %v, want k, true", tt.wantKey, rel, ok)
+			}
+		})
+	}
+}
+
+func TestKeyspace_RelOutside(t *testing.T) {
+	k := NewKeyspace("discourse-thread")
+
+	for _, full := range []string{"discourse-threads/k", "discourse-thread", "other/k"} {
+		if rel, ok := k.Rel(full); ok {
+			t.Errorf("Rel(%q) = %q, true, want false", full, rel)
+		}
+	}
+}
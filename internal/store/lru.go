@@ -1,61 +1,675 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"sync"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
 )
 
+var lruRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "tigris_gtm",
+	Subsystem: "glue",
+	Name:      "store_lru_requests_total",
+	Help:      "The number of LRU cache reads by result: hit (served from memory), load (read from the underlying store) or coalesced (waited for another caller's load)",
+}, []string{"result"})
+
+// lruMaxStreamedValue is the largest value GetReader will read into the cache.
+const lruMaxStreamedValue = 1 << 20
+
+// errLRUStreamed is returned by a load that found the value too large to cache,
+// so each caller has to stream it for itself.
+var errLRUStreamed = errors.New("value is too large to cache")
+
+// LRUOptions configure an LRU cache. The zero value matches NewLRUCache.
+type LRUOptions struct {
+	// MaxEntries is the most values the cache holds. Defaults to 512.
+	MaxEntries int
+
+	// MaxBytes is the most bytes of values the cache holds, evicting the least
+	// recently used values to stay under it. Values larger than this are never
+	// cached. Zero means no limit.
+	MaxBytes int64
+
+	// TTL is how long a value is served from the cache before it is read from
+	// the underlying store again, so that writes from other processes are
+	// eventually seen. Zero means cached values only expire when written with
+	// SetWithTTL.
+	TTL time.Duration
+
+	// NegativeTTL is how long a key that doesn't exist is remembered as
+	// missing, so repeated lookups of it don't reach the underlying store. Zero
+	// disables negative caching.
+	NegativeTTL time.Duration
+
+	// CacheExists serves Exists from the cache when it holds the key (or
+	// remembers it as missing) instead of always asking the underlying store.
+	CacheExists bool
+}
+
+// LRU is a store.Interface that caches the values of another store in memory.
+// Concurrent cache misses on the same key share a single read of the
+// underlying store.
 type LRU struct {
-	cache      *lru.Cache[string, []byte]
+	cache      *lru.Cache[string, lruEntry]
 	underlying Interface
+	opts       LRUOptions
+	now        func() time.Time
+	loads      singleflight.Group
+
+	lock    sync.Mutex // serializes cache writes so bytes stays accurate
+	bytes   int64
+	loading map[string]*lruLoad // guarded by lock
 }
 
+// lruLoad tracks the reads of a key from the underlying store that haven't
+// finished yet.
+type lruLoad struct {
+	reads int
+	stale bool // the key was written or evicted since the first read started
+}
+
+type lruEntry struct {
+	value   []byte
+	expires time.Time
+	missing bool // the key didn't exist in the underlying store
+}
+
+func (e lruEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// NewLRUCache wraps underlying in an LRU cache of 512 values.
 func NewLRUCache(underlying Interface) (*LRU, error) {
-	cache, err := lru.New[string, []byte](512)
+	return NewLRU(underlying, LRUOptions{})
+}
+
+// NewLRU wraps underlying in an LRU cache configured by opts.
+func NewLRU(underlying Interface, opts LRUOptions) (*LRU, error) {
+	if opts.MaxEntries == 0 {
+		opts.MaxEntries = 512
+	}
+
+	if opts.MaxEntries < 0 || opts.MaxBytes < 0 || opts.TTL < 0 || opts.NegativeTTL < 0 {
+		return nil, fmt.Errorf("%w: LRU options must not be negative", ErrBadConfig)
+	}
+
+	l := &LRU{
+		underlying: underlying,
+		opts:       opts,
+		now:        time.Now,
+		loading:    map[string]*lruLoad{},
+	}
+
+	cache, err := lru.NewWithEvict(opts.MaxEntries, l.onEvict)
 	if err != nil {
 		return nil, fmt.Errorf("can't create LRU cache: %w", err)
 	}
+	l.cache = cache
 
-	return &LRU{
-		cache:      cache,
-		underlying: underlying,
-	}, nil
+	return l, nil
 }
 
-func (l *LRU) Delete(ctx context.Context, key string) error {
+// onEvict is called by the cache whenever an entry leaves it. Every call
+// happens inside put or remove, so l.lock is held.
+func (l *LRU) onEvict(key string, e lruEntry) {
+	l.bytes -= int64(len(e.value))
+}
+
+// lookup fetches an unexpired entry from the cache, evicting it if it has
+// expired.
+func (l *LRU) lookup(key string) (lruEntry, bool) {
+	e, ok := l.cache.Get(key)
+	if !ok {
+		return lruEntry{}, false
+	}
+
+	if e.expired(l.now()) {
+		l.lock.Lock()
+		// Another goroutine may have replaced it since.
+		if e, ok := l.cache.Peek(key); ok && e.expired(l.now()) {
+			l.cache.Remove(key)
+		}
+		l.lock.Unlock()
+		return lruEntry{}, false
+	}
+
+	return e, true
+}
+
+// entry makes the cache entry of a value. A ttl of zero or less uses the
+// cache's TTL, and the cache's TTL caps longer ones.
+func (l *LRU) entry(value []byte, ttl time.Duration) lruEntry {
+	if l.opts.TTL > 0 && (ttl <= 0 || ttl > l.opts.TTL) {
+		ttl = l.opts.TTL
+	}
+
+	e := lruEntry{value: value}
+	if ttl > 0 {
+		e.expires = l.now().Add(ttl)
+	}
+
+	return e
+}
+
+// missingEntry makes the cache entry of a key that doesn't exist, or returns
+// false if negative caching is off.
+func (l *LRU) missingEntry() (lruEntry, bool) {
+	if l.opts.NegativeTTL <= 0 {
+		return lruEntry{}, false
+	}
+
+	return lruEntry{missing: true, expires: l.now().Add(l.opts.NegativeTTL)}, true
+}
+
+// add caches a value that was just written.
+func (l *LRU) add(key string, value []byte, ttl time.Duration) {
+	l.put(key, l.entry(value, ttl))
+}
+
+// beginLoad notes that key is being read from the underlying store, so that
+// the value read isn't cached if key is written before the read finishes.
+func (l *LRU) beginLoad(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	ld, ok := l.loading[key]
+	if !ok {
+		ld = &lruLoad{}
+		l.loading[key] = ld
+	}
+	ld.reads++
+}
+
+// endLoad finishes a read started with beginLoad, caching e if ok is set and
+// key hasn't been written since, since the value read may be older than the
+// write.
+func (l *LRU) endLoad(key string, e lruEntry, ok bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	ld := l.loading[key]
+	ld.reads--
+	if ld.reads == 0 {
+		delete(l.loading, key)
+	}
+
+	if ok && !ld.stale {
+		l.putLocked(key, e)
+	}
+}
+
+// endLoadMissing finishes a read started with beginLoad that found key
+// doesn't exist, remembering it as missing if negative caching is on.
+func (l *LRU) endLoadMissing(key string) {
+	e, ok := l.missingEntry()
+	l.endLoad(key, e, ok)
+}
+
+// invalidate keeps the reads of key in progress from caching what they read.
+// The caller must hold l.lock.
+func (l *LRU) invalidate(key string) {
+	if ld, ok := l.loading[key]; ok {
+		ld.stale = true
+	}
+}
+
+func (l *LRU) put(key string, e lruEntry) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.invalidate(key)
+	l.putLocked(key, e)
+}
+
+// putLocked caches e. The caller must hold l.lock.
+func (l *LRU) putLocked(key string, e lruEntry) {
+	// Remove the old entry first so its size is subtracted, Add replaces values
+	// without calling onEvict.
+	l.cache.Remove(key)
+
+	size := int64(len(e.value))
+	if l.opts.MaxBytes > 0 && size > l.opts.MaxBytes {
+		return
+	}
+
+	l.cache.Add(key, e)
+	l.bytes += size
+
+	for l.opts.MaxBytes > 0 && l.bytes > l.opts.MaxBytes {
+		if _, _, ok := l.cache.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+func (l *LRU) remove(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.invalidate(key)
 	l.cache.Remove(key)
-	return l.underlying.Delete(ctx, key)
 }
 
+// removePrefix evicts every key matching prefix, including the ones being
+// read.
+func (l *LRU) removePrefix(prefix string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, key := range l.cache.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			l.cache.Remove(key)
+		}
+	}
+
+	for key, ld := range l.loading {
+		if strings.HasPrefix(key, prefix) {
+			ld.stale = true
+		}
+	}
+}
+
+// Delete evicts the cached value once the underlying store has deleted it, so
+// a read that races the delete can't cache the old value.
+func (l *LRU) Delete(ctx context.Context, key string) error {
+	err := l.underlying.Delete(ctx, key)
+	l.remove(key)
+
+	return err
+}
+
+// Exists passes through to the underlying store unless CacheExists is set, in
+// which case cached values and keys remembered as missing are answered from
+// memory.
 func (l *LRU) Exists(ctx context.Context, key string) error {
-	return l.underlying.Exists(ctx, key)
+	if !l.opts.CacheExists {
+		return l.underlying.Exists(ctx, key)
+	}
+
+	if e, ok := l.lookup(key); ok {
+		lruRequests.WithLabelValues("hit").Inc()
+		if e.missing {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil
+	}
+
+	l.beginLoad(key)
+	err := l.underlying.Exists(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		l.endLoadMissing(key)
+	} else {
+		l.endLoad(key, lruEntry{}, false)
+	}
+
+	return err
 }
 
 func (l *LRU) Get(ctx context.Context, key string) ([]byte, error) {
-	result, ok := l.cache.Get(key)
-	if ok {
-		iopsMetrics.WithLabelValues("lru", "cache_read")
+	if e, ok := l.lookup(key); ok {
+		lruRequests.WithLabelValues("hit").Inc()
+		if e.missing {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return e.value, nil
+	}
+
+	value, err := l.load(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return l.underlying.Get(ctx, key)
+	})
+	if errors.Is(err, errLRUStreamed) {
+		// A concurrent GetReader found the value too large to cache.
+		return l.underlying.Get(ctx, key)
+	}
+
+	return value, err
+}
+
+// load reads a value from the underlying store with fetch and caches it,
+// unless the key is written while it's being read. Concurrent loads of the
+// same key share a single read. That read isn't canceled with ctx because
+// other callers may be waiting on it, but load returns as soon as ctx is done.
+func (l *LRU) load(ctx context.Context, key string, fetch func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	var leader bool
+
+	ch := l.loads.DoChan(key, func() (any, error) {
+		leader = true
+		lruRequests.WithLabelValues("load").Inc()
+
+		l.beginLoad(key)
+		value, err := fetch(context.WithoutCancel(ctx))
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				l.endLoadMissing(key)
+			} else {
+				l.endLoad(key, lruEntry{}, false)
+			}
+			return nil, err
+		}
+
+		l.endLoad(key, l.entry(value, 0), true)
+
+		return value, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if !leader {
+			lruRequests.WithLabelValues("coalesced").Inc()
+		}
+
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		return res.Val.([]byte), nil
+	}
+}
+
+// Set caches a value once the underlying store has it. If the write fails,
+// the cached value is evicted, since it isn't known whether it was made.
+func (l *LRU) Set(ctx context.Context, key string, value []byte) error {
+	if err := l.underlying.Set(ctx, key, value); err != nil {
+		l.remove(key)
+		return err
+	}
+
+	l.add(key, value, 0)
+	return nil
+}
+
+// SetWithTTL writes a value to the underlying store with ttl and then caches
+// it until ttl passes.
+func (l *LRU) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return l.Set(ctx, key, value)
+	}
+
+	if err := SetWithTTL(ctx, l.underlying, key, value, ttl); err != nil {
+		l.remove(key)
+		return err
+	}
+
+	l.add(key, value, ttl)
+	return nil
+}
+
+// GetVersion always reads through to the underlying store so the version is
+// current, and caches the value it gets back.
+func (l *LRU) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	l.beginLoad(key)
+	value, version, err := GetVersion(ctx, l.underlying, key)
+	if err != nil {
+		l.endLoad(key, lruEntry{}, false)
+		return nil, "", err
+	}
+
+	l.endLoad(key, l.entry(value, 0), true)
+
+	return value, version, nil
+}
+
+// SetIf performs a conditional write against the underlying store. The cached
+// value is replaced if the write succeeds and evicted if it conflicts, since
+// that means another writer has changed it.
+func (l *LRU) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	cs, ok := l.underlying.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, l.underlying)
+	}
+
+	version, err := cs.SetIf(ctx, key, value, cond)
+	if err != nil {
+		l.remove(key)
+		return "", err
+	}
+
+	l.add(key, value, cond.Options.TTL)
+
+	return version, nil
+}
+
+// GetReader serves cached values from memory. On a miss, values small enough to
+// cache are read in full and cached, sharing the read with concurrent Get and
+// GetReader calls. Larger ones are streamed from the underlying store without
+// being cached.
+func (l *LRU) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	if e, ok := l.lookup(key); ok {
+		lruRequests.WithLabelValues("hit").Inc()
+		if e.missing {
+			return nil, StreamInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return io.NopCloser(bytes.NewReader(e.value)), StreamInfo{ContentLength: int64(len(e.value))}, nil
+	}
+
+	// A value too large to cache is handed to the caller that opened it, unless
+	// that caller has given up waiting, in which case it's closed.
+	var (
+		streamLock sync.Mutex
+		stream     io.ReadCloser
+		streamInfo StreamInfo
+		abandoned  bool
+	)
+
+	value, err := l.load(ctx, key, func(ctx context.Context) ([]byte, error) {
+		rc, info, err := GetReader(ctx, l.underlying, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.ContentLength < 0 || info.ContentLength > l.maxStreamedValue() {
+			streamLock.Lock()
+			defer streamLock.Unlock()
+
+			if abandoned {
+				rc.Close()
+			} else {
+				stream, streamInfo = rc, info
+			}
+			return nil, errLRUStreamed
+		}
+		defer rc.Close()
+
+		value, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %w", key, err)
+		}
+
+		return value, nil
+	})
+
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	abandoned = true
+
+	switch {
+	case stream != nil && errors.Is(err, errLRUStreamed):
+		return stream, streamInfo, nil
+	case stream != nil:
+		stream.Close()
+	}
+
+	if errors.Is(err, errLRUStreamed) {
+		// Another caller opened the value, so open it again to stream it here.
+		return GetReader(ctx, l.underlying, key)
+	}
+	if err != nil {
+		return nil, StreamInfo{}, err
+	}
+
+	return io.NopCloser(bytes.NewReader(value)), StreamInfo{ContentLength: int64(len(value))}, nil
+}
+
+// maxStreamedValue caps lruMaxStreamedValue at MaxBytes.
+func (l *LRU) maxStreamedValue() int64 {
+	if l.opts.MaxBytes > 0 && l.opts.MaxBytes < lruMaxStreamedValue {
+		return l.opts.MaxBytes
+	}
+	return lruMaxStreamedValue
+}
+
+// SetReader streams r to the underlying store and then evicts the cached
+// value.
+func (l *LRU) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	err := SetReader(ctx, l.underlying, key, r, info)
+	l.remove(key)
+
+	return err
+}
+
+func (l *LRU) List(ctx context.Context, prefix string) ([]string, error) {
+	return l.underlying.List(ctx, prefix)
+}
+
+func (l *LRU) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return Iterate(ctx, l.underlying, prefix, opts)
+}
+
+func (l *LRU) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	return IterateDelimited(ctx, l.underlying, prefix, delimiter, opts)
+}
+
+// GetMany serves the keys it has cached from memory and reads the rest from
+// the underlying store in one batch, caching what it gets back. Batched reads
+// aren't shared with concurrent Get calls.
+func (l *LRU) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	var misses []string
+
+	for _, key := range keys {
+		e, ok := l.lookup(key)
+		if !ok {
+			misses = append(misses, key)
+			continue
+		}
+
+		lruRequests.WithLabelValues("hit").Inc()
+		if !e.missing {
+			result[key] = e.value
+		}
+	}
+
+	if len(misses) == 0 {
 		return result, nil
 	}
 
-	iopsMetrics.WithLabelValues("lru", "cache_load")
-	result, err := l.underlying.Get(ctx, key)
+	lruRequests.WithLabelValues("load").Add(float64(len(misses)))
+	for _, key := range misses {
+		l.beginLoad(key)
+	}
+
+	values, err := GetMany(ctx, l.underlying, misses)
 	if err != nil {
+		for _, key := range misses {
+			l.endLoad(key, lruEntry{}, false)
+		}
 		return nil, err
 	}
 
-	l.cache.Add(key, result)
+	for _, key := range misses {
+		value, ok := values[key]
+		if !ok {
+			l.endLoadMissing(key)
+			continue
+		}
+
+		l.endLoad(key, l.entry(value, 0), true)
+		result[key] = value
+	}
 
 	return result, nil
 }
 
-func (l *LRU) Set(ctx context.Context, key string, value []byte) error {
-	l.cache.Add(key, value)
-	return l.underlying.Set(ctx, key, value)
+// SetMany caches the values once the underlying store has them. If any write
+// fails, none of the values are cached, since it isn't known which were made.
+func (l *LRU) SetMany(ctx context.Context, values map[string][]byte) error {
+	if err := SetMany(ctx, l.underlying, values); err != nil {
+		for key := range values {
+			l.remove(key)
+		}
+		return err
+	}
+
+	for key, value := range values {
+		l.add(key, value, 0)
+	}
+
+	return nil
 }
 
-func (l *LRU) List(ctx context.Context, prefix string) ([]string, error) {
-	return l.underlying.List(ctx, prefix)
+// DeleteMany evicts the cached values once the underlying store has deleted
+// them, even if it only managed to delete some.
+func (l *LRU) DeleteMany(ctx context.Context, keys []string) error {
+	err := DeleteMany(ctx, l.underlying, keys)
+	for _, key := range keys {
+		l.remove(key)
+	}
+
+	return err
+}
+
+// DeletePrefix evicts the cached keys matching prefix once the underlying
+// store has deleted them, even if it only managed to delete some.
+func (l *LRU) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted, err := DeletePrefix(ctx, l.underlying, prefix)
+	l.removePrefix(prefix)
+
+	return deleted, err
+}
+
+// Copy copies in the underlying store and then evicts the cached value of dst.
+func (l *LRU) Copy(ctx context.Context, src, dst string) error {
+	err := Copy(ctx, l.underlying, src, dst)
+	l.remove(dst)
+
+	return err
+}
+
+// Move moves in the underlying store and then evicts the cached values of src
+// and dst.
+func (l *LRU) Move(ctx context.Context, src, dst string) error {
+	err := Move(ctx, l.underlying, src, dst)
+	l.remove(src)
+	l.remove(dst)
+
+	return err
+}
+
+// Stat always asks the underlying store, since the cache doesn't keep
+// anything but values.
+func (l *LRU) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return Stat(ctx, l.underlying, key)
+}
+
+// SetWithOptions writes a value to the underlying store with opts and then
+// caches it the same as SetWithTTL.
+func (l *LRU) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	if err := SetWithOptions(ctx, l.underlying, key, value, opts); err != nil {
+		l.remove(key)
+		return err
+	}
+
+	l.add(key, value, opts.TTL)
+	return nil
+}
+
+func (l *LRU) PublicURL(ctx context.Context, key string) (string, error) {
+	return PublicURL(ctx, l.underlying, key)
+}
+
+func (l *LRU) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return PresignedURL(ctx, l.underlying, key, ttl)
 }
@@ -0,0 +1,649 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestLRU_SetWithTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+
+	m := NewMemory()
+	m.now = clock.Now
+
+	l, err := NewLRUCache(m)
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+	l.now = clock.Now
+
+	if err := l.SetWithTTL(ctx, "key", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+
+	got, err := l.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "value" {
+		t.Errorf("Get() got = %q, want %q", got, "value")
+	}
+
+	clock.Advance(time.Minute)
+
+	if _, err := l.Get(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after expiry error = %v, want %v", err, ErrNotFound)
+	}
+
+	if l.cache.Contains("key") {
+		t.Error("Get() after expiry left the entry in the cache")
+	}
+}
+
+func TestLRU_SetWithTTL_UnderlyingWithoutTTL(t *testing.T) {
+	ctx := context.Background()
+	m := newMockStore()
+
+	l, err := NewLRUCache(m)
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+
+	if err := l.SetWithTTL(ctx, "key", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+
+	if got := m.data["key"]; string(got) != "value" {
+		t.Errorf("SetWithTTL() stored value = %q, want %q", got, "value")
+	}
+}
+
+func TestLRU_GetReader(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDirectory(t, map[string][]byte{
+		"small": []byte("small value"),
+		"large": bytes.Repeat([]byte("x"), lruMaxStreamedValue+1),
+	})
+
+	l, err := NewLRUCache(d)
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		key        string
+		wantCached bool
+	}{
+		{key: "small", wantCached: true},
+		{key: "large", wantCached: false},
+	} {
+		rc, _, err := l.GetReader(ctx, tt.key)
+		if err != nil {
+			t.Fatalf("GetReader(%q) error = %v", tt.key, err)
+		}
+
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+
+		want, _ := d.Get(ctx, tt.key)
+		if !bytes.Equal(got, want) {
+			t.Errorf("GetReader(%q) returned %d bytes, want %d", tt.key, len(got), len(want))
+		}
+
+		if l.cache.Contains(tt.key) != tt.wantCached {
+			t.Errorf("GetReader(%q) cached = %v, want %v", tt.key, !tt.wantCached, tt.wantCached)
+		}
+	}
+
+	if err := l.SetReader(ctx, "small", strings.NewReader("new value"), StreamInfo{ContentLength: -1}); err != nil {
+		t.Fatalf("SetReader() error = %v", err)
+	}
+
+	if got, err := l.Get(ctx, "small"); err != nil || string(got) != "new value" {
+		t.Errorf("Get() after SetReader() got = %q, %v, want %q", got, err, "new value")
+	}
+}
+
+func TestLRU_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := NewLRU(NewMemory(), LRUOptions{MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("NewLRU() error = %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := l.Set(ctx, key, []byte("1234")); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+
+	if l.cache.Contains("a") {
+		t.Error("least recently used value wasn't evicted to stay under MaxBytes")
+	}
+	if !l.cache.Contains("b") || !l.cache.Contains("c") {
+		t.Error("recently used values were evicted")
+	}
+	if l.bytes != 8 {
+		t.Errorf("cached bytes = %d, want 8", l.bytes)
+	}
+
+	if err := l.Set(ctx, "b", []byte("12")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if l.bytes != 6 {
+		t.Errorf("cached bytes after replacing a value = %d, want 6", l.bytes)
+	}
+
+	if err := l.Set(ctx, "huge", []byte("12345678901")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if l.cache.Contains("huge") {
+		t.Error("value larger than MaxBytes was cached")
+	}
+	if got, err := l.Get(ctx, "huge"); err != nil || string(got) != "12345678901" {
+		t.Errorf("Get() of uncached value got = %q, %v", got, err)
+	}
+
+	if err := l.Delete(ctx, "c"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if l.bytes != 2 {
+		t.Errorf("cached bytes after Delete() = %d, want 2", l.bytes)
+	}
+}
+
+func TestLRU_TTL(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	m := NewMemory()
+
+	l, err := NewLRU(m, LRUOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("NewLRU() error = %v", err)
+	}
+	l.now = clock.Now
+
+	if err := l.Set(ctx, "key", []byte("old")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// Simulate another process writing to the store.
+	if err := m.Set(ctx, "key", []byte("new")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if got, _ := l.Get(ctx, "key"); string(got) != "old" {
+		t.Errorf("Get() before TTL got = %q, want %q", got, "old")
+	}
+
+	clock.Advance(time.Minute)
+
+	if got, _ := l.Get(ctx, "key"); string(got) != "new" {
+		t.Errorf("Get() after TTL got = %q, want %q", got, "new")
+	}
+}
+
+func TestLRU_NegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	m := NewMemory()
+
+	l, err := NewLRU(m, LRUOptions{NegativeTTL: time.Minute, CacheExists: true})
+	if err != nil {
+		t.Fatalf("NewLRU() error = %v", err)
+	}
+	l.now = clock.Now
+
+	if _, err := l.Get(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := m.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if _, err := l.Get(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of key cached as missing error = %v, want %v", err, ErrNotFound)
+	}
+	if err := l.Exists(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() of key cached as missing error = %v, want %v", err, ErrNotFound)
+	}
+
+	clock.Advance(time.Minute)
+
+	if err := l.Exists(ctx, "key"); err != nil {
+		t.Errorf("Exists() after NegativeTTL error = %v", err)
+	}
+	if got, err := l.Get(ctx, "key"); err != nil || string(got) != "value" {
+		t.Errorf("Get() after NegativeTTL got = %q, %v, want %q", got, err, "value")
+	}
+
+	if err := l.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := l.Set(ctx, "key", []byte("again")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := l.Exists(ctx, "key"); err != nil {
+		t.Errorf("Exists() after Set() error = %v", err)
+	}
+}
+
+func TestLRU_CacheExists(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	for _, tt := range []struct {
+		name        string
+		cacheExists bool
+		wantErr     error
+	}{
+		{name: "pass through", cacheExists: false, wantErr: ErrNotFound},
+		{name: "from cache", cacheExists: true, wantErr: nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewLRU(m, LRUOptions{CacheExists: tt.cacheExists})
+			if err != nil {
+				t.Fatalf("NewLRU() error = %v", err)
+			}
+
+			if err := l.Set(ctx, "key", []byte("value")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			// Remove the key behind the cache's back.
+			if err := m.Delete(ctx, "key"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			if err := l.Exists(ctx, "key"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exists() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("can't read counter: %v", err)
+	}
+
+	return m.GetCounter().GetValue()
+}
+
+// blockingStore counts Get calls and holds them until release is closed.
+type blockingStore struct {
+	Interface
+	gets    atomic.Int32
+	release chan struct{}
+}
+
+func (b *blockingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	b.gets.Add(1)
+	<-b.release
+	return b.Interface.Get(ctx, key)
+}
+
+func TestLRU_Coalescing(t *testing.T) {
+	ctx := context.Background()
+
+	m := NewMemory()
+	if err := m.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	b := &blockingStore{Interface: m, release: make(chan struct{})}
+
+	l, err := NewLRUCache(b)
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+
+	loadsBefore := counterValue(t, lruRequests.WithLabelValues("load"))
+	coalescedBefore := counterValue(t, lruRequests.WithLabelValues("coalesced"))
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+
+	for range callers {
+		wg.Go(func() {
+			got, err := l.Get(ctx, "key")
+			if err == nil && string(got) != "value" {
+				err = fmt.Errorf("Get() got = %q, want %q", got, "value")
+			}
+			errs <- err
+		})
+	}
+
+	// Give every caller a chance to miss the cache before the load finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(b.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got := b.gets.Load(); got != 1 {
+		t.Errorf("underlying Get() called %d times, want 1", got)
+	}
+
+	if got := counterValue(t, lruRequests.WithLabelValues("load")) - loadsBefore; got != 1 {
+		t.Errorf("load metric went up by %v, want 1", got)
+	}
+
+	if got := counterValue(t, lruRequests.WithLabelValues("coalesced")) - coalescedBefore; got != callers-1 {
+		t.Errorf("coalesced metric went up by %v, want %d", got, callers-1)
+	}
+}
+
+func TestLRU_CoalescingTyped(t *testing.T) {
+	ctx := context.Background()
+
+	m := NewMemory()
+	if err := m.Set(ctx, "values/key", []byte(`"value"`)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	b := &blockingStore{Interface: m, release: make(chan struct{})}
+
+	l, err := NewLRUCache(b)
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+
+	typed := &JSON[string]{Underlying: l, Prefix: "values"}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+
+	for range callers {
+		wg.Go(func() {
+			got, err := typed.Get(ctx, "key")
+			if err == nil && got != "value" {
+				err = fmt.Errorf("Get() got = %q, want %q", got, "value")
+			}
+			errs <- err
+		})
+	}
+
+	// Give every caller a chance to miss the cache before the load finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(b.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got := b.gets.Load(); got != 1 {
+		t.Errorf("underlying Get() called %d times, want 1", got)
+	}
+}
+
+func TestLRU_CoalescingCanceled(t *testing.T) {
+	m := NewMemory()
+	if err := m.Set(context.Background(), "key", []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	b := &blockingStore{Interface: m, release: make(chan struct{})}
+
+	l, err := NewLRUCache(b)
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := l.Get(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+
+	// The load carries on for callers that are still waiting.
+	close(b.release)
+
+	got, err := l.Get(context.Background(), "key")
+	if err != nil || string(got) != "value" {
+		t.Errorf("Get() got = %q, %v, want %q", got, err, "value")
+	}
+}
+
+// slowReadStore reads values and then holds them until release is closed, like
+// a response that is slow to arrive.
+type slowReadStore struct {
+	Interface
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *slowReadStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.Interface.Get(ctx, key)
+	close(s.read)
+	<-s.release
+	return value, err
+}
+
+func TestLRU_LoadRacesWrite(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		write func(l *LRU) error
+		want  string // empty if the key should be gone
+	}{
+		{
+			name:  "set",
+			write: func(l *LRU) error { return l.Set(ctx, "a/key", []byte("new")) },
+			want:  "new",
+		},
+		{
+			name:  "delete",
+			write: func(l *LRU) error { return l.Delete(ctx, "a/key") },
+		},
+		{
+			name: "delete prefix",
+			write: func(l *LRU) error {
+				_, err := l.DeletePrefix(ctx, "a/")
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			if err := m.Set(ctx, "a/key", []byte("old")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			s := &slowReadStore{Interface: m, read: make(chan struct{}), release: make(chan struct{})}
+			l, err := NewLRU(s, LRUOptions{NegativeTTL: time.Minute})
+			if err != nil {
+				t.Fatalf("NewLRU() error = %v", err)
+			}
+
+			loaded := make(chan struct{})
+			go func() {
+				defer close(loaded)
+				l.Get(ctx, "a/key")
+			}()
+
+			// Write once the load has read the old value but before it's done.
+			<-s.read
+			if err := tt.write(l); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			close(s.release)
+			<-loaded
+
+			if e, ok := l.cache.Peek("a/key"); ok && string(e.value) != tt.want {
+				t.Errorf("cache holds %q after the load, want the write's result", e.value)
+			}
+		})
+	}
+}
+
+// slowWriteStore holds the first write until release is closed, signaling
+// writing once it has started.
+type slowWriteStore struct {
+	*Memory
+	once    sync.Once
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (s *slowWriteStore) pause() {
+	s.once.Do(func() {
+		close(s.writing)
+		<-s.release
+	})
+}
+
+func (s *slowWriteStore) Set(ctx context.Context, key string, value []byte) error {
+	s.pause()
+	return s.Memory.Set(ctx, key, value)
+}
+
+func (s *slowWriteStore) Delete(ctx context.Context, key string) error {
+	s.pause()
+	return s.Memory.Delete(ctx, key)
+}
+
+func (s *slowWriteStore) Copy(ctx context.Context, src, dst string) error {
+	s.pause()
+	return s.Memory.Copy(ctx, src, dst)
+}
+
+func (s *slowWriteStore) Move(ctx context.Context, src, dst string) error {
+	s.pause()
+	return s.Memory.Move(ctx, src, dst)
+}
+
+func TestLRU_WriteRacesLoad(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		write func(l *LRU) error
+		want  string // empty if the key should be gone
+	}{
+		{
+			name:  "delete",
+			write: func(l *LRU) error { return l.Delete(ctx, "key") },
+		},
+		{
+			name:  "delete many",
+			write: func(l *LRU) error { return l.DeleteMany(ctx, []string{"key"}) },
+		},
+		{
+			name: "set reader",
+			write: func(l *LRU) error {
+				return l.SetReader(ctx, "key", strings.NewReader("new"), StreamInfo{ContentLength: 3})
+			},
+			want: "new",
+		},
+		{
+			name:  "copy to",
+			write: func(l *LRU) error { return l.Copy(ctx, "other", "key") },
+			want:  "new",
+		},
+		{
+			name:  "move to",
+			write: func(l *LRU) error { return l.Move(ctx, "other", "key") },
+			want:  "new",
+		},
+		{
+			name:  "move from",
+			write: func(l *LRU) error { return l.Move(ctx, "key", "other") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			if err := m.Set(ctx, "key", []byte("old")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := m.Set(ctx, "other", []byte("new")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			s := &slowWriteStore{Memory: m, writing: make(chan struct{}), release: make(chan struct{})}
+			l, err := NewLRUCache(s)
+			if err != nil {
+				t.Fatalf("NewLRUCache() error = %v", err)
+			}
+
+			written := make(chan error, 1)
+			go func() {
+				written <- tt.write(l)
+			}()
+
+			// Read the old value while the write is in progress.
+			<-s.writing
+			if got, err := l.Get(ctx, "key"); err != nil || string(got) != "old" {
+				t.Fatalf("Get() during the write got = %q, %v, want %q", got, err, "old")
+			}
+			close(s.release)
+			if err := <-written; err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+
+			got, err := l.Get(ctx, "key")
+			if tt.want == "" {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get() after the write got = %q, %v, want %v", got, err, ErrNotFound)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("Get() after the write got = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLRU_SetFails(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := NewLRUCache(&permissionStore{Memory: NewMemory(), denied: map[string]bool{"secret": true}})
+	if err != nil {
+		t.Fatalf("NewLRUCache() error = %v", err)
+	}
+
+	if err := l.Set(ctx, "secret", []byte("value")); !errors.Is(err, ErrPermission) {
+		t.Fatalf("Set() error = %v, want %v", err, ErrPermission)
+	}
+	if l.cache.Contains("secret") {
+		t.Error("Set() cached a value the underlying store didn't take")
+	}
+}
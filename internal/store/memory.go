@@ -0,0 +1,273 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemory creates an empty in-memory store. It is safe for concurrent use and
+// is intended for tests and short-lived tools.
+func NewMemory() *Memory {
+	return &Memory{
+		data: map[string]memoryEntry{},
+		now:  time.Now,
+	}
+}
+
+// Memory is a store.Interface that keeps every value in a map. Values written
+// with SetWithTTL expire lazily: they are treated as missing once their expiry
+// has passed and removed the next time they are touched.
+type Memory struct {
+	lock    sync.Mutex
+	data    map[string]memoryEntry
+	now     func() time.Time
+	version uint64
+}
+
+type memoryEntry struct {
+	value       []byte
+	expires     time.Time
+	version     string
+	modified    time.Time
+	contentType string
+	metadata    map[string]string
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// get fetches an unexpired entry. The caller must hold m.lock.
+func (m *Memory) get(key string) (memoryEntry, bool) {
+	e, ok := m.data[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+
+	if e.expired(m.now()) {
+		delete(m.data, key)
+		return memoryEntry{}, false
+	}
+
+	return e, true
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.get(key); !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	delete(m.data, key)
+	return nil
+}
+
+func (m *Memory) Exists(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.get(key); !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return slices.Clone(e.value), nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte) error {
+	return m.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL puts a value into the store that expires after ttl. A ttl of zero
+// or less means the value never expires.
+func (m *Memory) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.set(key, value, SetOptions{TTL: ttl})
+	return nil
+}
+
+// SetWithOptions puts a value into the store along with its content type and
+// metadata. Memory has no URLs, so it returns errors.ErrUnsupported for public
+// values.
+func (m *Memory) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	if opts.Public {
+		return fmt.Errorf("%w: memory values can't be public", errors.ErrUnsupported)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.set(key, value, opts)
+	return nil
+}
+
+// set stores a copy of value and returns its new version. The caller must hold
+// m.lock.
+func (m *Memory) set(key string, value []byte, opts SetOptions) string {
+	m.version++
+
+	e := memoryEntry{
+		value:       slices.Clone(value),
+		version:     strconv.FormatUint(m.version, 10),
+		modified:    m.now(),
+		contentType: opts.ContentType,
+		metadata:    lowerKeys(opts.Metadata),
+	}
+	if e.value == nil {
+		e.value = []byte{}
+	}
+	if opts.TTL > 0 {
+		e.expires = m.now().Add(opts.TTL)
+	}
+
+	m.data[key] = e
+	return e.version
+}
+
+// GetVersion returns a value along with its version.
+func (m *Memory) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return slices.Clone(e.value), e.version, nil
+}
+
+// SetIf writes a value if cond holds and returns its new version. Public
+// values are refused the same as by SetWithOptions.
+func (m *Memory) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	if cond.Options.Public {
+		return "", fmt.Errorf("%w: memory values can't be public", errors.ErrUnsupported)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.get(key)
+
+	if cond.IfNotExists && ok {
+		return "", fmt.Errorf("%w: %s already exists", ErrConflict, key)
+	}
+
+	if cond.IfVersion != "" && !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	if cond.IfVersion != "" && e.version != cond.IfVersion {
+		return "", fmt.Errorf("%w: %s is not at version %s", ErrConflict, key, cond.IfVersion)
+	}
+
+	return m.set(key, value, cond.Options), nil
+}
+
+// Copy puts a copy of src's value at dst, along with its content type,
+// metadata and expiry.
+func (m *Memory) Copy(ctx context.Context, src, dst string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.get(src)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, src)
+	}
+
+	m.copy(e, dst)
+	return nil
+}
+
+// Move puts src's value at dst and removes src.
+func (m *Memory) Move(ctx context.Context, src, dst string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.get(src)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, src)
+	}
+
+	delete(m.data, src)
+	m.copy(e, dst)
+	return nil
+}
+
+// copy stores e at key as a new version. The caller must hold m.lock.
+func (m *Memory) copy(e memoryEntry, key string) {
+	m.version++
+
+	e.value = slices.Clone(e.value)
+	e.metadata = maps.Clone(e.metadata)
+	e.version = strconv.FormatUint(m.version, 10)
+	e.modified = m.now()
+
+	m.data[key] = e
+}
+
+// Stat describes a value without copying it.
+func (m *Memory) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(e.value)),
+		LastModified: e.modified,
+		Version:      e.version,
+		ContentType:  e.contentType,
+		Metadata:     hideRetryToken(ctx, maps.Clone(e.metadata)),
+		Expires:      e.expires,
+	}, nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	var result []string
+
+	for k, e := range m.data {
+		if e.expired(now) {
+			delete(m.data, k)
+			continue
+		}
+
+		if strings.HasPrefix(k, prefix) {
+			result = append(result, k)
+		}
+	}
+
+	slices.Sort(result)
+
+	return result, nil
+}
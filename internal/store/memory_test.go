@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for testing expiry.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if err := m.Exists(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() error = %v, want %v", err, ErrNotFound)
+	}
+
+	if _, err := m.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := m.Delete(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() error = %v, want %v", err, ErrNotFound)
+	}
+
+	for _, k := range []string{"foo/a", "foo/b", "bar/a", ""} {
+		if err := m.Set(ctx, k, []byte(k)); err != nil {
+			t.Fatalf("Set(%q) error = %v", k, err)
+		}
+	}
+
+	got, err := m.Get(ctx, "foo/a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "foo/a" {
+		t.Errorf("Get() got = %q, want %q", got, "foo/a")
+	}
+
+	// Mutating the returned value must not change what is stored.
+	got[0] = 'X'
+	if got, _ := m.Get(ctx, "foo/a"); string(got) != "foo/a" {
+		t.Errorf("Get() returned an aliased slice, stored value is now %q", got)
+	}
+
+	if err := m.Exists(ctx, ""); err != nil {
+		t.Errorf("Exists() on empty key error = %v", err)
+	}
+
+	keys, err := m.List(ctx, "foo/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if !equalStringSlicesUnordered(keys, []string{"foo/a", "foo/b"}) {
+		t.Errorf("List() got = %v, want [foo/a foo/b]", keys)
+	}
+
+	if err := m.Delete(ctx, "foo/a"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	if err := m.Exists(ctx, "foo/a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() after Delete() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemory_SetWithTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		advance time.Duration
+		wantErr error
+	}{
+		{
+			name:    "value is readable before expiry",
+			ttl:     time.Minute,
+			advance: 30 * time.Second,
+			wantErr: nil,
+		},
+		{
+			name:    "value expires at its deadline",
+			ttl:     time.Minute,
+			advance: time.Minute,
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "zero ttl never expires",
+			ttl:     0,
+			advance: 24 * 365 * time.Hour,
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := newFakeClock()
+			m := NewMemory()
+			m.now = clock.Now
+
+			if err := m.SetWithTTL(ctx, "key", []byte("value"), tt.ttl); err != nil {
+				t.Fatalf("SetWithTTL() error = %v", err)
+			}
+
+			clock.Advance(tt.advance)
+
+			if _, err := m.Get(ctx, "key"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err := m.Exists(ctx, "key"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			keys, err := m.List(ctx, "")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+
+			if wantKeys := tt.wantErr == nil; wantKeys != (len(keys) == 1) {
+				t.Errorf("List() got = %v, want expired keys to be hidden", keys)
+			}
+		})
+	}
+}
+
+func TestMemory_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Go(func() {
+			key := fmt.Sprintf("key-%d", i)
+			for range 100 {
+				if err := m.Set(ctx, key, []byte(key)); err != nil {
+					t.Errorf("Set() error = %v", err)
+				}
+				if _, err := m.Get(ctx, key); err != nil {
+					t.Errorf("Get() error = %v", err)
+				}
+				if _, err := m.List(ctx, "key-"); err != nil {
+					t.Errorf("List() error = %v", err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	keys, err := m.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(keys) != 16 {
+		t.Errorf("List() got %d keys, want 16", len(keys))
+	}
+}
+
+func TestMemory_Stat(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	m := NewMemory()
+	m.now = clock.Now
+
+	opts := SetOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Source": "feed"},
+		TTL:         time.Hour,
+	}
+	if err := m.SetWithOptions(ctx, "key", []byte("value"), opts); err != nil {
+		t.Fatalf("SetWithOptions() error = %v", err)
+	}
+
+	info, err := m.Stat(ctx, "key")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+
+	_, version, _ := m.GetVersion(ctx, "key")
+	want := ObjectInfo{
+		Key:          "key",
+		Size:         5,
+		LastModified: clock.Now(),
+		Version:      version,
+		ContentType:  "text/plain",
+		Metadata:     map[string]string{"source": "feed"},
+		Expires:      clock.Now().Add(time.Hour),
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("Stat() = %+v, want %+v", info, want)
+	}
+
+	info.Metadata["source"] = "changed"
+	if again, _ := m.Stat(ctx, "key"); again.Metadata["source"] != "feed" {
+		t.Error("changing Stat()'s metadata changed the stored metadata")
+	}
+
+	// Plain writes replace the metadata.
+	if err := m.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if info, _ := m.Stat(ctx, "key"); info.Metadata != nil || info.ContentType != "" {
+		t.Errorf("Stat() after Set() = %+v, want no content type or metadata", info)
+	}
+
+	if err := m.SetWithOptions(ctx, "key", []byte("value"), opts); err != nil {
+		t.Fatalf("SetWithOptions() error = %v", err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := m.Stat(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() after expiry error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemory_Public(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if err := m.SetWithOptions(ctx, "key", []byte("value"), SetOptions{Public: true}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetWithOptions() with Public error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if _, err := m.SetIf(ctx, "key", []byte("value"), Condition{IfNotExists: true, Options: SetOptions{Public: true}}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetIf() with Public error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if err := m.Exists(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() error = %v, want the public value not to be stored", err)
+	}
+}
@@ -0,0 +1,391 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var storeMirrorDivergence = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "tigris_gtm",
+	Subsystem: "glue",
+	Name:      "store_mirror_divergence_total",
+	Help:      "The number of times a mirror's secondary stopped matching its primary by mirror, secondary and kind: write_failed (the primary was written but the secondary wasn't) or primary_missing (a read found a key in the secondary but not the primary)",
+}, []string{"mirror", "secondary", "kind"})
+
+// MirrorOptions configure a Mirror store.
+type MirrorOptions struct {
+	// Secondaries get a copy of every write made to the primary. At least one
+	// is needed.
+	Secondaries []Interface
+
+	// ReadFallback makes reads that don't find a key in the primary try the
+	// secondaries in order, so data can be read from the store being migrated
+	// away from until it has all been copied.
+	ReadFallback bool
+
+	// Name labels the mirror's divergence metrics, eg: tigris-to-disk.
+	// Defaults to "mirror".
+	Name string
+
+	// Logger gets a warning every time a write to a secondary fails. Defaults
+	// to discarding them.
+	Logger *slog.Logger
+}
+
+// Mirror is a store.Interface that writes to a primary store and then to one
+// or more secondaries, so a store can be migrated to another bucket or driver
+// without downtime: mirror to the new store, copy the existing data over with
+// Sync, then switch the primary.
+//
+// Only failures of the primary are returned. Writes to the secondaries are
+// made after the primary succeeds, and their failures are logged and counted
+// as divergence instead, for Sync to repair later. Listings and versions
+// always come from the primary.
+type Mirror struct {
+	primary     Interface
+	secondaries []Interface
+	opts        MirrorOptions
+}
+
+// NewMirror wraps primary so that every write is copied to the secondaries in
+// opts.
+func NewMirror(primary Interface, opts MirrorOptions) (*Mirror, error) {
+	if len(opts.Secondaries) == 0 {
+		return nil, fmt.Errorf("%w: a mirror needs at least one secondary store", ErrBadConfig)
+	}
+	if opts.Name == "" {
+		opts.Name = "mirror"
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+
+	return &Mirror{
+		primary:     primary,
+		secondaries: opts.Secondaries,
+		opts:        opts,
+	}, nil
+}
+
+// diverged records that the secondary at index i no longer matches the
+// primary.
+func (m *Mirror) diverged(i int, kind string) {
+	storeMirrorDivergence.WithLabelValues(m.opts.Name, strconv.Itoa(i), kind).Inc()
+}
+
+// mirror calls fn on every secondary at once after op succeeded on the
+// primary, and records the secondaries it fails on.
+func (m *Mirror) mirror(op string, fn func(s Interface) error) {
+	var wg sync.WaitGroup
+
+	for i, s := range m.secondaries {
+		wg.Go(func() {
+			if err := fn(s); err != nil {
+				m.diverged(i, "write_failed")
+				m.opts.Logger.Warn("can't mirror store write", "mirror", m.opts.Name, "secondary", i, "op", op, "err", err)
+			}
+		})
+	}
+
+	wg.Wait()
+}
+
+// read calls fn on the primary and, if it doesn't find the key and
+// ReadFallback is set, on each secondary in turn until one does.
+func (m *Mirror) read(fn func(s Interface) error) error {
+	err := fn(m.primary)
+	if !m.opts.ReadFallback || !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	for i, s := range m.secondaries {
+		serr := fn(s)
+		if serr == nil {
+			m.diverged(i, "primary_missing")
+			return nil
+		}
+		if !errors.Is(serr, ErrNotFound) {
+			return serr
+		}
+	}
+
+	return err
+}
+
+// ignoreNotFound treats a missing key in a secondary as already deleted.
+func ignoreNotFound(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Delete removes key from every store. With ReadFallback set, a key that's
+// only in a secondary counts as found.
+func (m *Mirror) Delete(ctx context.Context, key string) error {
+	err := m.primary.Delete(ctx, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	var (
+		lock  sync.Mutex
+		found bool
+	)
+	m.mirror("Delete", func(s Interface) error {
+		serr := s.Delete(ctx, key)
+		if serr == nil {
+			lock.Lock()
+			found = true
+			lock.Unlock()
+		}
+		return ignoreNotFound(serr)
+	})
+
+	if m.opts.ReadFallback && found {
+		return nil
+	}
+	return err
+}
+
+func (m *Mirror) Exists(ctx context.Context, key string) error {
+	return m.read(func(s Interface) error {
+		return s.Exists(ctx, key)
+	})
+}
+
+func (m *Mirror) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := m.read(func(s Interface) (err error) {
+		value, err = s.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (m *Mirror) Set(ctx context.Context, key string, value []byte) error {
+	if err := m.primary.Set(ctx, key, value); err != nil {
+		return err
+	}
+
+	m.mirror("Set", func(s Interface) error {
+		return s.Set(ctx, key, value)
+	})
+	return nil
+}
+
+func (m *Mirror) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := SetWithTTL(ctx, m.primary, key, value, ttl); err != nil {
+		return err
+	}
+
+	m.mirror("SetWithTTL", func(s Interface) error {
+		return SetWithTTL(ctx, s, key, value, ttl)
+	})
+	return nil
+}
+
+func (m *Mirror) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	if err := SetWithOptions(ctx, m.primary, key, value, opts); err != nil {
+		return err
+	}
+
+	m.mirror("SetWithOptions", func(s Interface) error {
+		return SetWithOptions(ctx, s, key, value, opts)
+	})
+	return nil
+}
+
+// GetVersion reads from the primary only, since versions from different
+// stores can't be compared.
+func (m *Mirror) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	cs, ok := m.primary.(ConditionalSetter)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, m.primary)
+	}
+
+	return cs.GetVersion(ctx, key)
+}
+
+// SetIf checks cond against the primary only, and copies the value to the
+// secondaries unconditionally once the primary has been written.
+func (m *Mirror) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	cs, ok := m.primary.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, m.primary)
+	}
+
+	version, err := cs.SetIf(ctx, key, value, cond)
+	if err != nil {
+		return "", err
+	}
+
+	m.mirror("SetIf", func(s Interface) error {
+		return SetWithOptions(ctx, s, key, value, cond.Options)
+	})
+	return version, nil
+}
+
+func (m *Mirror) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	var (
+		r    io.ReadCloser
+		info StreamInfo
+	)
+	err := m.read(func(s Interface) (err error) {
+		r, info, err = GetReader(ctx, s, key)
+		return err
+	})
+	return r, info, err
+}
+
+// SetReader streams r into the primary and then streams the value back out of
+// the primary into each secondary, so r is only read once.
+func (m *Mirror) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	if err := SetReader(ctx, m.primary, key, r, info); err != nil {
+		return err
+	}
+
+	m.mirror("SetReader", func(s Interface) error {
+		pr, pinfo, err := GetReader(ctx, m.primary, key)
+		if err != nil {
+			return err
+		}
+		defer pr.Close()
+
+		return SetReader(ctx, s, key, pr, pinfo)
+	})
+	return nil
+}
+
+func (m *Mirror) List(ctx context.Context, prefix string) ([]string, error) {
+	return m.primary.List(ctx, prefix)
+}
+
+func (m *Mirror) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return Iterate(ctx, m.primary, prefix, opts)
+}
+
+func (m *Mirror) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	return IterateDelimited(ctx, m.primary, prefix, delimiter, opts)
+}
+
+// GetMany reads every key from the primary and, with ReadFallback set, the
+// keys the primary doesn't have from the secondaries.
+func (m *Mirror) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values, err := GetMany(ctx, m.primary, keys)
+	if err != nil || !m.opts.ReadFallback {
+		return values, err
+	}
+
+	for i, s := range m.secondaries {
+		var missing []string
+		for _, key := range keys {
+			if _, ok := values[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+
+		found, err := GetMany(ctx, s, missing)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range found {
+			m.diverged(i, "primary_missing")
+			values[key] = value
+		}
+	}
+
+	return values, nil
+}
+
+func (m *Mirror) SetMany(ctx context.Context, values map[string][]byte) error {
+	if err := SetMany(ctx, m.primary, values); err != nil {
+		return err
+	}
+
+	m.mirror("SetMany", func(s Interface) error {
+		return SetMany(ctx, s, values)
+	})
+	return nil
+}
+
+func (m *Mirror) DeleteMany(ctx context.Context, keys []string) error {
+	if err := DeleteMany(ctx, m.primary, keys); err != nil {
+		return err
+	}
+
+	m.mirror("DeleteMany", func(s Interface) error {
+		return DeleteMany(ctx, s, keys)
+	})
+	return nil
+}
+
+// DeletePrefix returns how many keys were deleted from the primary.
+func (m *Mirror) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted, err := DeletePrefix(ctx, m.primary, prefix)
+	if err != nil {
+		return deleted, err
+	}
+
+	m.mirror("DeletePrefix", func(s Interface) error {
+		_, err := DeletePrefix(ctx, s, prefix)
+		return err
+	})
+	return deleted, nil
+}
+
+// Copy copies inside each store, so a secondary that doesn't have src yet
+// can't copy it and diverges.
+func (m *Mirror) Copy(ctx context.Context, src, dst string) error {
+	if err := Copy(ctx, m.primary, src, dst); err != nil {
+		return err
+	}
+
+	m.mirror("Copy", func(s Interface) error {
+		return Copy(ctx, s, src, dst)
+	})
+	return nil
+}
+
+// Move moves inside each store, the same way as Copy.
+func (m *Mirror) Move(ctx context.Context, src, dst string) error {
+	if err := Move(ctx, m.primary, src, dst); err != nil {
+		return err
+	}
+
+	m.mirror("Move", func(s Interface) error {
+		return Move(ctx, s, src, dst)
+	})
+	return nil
+}
+
+func (m *Mirror) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := m.read(func(s Interface) (err error) {
+		info, err = Stat(ctx, s, key)
+		return err
+	})
+	return info, err
+}
+
+func (m *Mirror) PublicURL(ctx context.Context, key string) (string, error) {
+	return PublicURL(ctx, m.primary, key)
+}
+
+func (m *Mirror) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return PresignedURL(ctx, m.primary, key, ttl)
+}
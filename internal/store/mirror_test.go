@@ -0,0 +1,251 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMirror(t *testing.T, primary Interface, opts MirrorOptions) *Mirror {
+	t.Helper()
+
+	m, err := NewMirror(primary, opts)
+	if err != nil {
+		t.Fatalf("NewMirror() error = %v", err)
+	}
+	return m
+}
+
+// storeContents reads every key and value in s.
+func storeContents(t *testing.T, s Interface) map[string]string {
+	t.Helper()
+
+	keys, err := s.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	result := map[string]string{}
+	for _, key := range keys {
+		value, err := s.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", key, err)
+		}
+		result[key] = string(value)
+	}
+	return result
+}
+
+func TestNewMirror_NoSecondaries(t *testing.T) {
+	if _, err := NewMirror(NewMemory(), MirrorOptions{}); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("NewMirror() error = %v, want %v", err, ErrBadConfig)
+	}
+}
+
+func TestMirror_Writes(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		op   func(m *Mirror) error
+		want map[string]string
+	}{
+		{
+			name: "set",
+			op:   func(m *Mirror) error { return m.Set(ctx, "new", []byte("value")) },
+			want: map[string]string{"a": "a", "b/c": "b/c", "new": "value"},
+		},
+		{
+			name: "set with ttl",
+			op:   func(m *Mirror) error { return m.SetWithTTL(ctx, "new", []byte("value"), time.Hour) },
+			want: map[string]string{"a": "a", "b/c": "b/c", "new": "value"},
+		},
+		{
+			name: "set with options",
+			op: func(m *Mirror) error {
+				return m.SetWithOptions(ctx, "new", []byte("value"), SetOptions{ContentType: "text/plain"})
+			},
+			want: map[string]string{"a": "a", "b/c": "b/c", "new": "value"},
+		},
+		{
+			name: "set reader",
+			op: func(m *Mirror) error {
+				return m.SetReader(ctx, "new", strings.NewReader("streamed"), StreamInfo{ContentLength: -1})
+			},
+			want: map[string]string{"a": "a", "b/c": "b/c", "new": "streamed"},
+		},
+		{
+			name: "set if",
+			op: func(m *Mirror) error {
+				_, err := m.SetIf(ctx, "new", []byte("value"), Condition{IfNotExists: true})
+				return err
+			},
+			want: map[string]string{"a": "a", "b/c": "b/c", "new": "value"},
+		},
+		{
+			name: "set many",
+			op:   func(m *Mirror) error { return m.SetMany(ctx, map[string][]byte{"a": []byte("1"), "d": []byte("2")}) },
+			want: map[string]string{"a": "1", "b/c": "b/c", "d": "2"},
+		},
+		{
+			name: "delete",
+			op:   func(m *Mirror) error { return m.Delete(ctx, "a") },
+			want: map[string]string{"b/c": "b/c"},
+		},
+		{
+			name: "delete many",
+			op:   func(m *Mirror) error { return m.DeleteMany(ctx, []string{"a", "b/c"}) },
+			want: map[string]string{},
+		},
+		{
+			name: "delete prefix",
+			op:   func(m *Mirror) error { _, err := m.DeletePrefix(ctx, "b/"); return err },
+			want: map[string]string{"a": "a"},
+		},
+		{
+			name: "copy",
+			op:   func(m *Mirror) error { return m.Copy(ctx, "a", "copied") },
+			want: map[string]string{"a": "a", "b/c": "b/c", "copied": "a"},
+		},
+		{
+			name: "move",
+			op:   func(m *Mirror) error { return m.Move(ctx, "b/c", "moved") },
+			want: map[string]string{"a": "a", "moved": "b/c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := []Interface{NewMemory(), NewMemory(), newTestDirectory(t, nil)}
+			for _, s := range stores {
+				for _, key := range []string{"a", "b/c"} {
+					s.Set(ctx, key, []byte(key))
+				}
+			}
+
+			m := newTestMirror(t, stores[0], MirrorOptions{Secondaries: stores[1:]})
+			if err := tt.op(m); err != nil {
+				t.Fatalf("op error = %v", err)
+			}
+
+			for i, s := range stores {
+				got := storeContents(t, s)
+				if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("store %d holds %v, want %v", i, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMirror_SecondaryFailure(t *testing.T) {
+	ctx := context.Background()
+
+	primary := NewMemory()
+	failing := &permissionStore{Memory: NewMemory(), denied: map[string]bool{"key": true}}
+	healthy := NewMemory()
+
+	var logs bytes.Buffer
+	m := newTestMirror(t, primary, MirrorOptions{
+		Secondaries: []Interface{failing, healthy},
+		Name:        "test-secondary-failure",
+		Logger:      slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+
+	before := counterValue(t, storeMirrorDivergence.WithLabelValues("test-secondary-failure", "0", "write_failed"))
+
+	if err := m.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v, want secondary failures ignored", err)
+	}
+
+	if got := counterValue(t, storeMirrorDivergence.WithLabelValues("test-secondary-failure", "0", "write_failed")) - before; got != 1 {
+		t.Errorf("write_failed divergence went up by %v, want 1", got)
+	}
+	if got := counterValue(t, storeMirrorDivergence.WithLabelValues("test-secondary-failure", "1", "write_failed")); got != 0 {
+		t.Errorf("write_failed divergence of the healthy secondary = %v, want 0", got)
+	}
+	if !strings.Contains(logs.String(), "can't mirror store write") {
+		t.Errorf("log = %q, want a warning about the failed write", logs.String())
+	}
+
+	for _, s := range []Interface{primary, healthy} {
+		if got, err := s.Get(ctx, "key"); err != nil || string(got) != "value" {
+			t.Errorf("Get() = %q, %v, want the value written", got, err)
+		}
+	}
+
+	// A failed primary write isn't mirrored at all.
+	denied := &permissionStore{Memory: NewMemory(), denied: map[string]bool{"key": true}}
+	m = newTestMirror(t, denied, MirrorOptions{Secondaries: []Interface{healthy}})
+	if err := m.Set(ctx, "key", []byte("other")); !errors.Is(err, ErrPermission) {
+		t.Fatalf("Set() error = %v, want %v", err, ErrPermission)
+	}
+	if got, _ := healthy.Get(ctx, "key"); string(got) != "value" {
+		t.Errorf("secondary holds %q after a failed primary write, want it untouched", got)
+	}
+}
+
+func TestMirror_ReadFallback(t *testing.T) {
+	ctx := context.Background()
+
+	primary := NewMemory()
+	primary.Set(ctx, "both", []byte("primary"))
+
+	secondary := NewMemory()
+	secondary.Set(ctx, "both", []byte("secondary"))
+	secondary.Set(ctx, "old", []byte("old"))
+
+	without := newTestMirror(t, primary, MirrorOptions{Secondaries: []Interface{secondary}})
+	if _, err := without.Get(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() without fallback error = %v, want %v", err, ErrNotFound)
+	}
+
+	m := newTestMirror(t, primary, MirrorOptions{
+		Secondaries:  []Interface{secondary},
+		ReadFallback: true,
+		Name:         "test-read-fallback",
+	})
+	before := counterValue(t, storeMirrorDivergence.WithLabelValues("test-read-fallback", "0", "primary_missing"))
+
+	if got, err := m.Get(ctx, "both"); err != nil || string(got) != "primary" {
+		t.Errorf("Get(both) = %q, %v, want the primary's value", got, err)
+	}
+	if got, err := m.Get(ctx, "old"); err != nil || string(got) != "old" {
+		t.Errorf("Get(old) = %q, %v, want the secondary's value", got, err)
+	}
+	if err := m.Exists(ctx, "old"); err != nil {
+		t.Errorf("Exists(old) error = %v", err)
+	}
+	if _, err := m.Get(ctx, "nowhere"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(nowhere) error = %v, want %v", err, ErrNotFound)
+	}
+
+	values, err := m.GetMany(ctx, []string{"both", "old", "nowhere"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if len(values) != 2 || string(values["both"]) != "primary" || string(values["old"]) != "old" {
+		t.Errorf("GetMany() = %q, want both from the primary and old from the secondary", values)
+	}
+
+	if got := counterValue(t, storeMirrorDivergence.WithLabelValues("test-read-fallback", "0", "primary_missing")) - before; got != 3 {
+		t.Errorf("primary_missing divergence went up by %v, want 3", got)
+	}
+
+	if keys, _ := m.List(ctx, ""); !slices.Equal(keys, []string{"both"}) {
+		t.Errorf("List() = %v, want only the primary's keys", keys)
+	}
+
+	if err := m.Delete(ctx, "old"); err != nil {
+		t.Errorf("Delete(old) error = %v, want keys only in a secondary deleted", err)
+	}
+	if err := m.Exists(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists(old) after Delete() error = %v, want %v", err, ErrNotFound)
+	}
+}
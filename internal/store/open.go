@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Open creates a store from a URL. The scheme picks the driver:
+//
+//   - s3://bucket/prefix uses S3API with the bucket, scoping every key under
+//     the optional prefix. Operations that fail with ErrUnavailable are
+//     retried with NewRetry.
+//   - file:///path/to/dir (or file://./relative/dir) uses Directory.
+//   - memory:// uses Memory.
+//
+// S3 stores are configured with these query parameters (see S3Options).
+// Credentials always come from the environment.
+//
+//   - endpoint: the S3 API URL, eg: http://localhost:9000.
+//   - region: the region to sign requests for, eg: auto.
+//   - path-style: whether to address the bucket in the path, eg: true.
+//   - public-url: where public values are served from, eg:
+//     https://cdn.example.com.
+//   - hide-expired: whether to leave expired keys out of listings, at the cost
+//     of a request per listed key, eg: true.
+//
+// Every driver, and the LRU cache if there is one, is wrapped with
+// NewInstrumented and NewTraced so its operations show up in metrics and
+// traces.
+//
+// Adding the query parameter mirror wraps the store in a Mirror that copies
+// every write to the store at that URL, eg:
+// s3://new-bucket?mirror=s3%3A%2F%2Fold-bucket. The parameter can be repeated
+// to mirror to several stores, and is configured with (see MirrorOptions):
+//
+//   - mirror-fallback: whether to read keys this store doesn't have from the
+//     mirrors, eg: true.
+//
+// Adding the query parameter lru=true wraps the store in an LRU cache, eg:
+// s3://bucket?lru=true. The cache is configured with these parameters, any of
+// which also turn it on (see LRUOptions):
+//
+//   - lru-max-entries: the most values to cache, eg: 1000.
+//   - lru-max-bytes: the most bytes of values to cache, eg: 67108864.
+//   - lru-ttl: how long to cache values for, eg: 5m.
+//   - lru-negative-ttl: how long to remember missing keys for, eg: 30s.
+//   - lru-exists: whether to answer Exists from the cache, eg: true.
+func Open(ctx context.Context, storeURL string) (Interface, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: can't parse store URL %q: %w", ErrBadConfig, storeURL, err)
+	}
+
+	q := u.Query()
+
+	var result Interface
+
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%w: store URL %q has no bucket", ErrBadConfig, storeURL)
+		}
+
+		opts, err := s3OptionsFromQuery(q)
+		if err != nil {
+			return nil, fmt.Errorf("%w (store URL %q)", err, storeURL)
+		}
+
+		st, err := NewS3API(ctx, u.Host, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%w (store URL %q)", err, storeURL)
+		}
+
+		// Retry outside of the metrics and traces so that every attempt shows
+		// up in them.
+		result, err = NewRetry(observed(st, "s3api"), RetryOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		if prefix := strings.Trim(u.Path, "/"); prefix != "" {
+			result = NewPrefixed(result, prefix)
+		}
+
+	case "file":
+		// file://./var parses with "." as the host, so put relative paths back
+		// together.
+		root := u.Host + u.Path
+		if u.Opaque != "" {
+			root = u.Opaque
+		}
+
+		st, err := NewDirectory(filepath.FromSlash(root))
+		if err != nil {
+			return nil, err
+		}
+		result = observed(st, "directory")
+
+	case "memory":
+		result = observed(NewMemory(), "memory")
+
+	default:
+		return nil, fmt.Errorf("%w: unknown store scheme %q in %q", ErrBadConfig, u.Scheme, storeURL)
+	}
+
+	if q.Has("mirror") {
+		result, err = openMirror(ctx, result, q)
+		if err != nil {
+			return nil, fmt.Errorf("%w (store URL %q)", err, storeURL)
+		}
+	}
+
+	useLRU, opts, err := lruOptionsFromQuery(q)
+	if err != nil {
+		return nil, fmt.Errorf("%w (store URL %q)", err, storeURL)
+	}
+
+	if useLRU {
+		l, err := NewLRU(result, opts)
+		if err != nil {
+			return nil, err
+		}
+		result = observed(l, "lru")
+	}
+
+	return result, nil
+}
+
+// observed wraps a store so that its operations show up in metrics and traces.
+func observed(st Interface, driver string) Interface {
+	return NewTraced(NewInstrumented(st, driver), driver)
+}
+
+// openMirror opens the stores named by the mirror query parameters documented
+// on Open and mirrors primary to them.
+func openMirror(ctx context.Context, primary Interface, q url.Values) (Interface, error) {
+	opts := MirrorOptions{Logger: slog.Default()}
+
+	if q.Has("mirror-fallback") {
+		var err error
+		opts.ReadFallback, err = strconv.ParseBool(q.Get("mirror-fallback"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: mirror-fallback=%q is not a boolean: %w", ErrBadConfig, q.Get("mirror-fallback"), err)
+		}
+	}
+
+	for _, mirrorURL := range q["mirror"] {
+		st, err := Open(ctx, mirrorURL)
+		if err != nil {
+			return nil, fmt.Errorf("can't open mirror: %w", err)
+		}
+		opts.Secondaries = append(opts.Secondaries, st)
+	}
+
+	return NewMirror(primary, opts)
+}
+
+// s3OptionsFromQuery reads the s3 query parameters documented on Open.
+func s3OptionsFromQuery(q url.Values) (S3Options, error) {
+	opts := S3Options{
+		Endpoint:  q.Get("endpoint"),
+		Region:    q.Get("region"),
+		PublicURL: q.Get("public-url"),
+	}
+
+	if q.Has("path-style") {
+		var err error
+		opts.UsePathStyle, err = strconv.ParseBool(q.Get("path-style"))
+		if err != nil {
+			return S3Options{}, fmt.Errorf("%w: path-style=%q is not a boolean: %w", ErrBadConfig, q.Get("path-style"), err)
+		}
+	}
+
+	if q.Has("hide-expired") {
+		var err error
+		opts.HideExpired, err = strconv.ParseBool(q.Get("hide-expired"))
+		if err != nil {
+			return S3Options{}, fmt.Errorf("%w: hide-expired=%q is not a boolean: %w", ErrBadConfig, q.Get("hide-expired"), err)
+		}
+	}
+
+	return opts, nil
+}
+
+// lruOptionsFromQuery reads the lru query parameters documented on Open.
+func lruOptionsFromQuery(q url.Values) (bool, LRUOptions, error) {
+	var (
+		opts   LRUOptions
+		useLRU bool
+		err    error
+	)
+
+	parse := func(name string, fn func(string) error) {
+		if err != nil || !q.Has(name) {
+			return
+		}
+
+		useLRU = true
+		if perr := fn(q.Get(name)); perr != nil {
+			err = fmt.Errorf("%w: %s=%q is invalid: %w", ErrBadConfig, name, q.Get(name), perr)
+		}
+	}
+
+	parse("lru-max-entries", func(v string) (err error) {
+		opts.MaxEntries, err = strconv.Atoi(v)
+		return err
+	})
+	parse("lru-max-bytes", func(v string) (err error) {
+		opts.MaxBytes, err = strconv.ParseInt(v, 10, 64)
+		return err
+	})
+	parse("lru-ttl", func(v string) (err error) {
+		opts.TTL, err = time.ParseDuration(v)
+		return err
+	})
+	parse("lru-negative-ttl", func(v string) (err error) {
+		opts.NegativeTTL, err = time.ParseDuration(v)
+		return err
+	})
+	parse("lru-exists", func(v string) (err error) {
+		opts.CacheExists, err = strconv.ParseBool(v)
+		return err
+	})
+
+	if err != nil {
+		return false, LRUOptions{}, err
+	}
+
+	if q.Has("lru") {
+		useLRU, err = strconv.ParseBool(q.Get("lru"))
+		if err != nil {
+			return false, LRUOptions{}, fmt.Errorf("%w: lru=%q is not a boolean: %w", ErrBadConfig, q.Get("lru"), err)
+		}
+	}
+
+	return useLRU, opts, nil
+}
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// uninstrument checks that s is traced and instrumented as driver and returns
+// the store it wraps.
+func uninstrument(t *testing.T, s Interface, driver string) Interface {
+	t.Helper()
+
+	tr, ok := s.(*Traced)
+	if !ok {
+		t.Fatalf("Open() returned %T, want *Traced", s)
+	}
+
+	i, ok := tr.underlying.(*Instrumented)
+	if !ok {
+		t.Fatalf("Open() traced %T, want *Instrumented", tr.underlying)
+	}
+
+	if tr.driver != driver || i.driver != driver {
+		t.Errorf("Open() driver = %q/%q, want %q", tr.driver, i.driver, driver)
+	}
+
+	return i.underlying
+}
+
+func isMemory(s Interface) bool {
+	_, ok := s.(*Memory)
+	return ok
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		url     string
+		chdir   bool
+		check   func(t *testing.T, s Interface)
+		wantErr error
+	}{
+		{
+			name: "memory",
+			url:  "memory://",
+			check: func(t *testing.T, s Interface) {
+				if s := uninstrument(t, s, "memory"); !isMemory(s) {
+					t.Errorf("Open() returned %T, want *Memory", s)
+				}
+			},
+		},
+		{
+			name: "absolute file path",
+			url:  "file://" + filepath.ToSlash(dir),
+			check: func(t *testing.T, s Interface) {
+				d, ok := uninstrument(t, s, "directory").(*Directory)
+				if !ok {
+					t.Fatalf("Open() returned %T, want *Directory", s)
+				}
+				if d.root != dir {
+					t.Errorf("Open() root = %q, want %q", d.root, dir)
+				}
+			},
+		},
+		{
+			name:  "relative file path",
+			url:   "file://./var",
+			chdir: true,
+			check: func(t *testing.T, s Interface) {
+				d, ok := uninstrument(t, s, "directory").(*Directory)
+				if !ok {
+					t.Fatalf("Open() returned %T, want *Directory", s)
+				}
+				if d.root != "var" && d.root != "./var" {
+					t.Errorf("Open() root = %q, want ./var", d.root)
+				}
+			},
+		},
+		{
+			name: "lru wrapper",
+			url:  "memory://?lru=true",
+			check: func(t *testing.T, s Interface) {
+				l, ok := uninstrument(t, s, "lru").(*LRU)
+				if !ok {
+					t.Fatalf("Open() returned %T, want *LRU", s)
+				}
+				if !isMemory(uninstrument(t, l.underlying, "memory")) {
+					t.Errorf("Open() LRU wraps %T, want *Memory", l.underlying)
+				}
+			},
+		},
+		{
+			name: "lru disabled",
+			url:  "memory://?lru=false",
+			check: func(t *testing.T, s Interface) {
+				if s := uninstrument(t, s, "memory"); !isMemory(s) {
+					t.Errorf("Open() returned %T, want *Memory", s)
+				}
+			},
+		},
+		{
+			name: "lru options",
+			url:  "memory://?lru-max-bytes=1024&lru-ttl=5m&lru-negative-ttl=30s&lru-exists=true",
+			check: func(t *testing.T, s Interface) {
+				l, ok := uninstrument(t, s, "lru").(*LRU)
+				if !ok {
+					t.Fatalf("Open() returned %T, want *LRU", s)
+				}
+
+				want := LRUOptions{MaxEntries: 512, MaxBytes: 1024, TTL: 5 * time.Minute, NegativeTTL: 30 * time.Second, CacheExists: true}
+				if l.opts != want {
+					t.Errorf("Open() LRU options = %+v, want %+v", l.opts, want)
+				}
+			},
+		},
+		{
+			name: "lru options with lru disabled",
+			url:  "memory://?lru=false&lru-ttl=5m",
+			check: func(t *testing.T, s Interface) {
+				if s := uninstrument(t, s, "memory"); !isMemory(s) {
+					t.Errorf("Open() returned %T, want *Memory", s)
+				}
+			},
+		},
+		{
+			name:    "bad lru option",
+			url:     "memory://?lru-ttl=soon",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "negative lru option",
+			url:     "memory://?lru-max-entries=-1",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "bad lru value",
+			url:     "memory://?lru=sometimes",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name: "mirror",
+			url:  "memory://?mirror=memory%3A%2F%2F&mirror=memory%3A%2F%2F%3Flru%3Dtrue&mirror-fallback=true",
+			check: func(t *testing.T, s Interface) {
+				m, ok := s.(*Mirror)
+				if !ok {
+					t.Fatalf("Open() returned %T, want *Mirror", s)
+				}
+				if !isMemory(uninstrument(t, m.primary, "memory")) {
+					t.Errorf("Open() mirror primary is %T, want *Memory", m.primary)
+				}
+				if len(m.secondaries) != 2 || !m.opts.ReadFallback {
+					t.Errorf("Open() mirror has %d secondaries and ReadFallback %v, want 2 and true", len(m.secondaries), m.opts.ReadFallback)
+				}
+				if _, ok := uninstrument(t, m.secondaries[1], "lru").(*LRU); !ok {
+					t.Errorf("Open() second mirror is %T, want *LRU", m.secondaries[1])
+				}
+			},
+		},
+		{
+			name:    "bad mirror",
+			url:     "memory://?mirror=nope%3A%2F%2F",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "bad mirror-fallback",
+			url:     "memory://?mirror=memory%3A%2F%2F&mirror-fallback=maybe",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "s3 without bucket",
+			url:     "s3:///prefix",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name: "s3 public-url",
+			url:  "s3://bucket/prefix?public-url=https://cdn.example/assets",
+			check: func(t *testing.T, s Interface) {
+				got, err := PublicURL(context.Background(), s, "a.webp")
+				if err != nil {
+					t.Fatalf("PublicURL() error = %v", err)
+				}
+				if want := "https://cdn.example/assets/prefix/a.webp"; got != want {
+					t.Errorf("PublicURL() = %q, want %q", got, want)
+				}
+			},
+		},
+		{
+			name:    "s3 relative public-url",
+			url:     "s3://bucket?public-url=/assets",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "s3 bad path-style",
+			url:     "s3://bucket?path-style=sometimes",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "s3 bad hide-expired",
+			url:     "s3://bucket?hide-expired=sometimes",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "unknown scheme",
+			url:     "ftp://example.com",
+			wantErr: ErrBadConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.chdir {
+				t.Chdir(t.TempDir())
+			}
+
+			s, err := Open(context.Background(), tt.url)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.check != nil {
+				tt.check(t, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"time"
+)
+
+// NewPrefixed scopes underlying to the keys under prefix, so that the key "foo"
+// is stored as "prefix/foo". An empty prefix passes keys through unchanged.
+func NewPrefixed(underlying Interface, prefix string) *Prefixed {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &Prefixed{
+		underlying: underlying,
+		prefix:     prefix,
+	}
+}
+
+// Prefixed is a store.Interface that keeps every key under a fixed prefix of
+// another store.
+type Prefixed struct {
+	underlying Interface
+	prefix     string
+}
+
+func (p *Prefixed) key(key string) string {
+	return p.prefix + key
+}
+
+func (p *Prefixed) Delete(ctx context.Context, key string) error {
+	return p.underlying.Delete(ctx, p.key(key))
+}
+
+func (p *Prefixed) Exists(ctx context.Context, key string) error {
+	return p.underlying.Exists(ctx, p.key(key))
+}
+
+func (p *Prefixed) Get(ctx context.Context, key string) ([]byte, error) {
+	return p.underlying.Get(ctx, p.key(key))
+}
+
+func (p *Prefixed) Set(ctx context.Context, key string, value []byte) error {
+	return p.underlying.Set(ctx, p.key(key), value)
+}
+
+func (p *Prefixed) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return SetWithTTL(ctx, p.underlying, p.key(key), value, ttl)
+}
+
+func (p *Prefixed) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	return GetVersion(ctx, p.underlying, p.key(key))
+}
+
+func (p *Prefixed) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	cs, ok := p.underlying.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, p.underlying)
+	}
+
+	return cs.SetIf(ctx, p.key(key), value, cond)
+}
+
+func (p *Prefixed) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	return GetReader(ctx, p.underlying, p.key(key))
+}
+
+func (p *Prefixed) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	return SetReader(ctx, p.underlying, p.key(key), r, info)
+}
+
+func (p *Prefixed) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := p.underlying.List(ctx, p.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, p.prefix)
+	}
+
+	return keys, nil
+}
+
+func (p *Prefixed) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	if opts.StartAfter != "" {
+		opts.StartAfter = p.key(opts.StartAfter)
+	}
+
+	return func(yield func(string, error) bool) {
+		for key, err := range Iterate(ctx, p.underlying, p.key(prefix), opts) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			if !yield(strings.TrimPrefix(key, p.prefix), nil) {
+				return
+			}
+		}
+	}
+}
+
+func (p *Prefixed) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	if opts.StartAfter != "" {
+		opts.StartAfter = p.key(opts.StartAfter)
+	}
+
+	return func(yield func(Entry, error) bool) {
+		for entry, err := range IterateDelimited(ctx, p.underlying, p.key(prefix), delimiter, opts) {
+			if err != nil {
+				yield(Entry{}, err)
+				return
+			}
+
+			entry.Name = strings.TrimPrefix(entry.Name, p.prefix)
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (p *Prefixed) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = p.key(key)
+	}
+
+	values, err := GetMany(ctx, p.underlying, prefixed)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte, len(values))
+	for key, value := range values {
+		result[strings.TrimPrefix(key, p.prefix)] = value
+	}
+
+	return result, nil
+}
+
+func (p *Prefixed) SetMany(ctx context.Context, values map[string][]byte) error {
+	prefixed := make(map[string][]byte, len(values))
+	for key, value := range values {
+		prefixed[p.key(key)] = value
+	}
+
+	return SetMany(ctx, p.underlying, prefixed)
+}
+
+func (p *Prefixed) DeleteMany(ctx context.Context, keys []string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = p.key(key)
+	}
+
+	return DeleteMany(ctx, p.underlying, prefixed)
+}
+
+// DeletePrefix only removes keys under this store's prefix, even when prefix
+// is empty.
+func (p *Prefixed) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	return DeletePrefix(ctx, p.underlying, p.key(prefix))
+}
+
+func (p *Prefixed) Copy(ctx context.Context, src, dst string) error {
+	return Copy(ctx, p.underlying, p.key(src), p.key(dst))
+}
+
+func (p *Prefixed) Move(ctx context.Context, src, dst string) error {
+	return Move(ctx, p.underlying, p.key(src), p.key(dst))
+}
+
+func (p *Prefixed) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := Stat(ctx, p.underlying, p.key(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info.Key = key
+	return info, nil
+}
+
+func (p *Prefixed) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	return SetWithOptions(ctx, p.underlying, p.key(key), value, opts)
+}
+
+func (p *Prefixed) PublicURL(ctx context.Context, key string) (string, error) {
+	return PublicURL(ctx, p.underlying, p.key(key))
+}
+
+func (p *Prefixed) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return PresignedURL(ctx, p.underlying, p.key(key), ttl)
+}
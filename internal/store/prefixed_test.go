@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestPrefixed(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	p := NewPrefixed(m, "scope")
+
+	if err := p.Set(ctx, "a", []byte("1")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := p.Set(ctx, "sub/b", []byte("2")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := m.Set(ctx, "outside", []byte("3")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if _, err := m.Get(ctx, "scope/a"); err != nil {
+		t.Errorf("Set() didn't store under the prefix: %v", err)
+	}
+
+	keys, err := p.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"a", "sub/b"}; !slices.Equal(keys, want) {
+		t.Errorf("List() got = %v, want %v", keys, want)
+	}
+
+	var iterated []string
+	for key, err := range p.Iterate(ctx, "", ListOptions{StartAfter: "a"}) {
+		if err != nil {
+			t.Fatalf("Iterate() error = %v", err)
+		}
+		iterated = append(iterated, key)
+	}
+	if want := []string{"sub/b"}; !slices.Equal(iterated, want) {
+		t.Errorf("Iterate() got = %v, want %v", iterated, want)
+	}
+
+	if err := Create(ctx, p, "a", []byte("again")); !errors.Is(err, ErrConflict) {
+		t.Errorf("Create() error = %v, want %v", err, ErrConflict)
+	}
+
+	if err := p.Exists(ctx, "outside"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() of key outside the prefix error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,449 @@
+package store
+
+import (
+	"context"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"math/rand/v2"
+	"time"
+)
+
+// retryTokenMetadataKey is the metadata key Retry.SetIf keeps its token in.
+// Stores leave it out of what Stat returns, so it isn't mistaken for user
+// metadata and copied along with it, unless ctx comes from withRetryToken.
+const retryTokenMetadataKey = "glue-retry-token"
+
+// retryTokenContextKey is the context key withRetryToken sets.
+type retryTokenContextKey struct{}
+
+// withRetryToken asks the stores Stat is called on with ctx to keep the token
+// Retry.SetIf tags values with in their metadata.
+func withRetryToken(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryTokenContextKey{}, true)
+}
+
+// hideRetryToken removes the token Retry.SetIf tags values with from
+// metadata, unless ctx comes from withRetryToken.
+func hideRetryToken(ctx context.Context, metadata map[string]string) map[string]string {
+	if keep, _ := ctx.Value(retryTokenContextKey{}).(bool); keep {
+		return metadata
+	}
+
+	delete(metadata, retryTokenMetadataKey)
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	return metadata
+}
+
+// RetryOptions configure a Retry store. The zero value retries each operation
+// up to three more times, waiting about 100ms, 200ms and then 400ms between
+// attempts.
+type RetryOptions struct {
+	// MaxAttempts is the most times an operation is tried, including the
+	// first. Defaults to 4.
+	MaxAttempts int
+
+	// BaseDelay is how long to wait before the first retry. Each retry after
+	// that waits twice as long as the one before it. Defaults to 100ms.
+	BaseDelay time.Duration
+
+	// MaxDelay caps how long to wait between attempts. Defaults to 5s.
+	MaxDelay time.Duration
+}
+
+// Retry is a store.Interface that tries operations on another store again
+// when they fail with ErrUnavailable, backing off exponentially with jitter
+// between attempts. Every other error, including ErrNotFound and
+// ErrPermission, is returned straight away.
+type Retry struct {
+	underlying Interface
+	opts       RetryOptions
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetry wraps underlying so that operations failing with ErrUnavailable
+// are retried as configured by opts.
+func NewRetry(underlying Interface, opts RetryOptions) (*Retry, error) {
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 4
+	}
+	if opts.BaseDelay == 0 {
+		opts.BaseDelay = 100 * time.Millisecond
+	}
+	if opts.MaxDelay == 0 {
+		opts.MaxDelay = 5 * time.Second
+	}
+
+	if opts.MaxAttempts < 0 || opts.BaseDelay < 0 || opts.MaxDelay < 0 {
+		return nil, fmt.Errorf("%w: retry options must not be negative", ErrBadConfig)
+	}
+
+	return &Retry{
+		underlying: underlying,
+		opts:       opts,
+		sleep:      sleepContext,
+	}, nil
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// backoff returns how long to wait after the given failed attempt, counting
+// from 1. The delay is picked at random from the upper half of the
+// exponential backoff so that clients throttled at the same time don't all
+// retry at the same time.
+func (r *Retry) backoff(attempt int) time.Duration {
+	d := r.opts.BaseDelay
+	for i := 1; i < attempt && d < r.opts.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, r.opts.MaxDelay)
+
+	if d <= 1 {
+		return d
+	}
+
+	return d/2 + rand.N(d/2)
+}
+
+// do calls fn until it succeeds, fails with an error that isn't
+// ErrUnavailable, runs out of attempts or ctx is done. fn is given the
+// number of the attempt, counting from 1.
+func (r *Retry) do(ctx context.Context, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !errors.Is(err, ErrUnavailable) || attempt >= r.opts.MaxAttempts {
+			return err
+		}
+
+		if serr := r.sleep(ctx, r.backoff(attempt)); serr != nil {
+			return err
+		}
+	}
+}
+
+// Delete treats a missing key on a retry as success, since an earlier
+// attempt may have deleted it before failing.
+func (r *Retry) Delete(ctx context.Context, key string) error {
+	return r.do(ctx, func(attempt int) error {
+		err := r.underlying.Delete(ctx, key)
+		if attempt > 1 && errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (r *Retry) Exists(ctx context.Context, key string) error {
+	return r.do(ctx, func(int) error {
+		return r.underlying.Exists(ctx, key)
+	})
+}
+
+func (r *Retry) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := r.do(ctx, func(int) (err error) {
+		value, err = r.underlying.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (r *Retry) Set(ctx context.Context, key string, value []byte) error {
+	return r.do(ctx, func(int) error {
+		return r.underlying.Set(ctx, key, value)
+	})
+}
+
+func (r *Retry) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.do(ctx, func(int) error {
+		return SetWithTTL(ctx, r.underlying, key, value, ttl)
+	})
+}
+
+func (r *Retry) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	var (
+		value   []byte
+		version string
+	)
+	err := r.do(ctx, func(int) (err error) {
+		value, version, err = GetVersion(ctx, r.underlying, key)
+		return err
+	})
+	return value, version, err
+}
+
+// SetIf tags the value with a random token in its metadata, so that a
+// conflict on a retry can be told apart from another writer: it counts as
+// success only if the key holds this call's token, meaning an earlier attempt
+// wrote it before failing. Two writers with the same value still conflict, so
+// claims with Create stay exclusive. Stores that don't keep metadata can't
+// tell, so their conflicts are always returned.
+//
+// The token stays stored with the value, but stores leave it out of Stat, so
+// it isn't seen or copied by Sync and the like.
+func (r *Retry) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	cs, ok := r.underlying.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, r.underlying)
+	}
+
+	token := crand.Text()
+	cond.Options.Metadata = maps.Clone(cond.Options.Metadata)
+	if cond.Options.Metadata == nil {
+		cond.Options.Metadata = map[string]string{}
+	}
+	cond.Options.Metadata[retryTokenMetadataKey] = token
+
+	var version string
+	err := r.do(ctx, func(attempt int) (err error) {
+		version, err = cs.SetIf(ctx, key, value, cond)
+		if attempt > 1 && errors.Is(err, ErrConflict) {
+			info, serr := Stat(withRetryToken(ctx), r.underlying, key)
+			if serr == nil && info.Metadata[retryTokenMetadataKey] == token {
+				version = info.Version
+				return nil
+			}
+		}
+		return err
+	})
+	return version, err
+}
+
+// GetReader retries opening a value, not reading it.
+func (r *Retry) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	var (
+		rc   io.ReadCloser
+		info StreamInfo
+	)
+	err := r.do(ctx, func(int) (err error) {
+		rc, info, err = GetReader(ctx, r.underlying, key)
+		return err
+	})
+	return rc, info, err
+}
+
+// SetReader can only retry when body is an io.Seeker, so that it can be
+// rewound to where it was when SetReader was called. Other readers are tried
+// once.
+func (r *Retry) SetReader(ctx context.Context, key string, body io.Reader, info StreamInfo) error {
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return SetReader(ctx, r.underlying, key, body, info)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return SetReader(ctx, r.underlying, key, body, info)
+	}
+
+	return r.do(ctx, func(attempt int) error {
+		if attempt > 1 {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return fmt.Errorf("can't rewind value for %s: %w", key, err)
+			}
+		}
+		return SetReader(ctx, r.underlying, key, body, info)
+	})
+}
+
+func (r *Retry) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := r.do(ctx, func(int) (err error) {
+		keys, err = r.underlying.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+// Iterate resumes after the last key it yielded when the listing fails part
+// of the way through. The attempts are counted from the last key yielded, so
+// a long listing can survive any number of spread out failures.
+func (r *Retry) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		resume := opts
+		yielded := 0
+
+		for attempt := 1; ; attempt++ {
+			var err error
+
+			for key, ierr := range Iterate(ctx, r.underlying, prefix, resume) {
+				if ierr != nil {
+					err = ierr
+					break
+				}
+
+				attempt = 1
+				yielded++
+				resume.StartAfter = key
+
+				if !yield(key, nil) {
+					return
+				}
+			}
+
+			if err == nil {
+				return
+			}
+
+			if !errors.Is(err, ErrUnavailable) || attempt >= r.opts.MaxAttempts {
+				yield("", err)
+				return
+			}
+
+			if opts.Limit > 0 {
+				resume.Limit = opts.Limit - yielded
+			}
+
+			if serr := r.sleep(ctx, r.backoff(attempt)); serr != nil {
+				yield("", err)
+				return
+			}
+		}
+	}
+}
+
+// IterateDelimited resumes after the last entry it yielded when the listing
+// fails part of the way through, the same way as Iterate.
+func (r *Retry) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		resume := opts
+		yielded := 0
+
+		for attempt := 1; ; attempt++ {
+			var err error
+
+			for entry, ierr := range IterateDelimited(ctx, r.underlying, prefix, delimiter, resume) {
+				if ierr != nil {
+					err = ierr
+					break
+				}
+
+				attempt = 1
+				yielded++
+				resume.StartAfter = entry.Name
+
+				if !yield(entry, nil) {
+					return
+				}
+			}
+
+			if err == nil {
+				return
+			}
+
+			if !errors.Is(err, ErrUnavailable) || attempt >= r.opts.MaxAttempts {
+				yield(Entry{}, err)
+				return
+			}
+
+			if opts.Limit > 0 {
+				resume.Limit = opts.Limit - yielded
+			}
+
+			if serr := r.sleep(ctx, r.backoff(attempt)); serr != nil {
+				yield(Entry{}, err)
+				return
+			}
+		}
+	}
+}
+
+func (r *Retry) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	var values map[string][]byte
+	err := r.do(ctx, func(int) (err error) {
+		values, err = GetMany(ctx, r.underlying, keys)
+		return err
+	})
+	return values, err
+}
+
+// SetMany writes every value again on a retry, including the ones that were
+// written the first time.
+func (r *Retry) SetMany(ctx context.Context, values map[string][]byte) error {
+	return r.do(ctx, func(int) error {
+		return SetMany(ctx, r.underlying, values)
+	})
+}
+
+func (r *Retry) DeleteMany(ctx context.Context, keys []string) error {
+	return r.do(ctx, func(int) error {
+		return DeleteMany(ctx, r.underlying, keys)
+	})
+}
+
+// DeletePrefix counts the keys deleted by every attempt.
+func (r *Retry) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted := 0
+	err := r.do(ctx, func(int) error {
+		n, err := DeletePrefix(ctx, r.underlying, prefix)
+		deleted += n
+		return err
+	})
+	return deleted, err
+}
+
+// Copy retries the whole copy, which writes the same value to dst each time.
+func (r *Retry) Copy(ctx context.Context, src, dst string) error {
+	return r.do(ctx, func(int) error {
+		return Copy(ctx, r.underlying, src, dst)
+	})
+}
+
+// Move treats a missing src on a retry as success when dst exists, since an
+// earlier attempt may have moved it before failing.
+func (r *Retry) Move(ctx context.Context, src, dst string) error {
+	return r.do(ctx, func(attempt int) error {
+		err := Move(ctx, r.underlying, src, dst)
+		if attempt > 1 && errors.Is(err, ErrNotFound) && r.underlying.Exists(ctx, dst) == nil {
+			return nil
+		}
+		return err
+	})
+}
+
+// Stat leaves out the token SetIf tags values with, even if the underlying
+// store doesn't.
+func (r *Retry) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := r.do(ctx, func(int) (err error) {
+		info, err = Stat(ctx, r.underlying, key)
+		return err
+	})
+
+	info.Metadata = hideRetryToken(ctx, info.Metadata)
+
+	return info, err
+}
+
+func (r *Retry) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	return r.do(ctx, func(int) error {
+		return SetWithOptions(ctx, r.underlying, key, value, opts)
+	})
+}
+
+// PublicURL isn't retried, since building a URL doesn't talk to the store.
+func (r *Retry) PublicURL(ctx context.Context, key string) (string, error) {
+	return PublicURL(ctx, r.underlying, key)
+}
+
+// PresignedURL isn't retried, since signing a URL doesn't talk to the store.
+func (r *Retry) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return PresignedURL(ctx, r.underlying, key, ttl)
+}
@@ -0,0 +1,457 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyStore is a Memory store whose operations fail a set number of times
+// before they work.
+type flakyStore struct {
+	*Memory
+
+	failures map[string]int // operation name to how many more times it fails
+	err      error          // the error failures wrap, ErrUnavailable by default
+	calls    map[string]int
+
+	// applyFirst makes writes take effect before failing, like a request that
+	// reaches the server but whose response is lost.
+	applyFirst bool
+
+	// iterateFailAfter is how many keys Iterate yields, across every call,
+	// before failing.
+	iterateFailAfter int
+	iterated         int
+}
+
+func newFlakyStore(failures map[string]int) *flakyStore {
+	return &flakyStore{
+		Memory:   NewMemory(),
+		failures: failures,
+		err:      ErrUnavailable,
+		calls:    map[string]int{},
+	}
+}
+
+func (f *flakyStore) fail(op string) error {
+	f.calls[op]++
+	if f.failures[op] > 0 {
+		f.failures[op]--
+		return fmt.Errorf("%w: flaky %s", f.err, op)
+	}
+	return nil
+}
+
+func (f *flakyStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := f.fail("Get"); err != nil {
+		return nil, err
+	}
+	return f.Memory.Get(ctx, key)
+}
+
+func (f *flakyStore) Delete(ctx context.Context, key string) error {
+	if !f.applyFirst {
+		if err := f.fail("Delete"); err != nil {
+			return err
+		}
+	}
+
+	err := f.Memory.Delete(ctx, key)
+	if ferr := f.fail("Delete"); f.applyFirst && ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+func (f *flakyStore) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	if !f.applyFirst {
+		if err := f.fail("SetIf"); err != nil {
+			return "", err
+		}
+	}
+
+	version, err := f.Memory.SetIf(ctx, key, value, cond)
+	if ferr := f.fail("SetIf"); f.applyFirst && ferr != nil {
+		return "", ferr
+	}
+	return version, err
+}
+
+func (f *flakyStore) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	return GetReader(ctx, f.Memory, key)
+}
+
+// SetReader consumes r before failing, so a retry has to rewind it.
+func (f *flakyStore) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	value, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := f.fail("SetReader"); err != nil {
+		return err
+	}
+	return f.Memory.Set(ctx, key, value)
+}
+
+func (f *flakyStore) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for key, err := range Iterate(ctx, f.Memory, prefix, opts) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			if f.iterated >= f.iterateFailAfter {
+				if err := f.fail("Iterate"); err != nil {
+					yield("", err)
+					return
+				}
+			}
+			f.iterated++
+
+			if !yield(key, nil) {
+				return
+			}
+		}
+	}
+}
+
+// newTestRetry wraps s in a Retry that records its delays instead of
+// sleeping.
+func newTestRetry(t *testing.T, s Interface, opts RetryOptions) (*Retry, *[]time.Duration) {
+	t.Helper()
+
+	r, err := NewRetry(s, opts)
+	if err != nil {
+		t.Fatalf("NewRetry() error = %v", err)
+	}
+
+	var delays []time.Duration
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+
+	return r, &delays
+}
+
+func TestRetry_Get(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "no failures",
+			failures:  0,
+			wantCalls: 1,
+		},
+		{
+			name:      "recovers after two failures",
+			failures:  2,
+			wantCalls: 3,
+		},
+		{
+			name:      "gives up after max attempts",
+			failures:  10,
+			wantCalls: 4,
+			wantErr:   ErrUnavailable,
+		},
+		{
+			name:      "permission errors aren't retried",
+			failures:  10,
+			err:       ErrPermission,
+			wantCalls: 1,
+			wantErr:   ErrPermission,
+		},
+		{
+			name:      "missing keys aren't retried",
+			failures:  10,
+			err:       ErrNotFound,
+			wantCalls: 1,
+			wantErr:   ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFlakyStore(map[string]int{"Get": tt.failures})
+			if tt.err != nil {
+				f.err = tt.err
+			}
+			if err := f.Set(ctx, "key", []byte("value")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			r, delays := newTestRetry(t, f, RetryOptions{})
+
+			value, err := r.Get(ctx, "key")
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(value) != "value" {
+				t.Errorf("Get() = %q, want %q", value, "value")
+			}
+
+			if got := f.calls["Get"]; got != tt.wantCalls {
+				t.Errorf("underlying Get calls = %d, want %d", got, tt.wantCalls)
+			}
+			if got := len(*delays); got != tt.wantCalls-1 {
+				t.Errorf("sleeps = %d, want %d", got, tt.wantCalls-1)
+			}
+		})
+	}
+}
+
+func TestRetry_Backoff(t *testing.T) {
+	r, err := NewRetry(NewMemory(), RetryOptions{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	if err != nil {
+		t.Fatalf("NewRetry() error = %v", err)
+	}
+
+	for attempt, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		60: time.Second,
+	} {
+		for range 100 {
+			if got := r.backoff(attempt); got < want/2 || got >= want {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v)", attempt, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestNewRetry_BadConfig(t *testing.T) {
+	for _, opts := range []RetryOptions{
+		{MaxAttempts: -1},
+		{BaseDelay: -time.Second},
+		{MaxDelay: -time.Second},
+	} {
+		if _, err := NewRetry(NewMemory(), opts); !errors.Is(err, ErrBadConfig) {
+			t.Errorf("NewRetry(%+v) error = %v, want %v", opts, err, ErrBadConfig)
+		}
+	}
+}
+
+func TestRetry_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	f := newFlakyStore(map[string]int{"Get": 10})
+	r, _ := newTestRetry(t, f, RetryOptions{})
+
+	if _, err := r.Get(ctx, "key"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Get() error = %v, want %v", err, ErrUnavailable)
+	}
+	if got := f.calls["Get"]; got != 1 {
+		t.Errorf("underlying Get calls = %d, want 1", got)
+	}
+}
+
+func TestRetry_Delete(t *testing.T) {
+	ctx := context.Background()
+
+	f := newFlakyStore(map[string]int{"Delete": 1})
+	f.applyFirst = true
+	if err := f.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	r, _ := newTestRetry(t, f, RetryOptions{})
+
+	if err := r.Delete(ctx, "key"); err != nil {
+		t.Errorf("Delete() error = %v, want the lost delete to count as done", err)
+	}
+
+	if err := r.Delete(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestRetry_SetIf(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		existing []byte
+		wantErr  error
+	}{
+		{
+			name:    "lost write of a new key",
+			wantErr: nil,
+		},
+		{
+			name:     "key was taken by someone else",
+			existing: []byte("someone else"),
+			wantErr:  ErrConflict,
+		},
+		{
+			name:     "key was taken by someone else with the same value",
+			existing: []byte("value"),
+			wantErr:  ErrConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFlakyStore(map[string]int{"SetIf": 1})
+			f.applyFirst = true
+			if tt.existing != nil {
+				if err := f.Memory.Set(ctx, "key", tt.existing); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+
+			r, _ := newTestRetry(t, f, RetryOptions{})
+
+			version, err := r.SetIf(ctx, "key", []byte("value"), Condition{IfNotExists: true})
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("SetIf() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			_, current, err := f.GetVersion(ctx, "key")
+			if err != nil {
+				t.Fatalf("GetVersion() error = %v", err)
+			}
+			if version != current {
+				t.Errorf("SetIf() version = %q, want %q", version, current)
+			}
+
+			if info, err := r.Stat(ctx, "key"); err != nil || info.Metadata != nil {
+				t.Errorf("Stat() = %+v, %v, want the retry token left out", info, err)
+			}
+			if info, err := f.Memory.Stat(ctx, "key"); err != nil || info.Metadata != nil {
+				t.Errorf("underlying Stat() = %+v, %v, want the retry token left out", info, err)
+			}
+
+			dst := NewMemory()
+			if _, err := Sync(ctx, f.Memory, dst, SyncOptions{}); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if md := dst.data["key"].metadata; len(md) != 0 {
+				t.Errorf("Sync() copied metadata %v, want the retry token left behind", md)
+			}
+		})
+	}
+}
+
+func TestRetry_SetReader(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("seekable bodies are rewound", func(t *testing.T) {
+		f := newFlakyStore(map[string]int{"SetReader": 2})
+		r, _ := newTestRetry(t, f, RetryOptions{})
+
+		body := bytes.NewReader([]byte("skip:value"))
+		if _, err := body.Seek(5, io.SeekStart); err != nil {
+			t.Fatalf("Seek() error = %v", err)
+		}
+
+		if err := r.SetReader(ctx, "key", body, StreamInfo{ContentLength: -1}); err != nil {
+			t.Fatalf("SetReader() error = %v", err)
+		}
+
+		got, err := f.Memory.Get(ctx, "key")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if string(got) != "value" {
+			t.Errorf("stored value = %q, want %q", got, "value")
+		}
+	})
+
+	t.Run("other bodies are tried once", func(t *testing.T) {
+		f := newFlakyStore(map[string]int{"SetReader": 1})
+		r, _ := newTestRetry(t, f, RetryOptions{})
+
+		body := io.MultiReader(strings.NewReader("value"))
+		if err := r.SetReader(ctx, "key", body, StreamInfo{ContentLength: -1}); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("SetReader() error = %v, want %v", err, ErrUnavailable)
+		}
+		if got := f.calls["SetReader"]; got != 1 {
+			t.Errorf("underlying SetReader calls = %d, want 1", got)
+		}
+	})
+}
+
+func TestRetry_Iterate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		opts     ListOptions
+		failures int
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "resumes after the last key",
+			failures: 3,
+			want:     []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name:     "keeps the limit",
+			opts:     ListOptions{Limit: 3},
+			failures: 1,
+			want:     []string{"a", "b", "c"},
+		},
+		{
+			name:     "gives up",
+			failures: 10,
+			want:     []string{"a", "b"},
+			wantErr:  ErrUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFlakyStore(map[string]int{"Iterate": tt.failures})
+			f.iterateFailAfter = 2
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				if err := f.Set(ctx, key, []byte(key)); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+
+			r, _ := newTestRetry(t, f, RetryOptions{})
+
+			var (
+				got []string
+				err error
+			)
+			for key, ierr := range r.Iterate(ctx, "", tt.opts) {
+				if ierr != nil {
+					err = ierr
+					break
+				}
+				got = append(got, key)
+			}
+
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Iterate() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Iterate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,106 +3,973 @@ package store
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/aws/smithy-go/logging"
+	"golang.org/x/sync/errgroup"
 )
 
-func NewS3API(ctx context.Context, bucket string) (Interface, error) {
-	cfg, err := awsConfig.LoadDefaultConfig(ctx,
-		awsConfig.WithLogger(logging.Nop{}),
-	)
+// tigrisEndpoint is the S3 endpoint used when neither S3Options nor the
+// environment (AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL) configure one.
+const tigrisEndpoint = "https://t3.storage.dev"
+
+// S3Options configure an S3API store. The zero value talks to Tigris with
+// credentials and region from the environment.
+type S3Options struct {
+	// Endpoint is the URL of the S3 API, eg: http://localhost:9000. Defaults
+	// to the environment's AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL, then to
+	// https://t3.storage.dev.
+	Endpoint string
+
+	// Region is the region requests are signed for. Defaults to the
+	// environment's AWS_REGION.
+	Region string
+
+	// AccessKeyID and SecretAccessKey are static credentials to use instead of
+	// the environment's. Both must be set, or neither.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// UsePathStyle addresses buckets in the path of the URL, eg:
+	// http://localhost:9000/bucket/key, instead of as a virtual host, eg:
+	// https://bucket.t3.storage.dev/key. Tigris needs virtual hosts, most S3
+	// stand-ins need paths.
+	UsePathStyle bool
+
+	// PublicURL is the URL public values are served from, eg:
+	// https://cdn.example.com. Defaults to where the bucket is on Endpoint.
+	PublicURL string
+
+	// HTTPClient sends requests to Endpoint. Defaults to the AWS SDK's client,
+	// which honors AWS_CA_BUNDLE.
+	HTTPClient *http.Client
+
+	// Logger gets the AWS SDK's warnings and debug messages. Defaults to
+	// discarding them.
+	Logger *slog.Logger
+
+	// HideExpired leaves keys that have expired out of listings. ListObjectsV2
+	// doesn't return user metadata, so this costs a HeadObject call for every
+	// listed key. By default expired keys are listed until a bucket lifecycle
+	// rule deletes them, and Get, Exists and Stat treat them as missing.
+	HideExpired bool
+}
+
+// NewS3API creates a store backed by bucket, configured by opts and then the
+// environment. Public URLs point at the bucket on the S3 endpoint, eg:
+// https://bucket.t3.storage.dev/key.
+func NewS3API(ctx context.Context, bucket string, opts S3Options) (*S3API, error) {
+	if (opts.AccessKeyID == "") != (opts.SecretAccessKey == "") {
+		return nil, fmt.Errorf("%w: S3 access key ID and secret access key must be set together", ErrBadConfig)
+	}
+
+	var logger logging.Logger = logging.Nop{}
+	if opts.Logger != nil {
+		logger = slogLogger{opts.Logger}
+	}
+
+	loadOpts := []func(*awsConfig.LoadOptions) error{
+		awsConfig.WithLogger(logger),
+	}
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, awsConfig.WithRegion(opts.Region))
+	}
+	if opts.AccessKeyID != "" {
+		loadOpts = append(loadOpts, awsConfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(opts.AccessKeyID, opts.SecretAccessKey, ""),
+		))
+	}
+
+	cfg, err := awsConfig.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("can't load AWS config from environment: %w", err)
 	}
 
 	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.UsePathStyle = false // Tigris needs vhost style
+		o.UsePathStyle = opts.UsePathStyle
+		if opts.HTTPClient != nil {
+			o.HTTPClient = opts.HTTPClient
+		}
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+		if o.BaseEndpoint == nil {
+			o.BaseEndpoint = aws.String(tigrisEndpoint)
+		}
 	})
 
+	endpoint, err := url.Parse(aws.ToString(client.Options().BaseEndpoint))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: S3 endpoint %q is not an absolute URL", ErrBadConfig, aws.ToString(client.Options().BaseEndpoint))
+	}
+
+	publicURL := &url.URL{
+		Scheme: endpoint.Scheme,
+		Host:   bucket + "." + endpoint.Host,
+		Path:   endpoint.Path,
+	}
+	if opts.UsePathStyle {
+		publicURL = endpoint.JoinPath(bucket)
+	}
+	if opts.PublicURL != "" {
+		publicURL, err = url.Parse(opts.PublicURL)
+		if err != nil || publicURL.Scheme == "" || publicURL.Host == "" {
+			return nil, fmt.Errorf("%w: public URL %q is not an absolute URL", ErrBadConfig, opts.PublicURL)
+		}
+	}
+
 	return &S3API{
-		s3:     client,
-		bucket: bucket,
+		s3:        client,
+		presign:   s3.NewPresignClient(client),
+		bucket:    bucket,
+		publicURL: publicURL,
+
+		hideExpired: opts.HideExpired,
 	}, nil
 }
 
+// slogLogger passes the AWS SDK's log messages on to a slog.Logger.
+type slogLogger struct {
+	lg *slog.Logger
+}
+
+func (l slogLogger) Logf(classification logging.Classification, format string, v ...any) {
+	level := slog.LevelDebug
+	if classification == logging.Warn {
+		level = slog.LevelWarn
+	}
+
+	l.lg.Log(context.Background(), level, fmt.Sprintf(format, v...), "source", "aws-sdk")
+}
+
 type S3API struct {
-	s3     *s3.Client
-	bucket string
+	s3        *s3.Client
+	presign   *s3.PresignClient
+	bucket    string
+	publicURL *url.URL // keys are appended to its path
+
+	hideExpired bool
 }
 
 func (s *S3API) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
 	// Emulate not found by probing first.
 	if _, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &key}); err != nil {
-		return fmt.Errorf("%w: %w", ErrNotFound, err)
+		return s3Error("can't find s3 object", err)
 	}
-	iopsMetrics.WithLabelValues("s3api", "HeadObject")
+
 	if _, err := s.s3.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &key}); err != nil {
-		return fmt.Errorf("can't delete from s3: %w", err)
+		return s3Error("can't delete from s3", err)
 	}
-	iopsMetrics.WithLabelValues("s3api", "DeleteObject")
+
 	return nil
 }
 
 func (s *S3API) Exists(ctx context.Context, key string) error {
-	_, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &key})
-	iopsMetrics.WithLabelValues("s3api", "HeadObject")
-	if err != nil {
+	if err := checkKey(key); err != nil {
 		return fmt.Errorf("%w: %w", ErrNotFound, err)
 	}
+
+	out, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &key})
+	if err != nil {
+		return s3Error("can't find s3 object", err)
+	}
+	if expired(out.Metadata, time.Now()) {
+		return fmt.Errorf("%w: %s has expired", ErrNotFound, key)
+	}
 	return nil
 }
 
 func (s *S3API) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
 	out, err := s.s3.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: &s.bucket,
 		Key:    &key,
 	})
-	iopsMetrics.WithLabelValues("s3api", "GetObject")
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		return nil, s3Error("can't get s3 object", err)
 	}
 	defer out.Body.Close()
 
+	if expired(out.Metadata, time.Now()) {
+		return nil, fmt.Errorf("%w: %s has expired", ErrNotFound, key)
+	}
+
 	b, err := io.ReadAll(out.Body)
 	if err != nil {
-		return nil, fmt.Errorf("can't read s3 object: %w", err)
+		return nil, s3Error("can't read s3 object", err)
 	}
 	return b, nil
 }
 
 func (s *S3API) Set(ctx context.Context, key string, value []byte) error {
-	_, err := s.s3.PutObject(ctx, &s3.PutObjectInput{
+	return s.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL puts a value into the bucket with its expiry recorded in the
+// object's metadata. Expired objects are treated as missing by Get, Exists and
+// Stat, and by listings with S3Options.HideExpired, but are not deleted;
+// configure a bucket lifecycle rule to reclaim them.
+func (s *S3API) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.SetWithOptions(ctx, key, value, SetOptions{TTL: ttl})
+}
+
+// SetWithOptions puts a value into the bucket with opts.ContentType as its
+// Content-Type, opts.Metadata as user metadata and, if opts.Public is set, the
+// public-read ACL. The expiry of values with a TTL is kept in the metadata key
+// glue-expires, so it can't be used for anything else.
+func (s *S3API) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	if _, err := s.s3.PutObject(ctx, s.putObjectInput(key, value, opts)); err != nil {
+		return s3Error("can't put s3 object", err)
+	}
+	return nil
+}
+
+// putObjectInput builds the PutObject request that writes value to key with
+// the attributes in opts.
+func (s *S3API) putObjectInput(key string, value []byte, opts SetOptions) *s3.PutObjectInput {
+	metadata := lowerKeys(opts.Metadata)
+	if opts.TTL > 0 {
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[expiresMetadataKey] = time.Now().Add(opts.TTL).UTC().Format(time.RFC3339Nano)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:   &s.bucket,
+		Key:      &key,
+		Body:     bytes.NewReader(value),
+		Metadata: metadata,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.Public {
+		input.ACL = types.ObjectCannedACLPublicRead
+	}
+
+	return input
+}
+
+// Stat describes an object with HeadObject. Version is the object's ETag.
+func (s *S3API) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := checkKey(key); err != nil {
+		return ObjectInfo{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	out, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &key})
+	if err != nil {
+		return ObjectInfo{}, s3Error("can't find s3 object", err)
+	}
+	if expired(out.Metadata, time.Now()) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s has expired", ErrNotFound, key)
+	}
+
+	info := ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		LastModified: aws.ToTime(out.LastModified),
+		Version:      aws.ToString(out.ETag),
+		ContentType:  aws.ToString(out.ContentType),
+		Metadata:     lowerKeys(out.Metadata),
+	}
+
+	if t, ok := expiresAt(info.Metadata); ok {
+		info.Expires = t
+		delete(info.Metadata, expiresMetadataKey)
+	}
+	info.Metadata = hideRetryToken(ctx, info.Metadata)
+
+	return info, nil
+}
+
+// GetVersion returns an object's contents along with its ETag.
+func (s *S3API) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	if err := checkKey(key); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	out, err := s.s3.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: &s.bucket,
 		Key:    &key,
-		Body:   bytes.NewReader(value),
 	})
-	iopsMetrics.WithLabelValues("s3api", "PutObject")
 	if err != nil {
-		return fmt.Errorf("can't put s3 object: %w", err)
+		return nil, "", s3Error("can't get s3 object", err)
+	}
+	defer out.Body.Close()
+
+	if expired(out.Metadata, time.Now()) {
+		return nil, "", fmt.Errorf("%w: %s has expired", ErrNotFound, key)
+	}
+
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", s3Error("can't read s3 object", err)
+	}
+
+	return b, aws.ToString(out.ETag), nil
+}
+
+// SetIf writes an object with If-None-Match or If-Match headers so that the
+// bucket enforces cond, and returns the new ETag. cond.Options are written the
+// same way as by SetWithOptions.
+func (s *S3API) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
 	}
+
+	input := s.putObjectInput(key, value, cond.Options)
+	if cond.IfNotExists {
+		input.IfNoneMatch = aws.String("*")
+	}
+	if cond.IfVersion != "" {
+		input.IfMatch = aws.String(cond.IfVersion)
+	}
+
+	out, err := s.s3.PutObject(ctx, input)
+	if err != nil && cond.IfNotExists && cond.IfVersion == "" && isConflict(err) {
+		// An expired object still exists as far as the bucket is concerned, so
+		// replace it as long as nobody else has in the meantime.
+		head, headErr := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &key})
+		if headErr == nil && expired(head.Metadata, time.Now()) {
+			return s.SetIf(ctx, key, value, Condition{IfVersion: aws.ToString(head.ETag), Options: cond.Options})
+		}
+	}
+	if err != nil {
+		if isConflict(err) {
+			return "", fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+		return "", s3Error("can't put s3 object", err)
+	}
+
+	return aws.ToString(out.ETag), nil
+}
+
+// isConflict reports whether err is S3 rejecting a conditional write. If-Match
+// on a missing object fails with NoSuchKey instead, which s3Error reports as
+// ErrNotFound.
+func isConflict(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		case "NoSuchKey":
+			return false
+		}
+	}
+
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		switch re.HTTPStatusCode() {
+		case http.StatusPreconditionFailed, http.StatusConflict:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Copy copies an object inside the bucket with CopyObject, so the value never
+// leaves S3. The content type and metadata, including the expiry, are copied,
+// but the copy is always private. CopyObject only copies objects up to 5 GiB.
+func (s *S3API) Copy(ctx context.Context, src, dst string) error {
+	if err := checkKey(src); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if err := checkKey(dst); err != nil {
+		return err
+	}
+
+	// Probe first so expired objects aren't brought back to life, and only
+	// copy the version that was probed.
+	out, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &src})
+	if err != nil {
+		return s3Error("can't find s3 object", err)
+	}
+	if expired(out.Metadata, time.Now()) {
+		return fmt.Errorf("%w: %s has expired", ErrNotFound, src)
+	}
+
+	_, err = s.s3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            &s.bucket,
+		Key:               &dst,
+		CopySource:        aws.String(copySource(s.bucket, src)),
+		CopySourceIfMatch: out.ETag,
+	})
+	if err != nil {
+		return s3Error("can't copy s3 object", err)
+	}
+
 	return nil
 }
 
-func (s *S3API) List(ctx context.Context, prefix string) ([]string, error) {
-	items, err := s.s3.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+// copySource returns the URL encoded bucket/key that CopyObject copies from.
+func copySource(bucket, key string) string {
+	parts := strings.Split(bucket+"/"+key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// PublicURL returns the URL of key under the bucket's public URL.
+func (s *S3API) PublicURL(ctx context.Context, key string) (string, error) {
+	u := *s.publicURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	u.RawPath = ""
+
+	return u.String(), nil
+}
+
+// PresignedURL signs a GetObject request for key that is valid for ttl. S3
+// doesn't accept presigned URLs that last longer than a week.
+func (s *S3API) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", fmt.Errorf("%w: presigned URLs must last longer than 0s, not %s", ErrBadConfig, ttl)
+	}
+
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: &s.bucket,
-		Prefix: aws.String(prefix),
+		Key:    &key,
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", s3Error("can't presign s3 object", err)
+	}
+
+	return req.URL, nil
+}
+
+// maxKeyLength is the longest key S3 accepts, in bytes.
+const maxKeyLength = 1024
+
+// checkKey returns ErrInvalidKey for keys S3 can't store.
+func checkKey(key string) error {
+	if key == "" || len(key) > maxKeyLength {
+		return fmt.Errorf("%w: S3 keys must be 1 to %d bytes long, not %d", ErrInvalidKey, maxKeyLength, len(key))
+	}
+	return nil
+}
+
+// s3Error wraps an error from the S3 API with msg and the store error it
+// corresponds to, if any.
+func s3Error(msg string, err error) error {
+	if kind := classifyS3Error(err); kind != nil {
+		return fmt.Errorf("%w: %s: %w", kind, msg, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// classifyS3Error maps an error from the S3 API to ErrNotFound, ErrPermission,
+// ErrUnavailable or errors.ErrUnsupported, or returns nil if it is none of
+// them. Errors from the caller's context being done are left alone so they
+// aren't retried, and so are server errors that retrying can't fix.
+func classifyS3Error(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return ErrNotFound
+		case "AccessDenied", "Forbidden", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken":
+			return ErrPermission
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequests",
+			"RequestTimeout", "InternalError", "ServiceUnavailable":
+			return ErrUnavailable
+		case "NotImplemented":
+			return errors.ErrUnsupported
+		}
+	}
+
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		switch code := re.HTTPStatusCode(); {
+		case code == http.StatusNotFound:
+			return ErrNotFound
+		case code == http.StatusUnauthorized, code == http.StatusForbidden:
+			return ErrPermission
+		case code == http.StatusNotImplemented:
+			return errors.ErrUnsupported
+		case code == http.StatusHTTPVersionNotSupported:
+			return nil
+		case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+			return ErrUnavailable
+		}
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrUnavailable
+	}
+
+	return nil
+}
+
+// GetReader opens an object for reading without buffering it in memory.
+func (s *S3API) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, StreamInfo{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	out, err := s.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
 	})
-	iopsMetrics.WithLabelValues("s3api", "ListObjectsV2")
 	if err != nil {
-		return nil, fmt.Errorf("can't list items: %w", err)
+		return nil, StreamInfo{}, s3Error("can't get s3 object", err)
+	}
+
+	if expired(out.Metadata, time.Now()) {
+		out.Body.Close()
+		return nil, StreamInfo{}, fmt.Errorf("%w: %s has expired", ErrNotFound, key)
+	}
+
+	info := StreamInfo{
+		ContentLength: aws.ToInt64(out.ContentLength),
+		ContentType:   aws.ToString(out.ContentType),
+	}
+	if out.ContentLength == nil {
+		info.ContentLength = -1
 	}
 
+	return out.Body, info, nil
+}
+
+// SetReader uploads the contents of r. Bodies that fit in a single part are
+// sent with PutObject, anything larger is sent as a multipart upload so that
+// at most one part is held in memory at a time.
+func (s *S3API) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	var contentType *string
+	if info.ContentType != "" {
+		contentType = aws.String(info.ContentType)
+	}
+
+	// Grow the first part as it's read rather than allocating a whole part up
+	// front, so small values of unknown length stay small.
+	var first bytes.Buffer
+	if info.ContentLength >= 0 {
+		first.Grow(int(min(info.ContentLength, multipartPartSize)))
+	}
+	if _, err := first.ReadFrom(io.LimitReader(r, multipartPartSize)); err != nil {
+		return fmt.Errorf("can't read value for %s: %w", key, err)
+	}
+
+	if first.Len() < multipartPartSize {
+		_, err := s.s3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      &s.bucket,
+			Key:         &key,
+			Body:        bytes.NewReader(first.Bytes()),
+			ContentType: contentType,
+		})
+		if err != nil {
+			return s3Error("can't put s3 object", err)
+		}
+		return nil
+	}
+
+	upload, err := s.s3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      &s.bucket,
+		Key:         &key,
+		ContentType: contentType,
+	})
+	if err != nil {
+		return s3Error("can't start multipart upload", err)
+	}
+
+	if err := s.uploadParts(ctx, key, upload.UploadId, r, first.Bytes()); err != nil {
+		// Use a fresh context so the upload is cleaned up even if ctx was
+		// canceled.
+		_, abortErr := s.s3.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   &s.bucket,
+			Key:      &key,
+			UploadId: upload.UploadId,
+		})
+		return errors.Join(err, abortErr)
+	}
+
+	return nil
+}
+
+// uploadParts sends the already-filled first part in buf followed by the rest
+// of r, then completes the multipart upload. buf is reused for each part, so
+// it has to be a whole part long.
+func (s *S3API) uploadParts(ctx context.Context, key string, uploadID *string, r io.Reader, buf []byte) error {
+	var parts []types.CompletedPart
+	n := len(buf)
+
+	for partNumber := int32(1); ; partNumber++ {
+		out, err := s.s3.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     &s.bucket,
+			Key:        &key,
+			UploadId:   uploadID,
+			PartNumber: aws.Int32(partNumber),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return s3Error(fmt.Sprintf("can't upload part %d", partNumber), err)
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       out.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+
+		n, err = io.ReadFull(r, buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("can't read value for %s: %w", key, err)
+		}
+	}
+
+	_, err := s.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.bucket,
+		Key:             &key,
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return s3Error("can't complete multipart upload", err)
+	}
+
+	return nil
+}
+
+func (s *S3API) List(ctx context.Context, prefix string) ([]string, error) {
 	var result []string
 
-	for _, item := range items.Contents {
-		result = append(result, *item.Key)
+	for key, err := range s.Iterate(ctx, prefix, ListOptions{}) {
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, key)
 	}
 
 	return result, nil
 }
+
+// Iterate streams the keys matching prefix in lexicographic order, following
+// ListObjectsV2 continuation tokens one page at a time.
+func (s *S3API) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		input := &s3.ListObjectsV2Input{
+			Bucket: &s.bucket,
+			Prefix: aws.String(prefix),
+		}
+		if opts.StartAfter != "" {
+			input.StartAfter = aws.String(opts.StartAfter)
+		}
+		if opts.Limit > 0 && opts.Limit < listPageSize {
+			input.MaxKeys = aws.Int32(int32(opts.Limit))
+		}
+
+		count := 0
+		pages := s3.NewListObjectsV2Paginator(s.s3, input)
+
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				yield("", s3Error("can't list items", err))
+				return
+			}
+
+			keys, err := s.liveKeys(ctx, page.Contents)
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+
+				count++
+				if opts.Limit > 0 && count >= opts.Limit {
+					return
+				}
+			}
+		}
+	}
+}
+
+// IterateDelimited lists one level under prefix with the ListObjectsV2
+// delimiter, so the keys nested under each common prefix are never fetched.
+// With S3Options.HideExpired expired keys are left out, but a common prefix is
+// listed even if every key under it has expired.
+func (s *S3API) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		input := &s3.ListObjectsV2Input{
+			Bucket: &s.bucket,
+			Prefix: aws.String(prefix),
+		}
+		if delimiter != "" {
+			input.Delimiter = aws.String(delimiter)
+		}
+		if opts.StartAfter != "" {
+			input.StartAfter = aws.String(opts.StartAfter)
+		}
+		if opts.Limit > 0 && opts.Limit < listPageSize {
+			input.MaxKeys = aws.Int32(int32(opts.Limit))
+		}
+
+		count := 0
+		pages := s3.NewListObjectsV2Paginator(s.s3, input)
+
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				yield(Entry{}, s3Error("can't list items", err))
+				return
+			}
+
+			keys, err := s.liveKeys(ctx, page.Contents)
+			if err != nil {
+				yield(Entry{}, err)
+				return
+			}
+
+			entries := make([]Entry, 0, len(keys)+len(page.CommonPrefixes))
+			for _, key := range keys {
+				entries = append(entries, Entry{Name: key})
+			}
+			for _, p := range page.CommonPrefixes {
+				entries = append(entries, Entry{Name: *p.Prefix, IsPrefix: true})
+			}
+			slices.SortFunc(entries, func(a, b Entry) int {
+				return strings.Compare(a.Name, b.Name)
+			})
+
+			for _, entry := range entries {
+				// S3 lists the common prefix StartAfter is in, which a
+				// resumed listing has already seen.
+				if entry.Name <= opts.StartAfter {
+					continue
+				}
+
+				if !yield(entry, nil) {
+					return
+				}
+
+				count++
+				if opts.Limit > 0 && count >= opts.Limit {
+					return
+				}
+			}
+		}
+	}
+}
+
+// liveKeys returns the keys of the listed objects, leaving out the ones that
+// have expired if hideExpired is set. ListObjectsV2 doesn't return user
+// metadata, so every key has to be probed to find out if it has expired.
+func (s *S3API) liveKeys(ctx context.Context, objects []types.Object) ([]string, error) {
+	result := make([]string, len(objects))
+	if !s.hideExpired {
+		for i, item := range objects {
+			result[i] = *item.Key
+		}
+		return result, nil
+	}
+
+	live := make([]bool, len(objects))
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(expiryProbeConcurrency)
+
+	for i, item := range objects {
+		result[i] = *item.Key
+		g.Go(func() error {
+			switch err := s.Exists(gCtx, *item.Key); {
+			case err == nil:
+				live[i] = true
+			case errors.Is(err, ErrNotFound):
+			default:
+				return err
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, fmt.Errorf("can't check item expiry: %w", err)
+	}
+
+	n := 0
+	for i, key := range result {
+		if live[i] {
+			result[n] = key
+			n++
+		}
+	}
+
+	return result[:n], nil
+}
+
+// GetMany reads the keys batchConcurrency at a time.
+func (s *S3API) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return getEach(ctx, s, keys)
+}
+
+// SetMany writes the values batchConcurrency at a time.
+func (s *S3API) SetMany(ctx context.Context, values map[string][]byte) error {
+	return setEach(ctx, s, values)
+}
+
+// DeleteMany deletes keys with DeleteObjects, up to deleteObjectsMaxKeys at a
+// time.
+func (s *S3API) DeleteMany(ctx context.Context, keys []string) error {
+	_, err := s.deleteObjects(ctx, keys)
+	return err
+}
+
+// DeletePrefix deletes every object matching prefix a page of listings at a
+// time, including ones that have expired.
+func (s *S3API) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted := 0
+	pages := s3.NewListObjectsV2Paginator(s.s3, &s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: aws.String(prefix),
+	})
+
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return deleted, s3Error("can't list items", err)
+		}
+
+		keys := make([]string, len(page.Contents))
+		for i, item := range page.Contents {
+			keys[i] = aws.ToString(item.Key)
+		}
+
+		n, err := s.deleteObjects(ctx, keys)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// deleteObjects deletes keys with as few DeleteObjects calls as it can and
+// returns how many it deleted. S3 doesn't say whether a key existed, so keys
+// that didn't are counted too.
+func (s *S3API) deleteObjects(ctx context.Context, keys []string) (int, error) {
+	var (
+		deleted int
+		errs    []error
+	)
+
+	for chunk := range slices.Chunk(keys, deleteObjectsMaxKeys) {
+		objects := make([]types.ObjectIdentifier, len(chunk))
+		for i, key := range chunk {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		out, err := s.s3.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &s.bucket,
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			errs = append(errs, s3Error("can't delete objects", err))
+			return deleted, errors.Join(errs...)
+		}
+
+		deleted += len(chunk) - len(out.Errors)
+		for _, e := range out.Errors {
+			errs = append(errs, s3Error(
+				fmt.Sprintf("can't delete %s", aws.ToString(e.Key)),
+				&smithy.GenericAPIError{Code: aws.ToString(e.Code), Message: aws.ToString(e.Message)},
+			))
+		}
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+const (
+	// deleteObjectsMaxKeys is the most keys DeleteObjects accepts in one call.
+	deleteObjectsMaxKeys = 1000
+
+	// expiresMetadataKey is the object metadata key that holds the RFC 3339
+	// timestamp a value written with SetWithTTL expires at.
+	expiresMetadataKey = "glue-expires"
+
+	// expiryProbeConcurrency is the number of HeadObject calls listings make
+	// at once while filtering expired values.
+	expiryProbeConcurrency = 16
+
+	// listPageSize is the most keys ListObjectsV2 returns in one page.
+	listPageSize = 1000
+
+	// multipartPartSize is the size of each part SetReader uploads. Bodies
+	// smaller than this are sent with a single PutObject call.
+	multipartPartSize = 8 << 20
+)
+
+// expired reports whether object metadata carries an expiry that is at or
+// before now.
+func expired(metadata map[string]string, now time.Time) bool {
+	t, ok := expiresAt(metadata)
+	return ok && !now.Before(t)
+}
+
+// expiresAt returns the expiry recorded in object metadata, if it has one
+// that parses.
+func expiresAt(metadata map[string]string) (time.Time, bool) {
+	for k, v := range metadata {
+		if !strings.EqualFold(k, expiresMetadataKey) {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		return t, true
+	}
+
+	return time.Time{}, false
+}
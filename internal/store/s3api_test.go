@@ -0,0 +1,704 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/tigrisdata-community/glue/internal/store/s3test"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     bool
+	}{
+		{
+			name:     "no metadata",
+			metadata: nil,
+			want:     false,
+		},
+		{
+			name:     "expiry in the future",
+			metadata: map[string]string{expiresMetadataKey: now.Add(time.Second).Format(time.RFC3339Nano)},
+			want:     false,
+		},
+		{
+			name:     "expiry in the past",
+			metadata: map[string]string{expiresMetadataKey: now.Add(-time.Second).Format(time.RFC3339Nano)},
+			want:     true,
+		},
+		{
+			name:     "expiry is now",
+			metadata: map[string]string{expiresMetadataKey: now.Format(time.RFC3339Nano)},
+			want:     true,
+		},
+		{
+			name:     "metadata key case is ignored",
+			metadata: map[string]string{"Glue-Expires": now.Add(-time.Second).Format(time.RFC3339Nano)},
+			want:     true,
+		},
+		{
+			name:     "unparseable expiry never expires",
+			metadata: map[string]string{expiresMetadataKey: "tomorrow"},
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expired(tt.metadata, now); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func responseError(status int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      errors.New(http.StatusText(status)),
+		},
+	}
+}
+
+func TestClassifyS3Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "no such key",
+			err:  &smithy.OperationError{OperationName: "GetObject", Err: &types.NoSuchKey{}},
+			want: ErrNotFound,
+		},
+		{
+			name: "head of missing object",
+			err:  &smithy.GenericAPIError{Code: "NotFound"},
+			want: ErrNotFound,
+		},
+		{
+			name: "404 without a code",
+			err:  responseError(http.StatusNotFound),
+			want: ErrNotFound,
+		},
+		{
+			name: "access denied",
+			err:  &smithy.GenericAPIError{Code: "AccessDenied"},
+			want: ErrPermission,
+		},
+		{
+			name: "403 without a code",
+			err:  responseError(http.StatusForbidden),
+			want: ErrPermission,
+		},
+		{
+			name: "throttled",
+			err:  &smithy.GenericAPIError{Code: "SlowDown"},
+			want: ErrUnavailable,
+		},
+		{
+			name: "429",
+			err:  responseError(http.StatusTooManyRequests),
+			want: ErrUnavailable,
+		},
+		{
+			name: "503",
+			err:  responseError(http.StatusServiceUnavailable),
+			want: ErrUnavailable,
+		},
+		{
+			name: "not implemented",
+			err:  &smithy.GenericAPIError{Code: "NotImplemented"},
+			want: errors.ErrUnsupported,
+		},
+		{
+			name: "501 without a code",
+			err:  responseError(http.StatusNotImplemented),
+			want: errors.ErrUnsupported,
+		},
+		{
+			name: "505",
+			err:  responseError(http.StatusHTTPVersionNotSupported),
+			want: nil,
+		},
+		{
+			name: "network timeout",
+			err:  &smithy.OperationError{OperationName: "HeadObject", Err: timeoutError{}},
+			want: ErrUnavailable,
+		},
+		{
+			name: "caller canceled",
+			err:  &smithy.OperationError{OperationName: "GetObject", Err: context.Canceled},
+			want: nil,
+		},
+		{
+			name: "missing bucket",
+			err:  &smithy.GenericAPIError{Code: "NoSuchBucket"},
+			want: nil,
+		},
+		{
+			name: "400",
+			err:  responseError(http.StatusBadRequest),
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyS3Error(tt.err); got != tt.want {
+				t.Errorf("classifyS3Error() = %v, want %v", got, tt.want)
+			}
+
+			err := s3Error("can't get s3 object", tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("s3Error() = %v, doesn't wrap %v", err, tt.err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("s3Error() = %v, want it to wrap %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3API_URLs(t *testing.T) {
+	ctx := context.Background()
+
+	client := s3.New(s3.Options{
+		Region:       "auto",
+		BaseEndpoint: aws.String("https://storage.example"),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, nil
+		}),
+	})
+
+	s := &S3API{
+		s3:        client,
+		presign:   s3.NewPresignClient(client),
+		bucket:    "bucket",
+		publicURL: &url.URL{Scheme: "https", Host: "bucket.storage.example"},
+	}
+
+	got, err := s.PublicURL(ctx, "avatars/a b.webp")
+	if err != nil {
+		t.Fatalf("PublicURL() error = %v", err)
+	}
+	if want := "https://bucket.storage.example/avatars/a%20b.webp"; got != want {
+		t.Errorf("PublicURL() = %q, want %q", got, want)
+	}
+
+	got, err = s.PresignedURL(ctx, "avatars/a.webp", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("PresignedURL() error = %v", err)
+	}
+
+	u, err := url.Parse(got)
+	if err != nil {
+		t.Fatalf("PresignedURL() = %q, which doesn't parse: %v", got, err)
+	}
+	if u.Host != "bucket.storage.example" || u.Path != "/avatars/a.webp" {
+		t.Errorf("PresignedURL() = %q, want it to point at bucket.storage.example/avatars/a.webp", got)
+	}
+	if expires := u.Query().Get("X-Amz-Expires"); expires != "900" {
+		t.Errorf("PresignedURL() expires after %qs, want 900s", expires)
+	}
+	if u.Query().Get("X-Amz-Signature") == "" {
+		t.Errorf("PresignedURL() = %q, want it to be signed", got)
+	}
+
+	if _, err := s.PresignedURL(ctx, "avatars/a.webp", 0); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("PresignedURL() with no ttl error = %v, want %v", err, ErrBadConfig)
+	}
+}
+
+func TestNewS3API(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "")
+	t.Setenv("AWS_REGION", "")
+
+	httpClient := &http.Client{}
+
+	tests := []struct {
+		name          string
+		opts          S3Options
+		wantPublicURL string
+		wantRegion    string
+		wantErr       error
+	}{
+		{
+			name:          "tigris",
+			wantPublicURL: "https://bucket.t3.storage.dev/a",
+		},
+		{
+			name: "path style",
+			opts: S3Options{
+				Endpoint:        "http://localhost:9000",
+				Region:          "us-east-1",
+				AccessKeyID:     "id",
+				SecretAccessKey: "secret",
+				UsePathStyle:    true,
+				HTTPClient:      httpClient,
+			},
+			wantPublicURL: "http://localhost:9000/bucket/a",
+			wantRegion:    "us-east-1",
+		},
+		{
+			name:          "public URL",
+			opts:          S3Options{PublicURL: "https://cdn.example/assets"},
+			wantPublicURL: "https://cdn.example/assets/a",
+		},
+		{
+			name:    "relative public URL",
+			opts:    S3Options{PublicURL: "/assets"},
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "relative endpoint",
+			opts:    S3Options{Endpoint: "localhost:9000"},
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "access key without secret",
+			opts:    S3Options{AccessKeyID: "id"},
+			wantErr: ErrBadConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewS3API(ctx, "bucket", tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewS3API() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := s.PublicURL(ctx, "a")
+			if err != nil {
+				t.Fatalf("PublicURL() error = %v", err)
+			}
+			if got != tt.wantPublicURL {
+				t.Errorf("PublicURL() = %q, want %q", got, tt.wantPublicURL)
+			}
+
+			o := s.s3.Options()
+			if o.Region != tt.wantRegion {
+				t.Errorf("region = %q, want %q", o.Region, tt.wantRegion)
+			}
+			if o.UsePathStyle != tt.opts.UsePathStyle {
+				t.Errorf("UsePathStyle = %v, want %v", o.UsePathStyle, tt.opts.UsePathStyle)
+			}
+			if tt.opts.HTTPClient != nil && o.HTTPClient != tt.opts.HTTPClient {
+				t.Errorf("HTTP client = %v, want %v", o.HTTPClient, tt.opts.HTTPClient)
+			}
+
+			if tt.opts.AccessKeyID != "" {
+				creds, err := o.Credentials.Retrieve(ctx)
+				if err != nil {
+					t.Fatalf("Credentials.Retrieve() error = %v", err)
+				}
+				if creds.AccessKeyID != tt.opts.AccessKeyID || creds.SecretAccessKey != tt.opts.SecretAccessKey {
+					t.Errorf("credentials = %s/%s, want %s/%s", creds.AccessKeyID, creds.SecretAccessKey, tt.opts.AccessKeyID, tt.opts.SecretAccessKey)
+				}
+			}
+		})
+	}
+}
+
+// newTestS3API returns an S3API for an empty bucket on an s3test server.
+func newTestS3API(t *testing.T) (*S3API, *s3test.Server) {
+	t.Helper()
+
+	srv := s3test.NewServer(t, "bucket")
+
+	s, err := NewS3API(context.Background(), "bucket", S3Options{
+		Endpoint:        srv.URL,
+		Region:          s3test.Region,
+		AccessKeyID:     s3test.AccessKeyID,
+		SecretAccessKey: s3test.SecretAccessKey,
+		UsePathStyle:    true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3API() error = %v", err)
+	}
+
+	// Turn off the SDK's own retries so that failures injected with srv.Fail
+	// reach the store.
+	s.s3 = s3.New(s.s3.Options(), func(o *s3.Options) { o.Retryer = aws.NopRetryer{} })
+	s.presign = s3.NewPresignClient(s.s3)
+
+	return s, srv
+}
+
+func TestS3API(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newTestS3API(t)
+
+	if err := s.Exists(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Delete(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() error = %v, want %v", err, ErrNotFound)
+	}
+
+	long := strings.Repeat("k", maxKeyLength+1)
+	if err := s.Set(ctx, long, []byte("1")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Set() of a %d byte key error = %v, want %v", len(long), err, ErrInvalidKey)
+	}
+	if _, err := s.Get(ctx, long); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a %d byte key error = %v, want %v", len(long), err, ErrNotFound)
+	}
+
+	for _, k := range []string{"foo/a", "foo/b", "bar/a", "foo/with space"} {
+		if err := s.Set(ctx, k, []byte(k)); err != nil {
+			t.Fatalf("Set(%q) error = %v", k, err)
+		}
+	}
+
+	got, err := s.Get(ctx, "foo/with space")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "foo/with space" {
+		t.Errorf("Get() = %q, want %q", got, "foo/with space")
+	}
+
+	keys, err := s.List(ctx, "foo/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"foo/a", "foo/b", "foo/with space"}; !slices.Equal(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+
+	if err := s.Delete(ctx, "foo/a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if want := []string{"bar/a", "foo/b", "foo/with space"}; !slices.Equal(srv.Keys("bucket"), want) {
+		t.Errorf("bucket holds %v after Delete(), want %v", srv.Keys("bucket"), want)
+	}
+}
+
+func TestS3API_TTL(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newTestS3API(t)
+
+	if err := s.SetWithTTL(ctx, "gone", []byte("1"), time.Millisecond); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	if err := s.SetWithTTL(ctx, "kept", []byte("2"), time.Hour); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := s.Get(ctx, "gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of an expired key error = %v, want %v", err, ErrNotFound)
+	}
+	// Listings only probe for expired keys when asked to.
+	if keys, err := s.List(ctx, ""); err != nil || !slices.Equal(keys, []string{"gone", "kept"}) {
+		t.Errorf("List() = %v, %v, want [gone kept]", keys, err)
+	}
+	s.hideExpired = true
+	if keys, err := s.List(ctx, ""); err != nil || !slices.Equal(keys, []string{"kept"}) {
+		t.Errorf("List() with HideExpired = %v, %v, want [kept]", keys, err)
+	}
+
+	// The expired object is still in the bucket, so creating the key has to
+	// replace it.
+	if _, ok := srv.Object("bucket", "gone"); !ok {
+		t.Fatal("expired object was removed from the bucket")
+	}
+	if err := Create(ctx, s, "gone", []byte("3")); err != nil {
+		t.Errorf("Create() over an expired key error = %v", err)
+	}
+}
+
+func TestS3API_Iterate(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newTestS3API(t)
+	srv.SetMaxKeys(2)
+
+	for _, k := range []string{"a", "b", "c", "d", "e", "other"} {
+		if err := s.Set(ctx, "page/"+k, []byte(k)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{
+			name: "every page",
+			want: []string{"page/a", "page/b", "page/c", "page/d", "page/e", "page/other"},
+		},
+		{
+			name: "start after",
+			opts: ListOptions{StartAfter: "page/c"},
+			want: []string{"page/d", "page/e", "page/other"},
+		},
+		{
+			name: "limit across pages",
+			opts: ListOptions{StartAfter: "page/a", Limit: 3},
+			want: []string{"page/b", "page/c", "page/d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for key, err := range s.Iterate(ctx, "page/", tt.opts) {
+				if err != nil {
+					t.Fatalf("Iterate() error = %v", err)
+				}
+				got = append(got, key)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Iterate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3API_Stream(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newTestS3API(t)
+
+	tests := []struct {
+		name     string
+		size     int
+		wantETag string // suffix of the ETag, multipart uploads end in -<parts>
+	}{
+		{name: "single part", size: 1024, wantETag: `"`},
+		{name: "one whole part", size: multipartPartSize, wantETag: `-1"`},
+		{name: "multipart", size: multipartPartSize + 1024, wantETag: `-2"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := bytes.Repeat([]byte("x"), tt.size)
+
+			if err := s.SetReader(ctx, tt.name, bytes.NewReader(value), StreamInfo{ContentLength: -1, ContentType: "text/plain"}); err != nil {
+				t.Fatalf("SetReader() error = %v", err)
+			}
+
+			obj, ok := srv.Object("bucket", tt.name)
+			if !ok {
+				t.Fatal("SetReader() didn't write the object")
+			}
+			if !strings.HasSuffix(obj.ETag, tt.wantETag) {
+				t.Errorf("object ETag = %s, want it to end in %s", obj.ETag, tt.wantETag)
+			}
+
+			rc, info, err := s.GetReader(ctx, tt.name)
+			if err != nil {
+				t.Fatalf("GetReader() error = %v", err)
+			}
+			defer rc.Close()
+
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("reading GetReader() body error = %v", err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Errorf("GetReader() read %d bytes, want the %d written", len(got), len(value))
+			}
+			if info.ContentType != "text/plain" || info.ContentLength != int64(tt.size) {
+				t.Errorf("GetReader() info = %+v, want text/plain and %d bytes", info, tt.size)
+			}
+		})
+	}
+}
+
+func TestS3API_Batch(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newTestS3API(t)
+	srv.SetMaxKeys(2)
+
+	values := map[string][]byte{
+		"a/1": []byte("1"),
+		"a/2": []byte("2"),
+		"a/3": []byte("3"),
+		"b/1": []byte("4"),
+	}
+	if err := s.SetMany(ctx, values); err != nil {
+		t.Fatalf("SetMany() error = %v", err)
+	}
+
+	got, err := s.GetMany(ctx, []string{"a/1", "b/1", "missing"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if len(got) != 2 || string(got["a/1"]) != "1" || string(got["b/1"]) != "4" {
+		t.Errorf("GetMany() = %q, want a/1 and b/1", got)
+	}
+
+	if err := s.DeleteMany(ctx, []string{"b/1", "missing"}); err != nil {
+		t.Fatalf("DeleteMany() error = %v", err)
+	}
+
+	deleted, err := s.DeletePrefix(ctx, "a/")
+	if err != nil {
+		t.Fatalf("DeletePrefix() error = %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("DeletePrefix() deleted %d keys, want 3", deleted)
+	}
+	if keys := srv.Keys("bucket"); len(keys) != 0 {
+		t.Errorf("bucket holds %v, want nothing", keys)
+	}
+}
+
+func TestS3API_Stat(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestS3API(t)
+
+	err := s.SetWithOptions(ctx, "a", []byte("hello"), SetOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Source": "feed"},
+		TTL:         time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("SetWithOptions() error = %v", err)
+	}
+
+	info, err := s.Stat(ctx, "a")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Key != "a" || info.Size != 5 || info.ContentType != "text/plain" {
+		t.Errorf("Stat() = %+v, want a, 5 bytes of text/plain", info)
+	}
+	if !maps.Equal(info.Metadata, map[string]string{"source": "feed"}) {
+		t.Errorf("Stat() metadata = %v, want map[source:feed]", info.Metadata)
+	}
+	if info.Version == "" || info.LastModified.IsZero() {
+		t.Errorf("Stat() = %+v, want a version and modification time", info)
+	}
+	if until := time.Until(info.Expires); until <= 0 || until > time.Hour {
+		t.Errorf("Stat() expires in %s, want within an hour", until)
+	}
+
+	if _, err := s.Stat(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestS3API_PublicValues(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newTestS3API(t)
+
+	if err := s.SetWithOptions(ctx, "public.webp", []byte("image"), SetOptions{ContentType: "image/webp", Public: true}); err != nil {
+		t.Fatalf("SetWithOptions() error = %v", err)
+	}
+	if err := s.Set(ctx, "private", []byte("secret")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if obj, _ := srv.Object("bucket", "public.webp"); obj.ACL != "public-read" {
+		t.Errorf("public object ACL = %q, want public-read", obj.ACL)
+	}
+
+	get := func(key string, link func(key string) (string, error)) (int, string) {
+		t.Helper()
+
+		u, err := link(key)
+		if err != nil {
+			t.Fatalf("making a URL for %s: %v", key, err)
+		}
+
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("http.Get(%s) error = %v", u, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	public := func(key string) (string, error) { return s.PublicURL(ctx, key) }
+	presigned := func(key string) (string, error) { return s.PresignedURL(ctx, key, time.Minute) }
+
+	if status, body := get("public.webp", public); status != http.StatusOK || body != "image" {
+		t.Errorf("public URL of a public value = %d %q, want 200 %q", status, body, "image")
+	}
+	if status, _ := get("private", public); status != http.StatusForbidden {
+		t.Errorf("public URL of a private value = %d, want 403", status)
+	}
+	if status, body := get("private", presigned); status != http.StatusOK || body != "secret" {
+		t.Errorf("presigned URL of a private value = %d %q, want 200 %q", status, body, "secret")
+	}
+}
+
+func TestS3API_Errors(t *testing.T) {
+	ctx := context.Background()
+	s, srv := newTestS3API(t)
+
+	if err := s.Set(ctx, "a", []byte("1")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	srv.Fail(1, http.StatusServiceUnavailable, "SlowDown")
+	if _, err := s.Get(ctx, "a"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Get() while throttled error = %v, want %v", err, ErrUnavailable)
+	}
+
+	srv.Fail(1, http.StatusForbidden, "AccessDenied")
+	if err := s.Set(ctx, "a", []byte("2")); !errors.Is(err, ErrPermission) {
+		t.Errorf("Set() without access error = %v, want %v", err, ErrPermission)
+	}
+
+	// Retrying through a brief outage succeeds.
+	r, _ := newTestRetry(t, s, RetryOptions{})
+	srv.Fail(2, http.StatusInternalServerError, "InternalError")
+	if got, err := r.Get(ctx, "a"); err != nil || string(got) != "1" {
+		t.Errorf("Retry.Get() = %q, %v, want %q", got, err, "1")
+	}
+
+	wrong, err := NewS3API(ctx, "bucket", S3Options{
+		Endpoint:        srv.URL,
+		Region:          s3test.Region,
+		AccessKeyID:     "someone-else",
+		SecretAccessKey: "nope",
+		UsePathStyle:    true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3API() error = %v", err)
+	}
+	if _, err := wrong.Get(ctx, "a"); !errors.Is(err, ErrPermission) {
+		t.Errorf("Get() with unknown credentials error = %v, want %v", err, ErrPermission)
+	}
+}
@@ -0,0 +1,818 @@
+// Package s3test runs an in-memory stand-in for the parts of the S3 API that
+// store.S3API uses, so that it and the commands built on it can be tested
+// without a real bucket.
+//
+// The server only understands path-style requests, eg: /bucket/key. It checks
+// that requests are made with AccessKeyID or are presigned and unexpired, but
+// doesn't verify signatures. Objects written with the public-read ACL can be
+// read without credentials.
+package s3test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The credentials and region clients must use to talk to a Server.
+const (
+	AccessKeyID     = "s3test"
+	SecretAccessKey = "s3test-secret"
+	Region          = "us-east-1"
+)
+
+// defaultMaxKeys is the most keys ListObjectsV2 returns per page, like S3.
+const defaultMaxKeys = 1000
+
+// Object is a value stored in a Server.
+type Object struct {
+	Body         []byte
+	ContentType  string
+	Metadata     map[string]string // keys are lowercase
+	ACL          string            // canned ACL, eg: private or public-read
+	ETag         string            // quoted, like S3 returns it
+	LastModified time.Time
+}
+
+// Server is an in-memory S3 API served over HTTP.
+type Server struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	buckets  map[string]map[string]Object
+	uploads  map[string]*upload
+	nextID   int
+	maxKeys  int
+	failures []failure
+}
+
+type upload struct {
+	bucket, key string
+	object      Object
+	parts       map[int][]byte
+}
+
+type failure struct {
+	status int
+	code   string
+}
+
+// NewServer starts a Server with the given empty buckets. It is closed when
+// the test finishes.
+func NewServer(t testing.TB, buckets ...string) *Server {
+	t.Helper()
+
+	s := &Server{
+		buckets: map[string]map[string]Object{},
+		uploads: map[string]*upload{},
+		maxKeys: defaultMaxKeys,
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = map[string]Object{}
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Object returns a copy of the object stored at key in bucket.
+func (s *Server) Object(bucket, key string) (Object, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return Object{}, false
+	}
+
+	obj.Body = bytes.Clone(obj.Body)
+	obj.Metadata = maps.Clone(obj.Metadata)
+	return obj, true
+}
+
+// Keys returns the keys stored in bucket in lexicographic order.
+func (s *Server) Keys(bucket string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return slices.Sorted(maps.Keys(s.buckets[bucket]))
+}
+
+// SetMaxKeys caps how many keys ListObjectsV2 returns per page, so that tests
+// can page through a few keys instead of thousands.
+func (s *Server) SetMaxKeys(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxKeys = n
+}
+
+// Fail makes the next n requests fail with the given HTTP status and S3 error
+// code, eg: Fail(1, http.StatusServiceUnavailable, "SlowDown").
+func (s *Server) Fail(n int, status int, code string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for range n {
+		s.failures = append(s.failures, failure{status: status, code: code})
+	}
+}
+
+// apiError is an S3 error response.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errAccessDenied       = &apiError{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errInvalidAccessKeyID = &apiError{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist in our records."}
+	errNoSuchBucket       = &apiError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey          = &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errNoSuchUpload       = &apiError{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist."}
+	errPreconditionFailed = &apiError{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+	errNotImplemented     = &apiError{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented"}
+	errMalformedXML       = &apiError{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed"}
+	errInvalidPart        = &apiError{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+)
+
+func writeError(w http.ResponseWriter, r *http.Request, err *apiError) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(err.status)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: err.code, Message: err.message, Resource: r.URL.Path})
+}
+
+func writeXML(w io.Writer, v any) {
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-amz-request-id", strconv.FormatInt(time.Now().UnixNano(), 36))
+
+	if err := s.handle(w, r); err != nil {
+		var ae *apiError
+		if !errors.As(err, &ae) {
+			ae = &apiError{http.StatusInternalServerError, "InternalError", err.Error()}
+		}
+		writeError(w, r, ae)
+	}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) error {
+	if err := s.injectedFailure(); err != nil {
+		return err
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+
+	signed, err := authenticate(r)
+	if err != nil {
+		return err
+	}
+	if !signed && !s.isPublicRead(r, bucket, key) {
+		return errAccessDenied
+	}
+
+	s.lock.Lock()
+	_, ok := s.buckets[bucket]
+	s.lock.Unlock()
+	if !ok {
+		return errNoSuchBucket
+	}
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodGet && q.Get("list-type") == "2":
+			return s.listObjectsV2(w, r, bucket)
+		case r.Method == http.MethodPost && q.Has("delete"):
+			return s.deleteObjects(w, r, bucket)
+		}
+		return errNotImplemented
+	}
+
+	switch {
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		return s.uploadPart(w, r, bucket, key)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		return s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		return s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		return s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		return s.abortMultipartUpload(w, r)
+	case r.Method == http.MethodDelete:
+		return s.deleteObject(w, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		return s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		return s.completeMultipartUpload(w, r, bucket, key)
+	}
+
+	return errNotImplemented
+}
+
+func (s *Server) injectedFailure() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.failures) == 0 {
+		return nil
+	}
+
+	f := s.failures[0]
+	s.failures = s.failures[1:]
+	return &apiError{f.status, f.code, "injected failure"}
+}
+
+// authenticate reports whether r is signed with AccessKeyID, either in the
+// Authorization header or as an unexpired presigned URL.
+func authenticate(r *http.Request) (bool, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.Contains(auth, "Credential="+AccessKeyID+"/") {
+			return false, errInvalidAccessKeyID
+		}
+		return true, nil
+	}
+
+	q := r.URL.Query()
+	if !q.Has("X-Amz-Credential") {
+		return false, nil
+	}
+
+	if !strings.HasPrefix(q.Get("X-Amz-Credential"), AccessKeyID+"/") {
+		return false, errInvalidAccessKeyID
+	}
+
+	date, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	if err != nil {
+		return false, errAccessDenied
+	}
+	expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil {
+		return false, errAccessDenied
+	}
+	if time.Now().After(date.Add(time.Duration(expires) * time.Second)) {
+		return false, &apiError{http.StatusForbidden, "AccessDenied", "Request has expired"}
+	}
+
+	return true, nil
+}
+
+// isPublicRead reports whether r reads an object anyone can read.
+func (s *Server) isPublicRead(r *http.Request, bucket, key string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	obj, ok := s.buckets[bucket][key]
+	return ok && obj.ACL == "public-read"
+}
+
+// readBody reads a request body, decoding it if the client sent it with
+// aws-chunked encoding.
+func readBody(r *http.Request) ([]byte, error) {
+	chunked := strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") ||
+		strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-")
+	if !chunked {
+		return io.ReadAll(r.Body)
+	}
+
+	var (
+		body bytes.Buffer
+		br   = bufio.NewReader(r.Body)
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("can't read chunk header: %w", err)
+		}
+
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad chunk size %q: %w", sizeHex, err)
+		}
+		if size == 0 {
+			// The rest is trailing checksums, which aren't checked.
+			return body.Bytes(), nil
+		}
+
+		if _, err := io.CopyN(&body, br, size); err != nil {
+			return nil, fmt.Errorf("can't read chunk: %w", err)
+		}
+		if _, err := br.Discard(2); err != nil {
+			return nil, fmt.Errorf("can't read chunk: %w", err)
+		}
+	}
+}
+
+func etag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+// objectFromHeaders reads the content type, metadata and ACL of an object
+// being written.
+func objectFromHeaders(h http.Header) (Object, error) {
+	obj := Object{
+		ContentType: h.Get("Content-Type"),
+		ACL:         h.Get("x-amz-acl"),
+	}
+
+	switch obj.ACL {
+	case "":
+		obj.ACL = "private"
+	case "private", "public-read", "public-read-write", "authenticated-read", "bucket-owner-read", "bucket-owner-full-control":
+	default:
+		return Object{}, &apiError{http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("unknown canned ACL %q", obj.ACL)}
+	}
+
+	for name, values := range h {
+		name = strings.ToLower(name)
+		if meta, ok := strings.CutPrefix(name, "x-amz-meta-"); ok {
+			if obj.Metadata == nil {
+				obj.Metadata = map[string]string{}
+			}
+			obj.Metadata[meta] = values[0]
+		}
+	}
+
+	return obj, nil
+}
+
+// checkConditions enforces the If-Match and If-None-Match headers of a write
+// against the object currently at key.
+func checkConditions(h http.Header, current Object, exists bool) error {
+	if ifMatch := h.Get("If-Match"); ifMatch != "" {
+		if !exists {
+			return errNoSuchKey
+		}
+		if ifMatch != "*" && ifMatch != current.ETag {
+			return errPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := h.Get("If-None-Match"); ifNoneMatch != "" && exists {
+		if ifNoneMatch == "*" || ifNoneMatch == current.ETag {
+			return errPreconditionFailed
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	body, err := readBody(r)
+	if err != nil {
+		return &apiError{http.StatusBadRequest, "IncompleteBody", err.Error()}
+	}
+
+	obj, err := objectFromHeaders(r.Header)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(body)
+	obj.Body = body
+	obj.ETag = etag(sum[:])
+	obj.LastModified = time.Now().UTC().Truncate(time.Second)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, exists := s.buckets[bucket][key]
+	if err := checkConditions(r.Header, current, exists); err != nil {
+		return err
+	}
+
+	s.buckets[bucket][key] = obj
+
+	w.Header().Set("ETag", obj.ETag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// copyObject copies the object named by the x-amz-copy-source header. Like S3,
+// the content type and metadata are copied unless x-amz-metadata-directive is
+// REPLACE, but the ACL is always taken from the request.
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	source, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return &apiError{http.StatusBadRequest, "InvalidArgument", "x-amz-copy-source isn't URL encoded"}
+	}
+	source, _, _ = strings.Cut(source, "?versionId=")
+	srcBucket, srcKey, ok := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !ok || srcKey == "" {
+		return &apiError{http.StatusBadRequest, "InvalidArgument", "x-amz-copy-source must name a bucket and key"}
+	}
+
+	headers, err := objectFromHeaders(r.Header)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	objects, ok := s.buckets[srcBucket]
+	if !ok {
+		return errNoSuchBucket
+	}
+	src, ok := objects[srcKey]
+	if !ok {
+		return errNoSuchKey
+	}
+
+	if ifMatch := r.Header.Get("x-amz-copy-source-if-match"); ifMatch != "" && ifMatch != src.ETag {
+		return errPreconditionFailed
+	}
+
+	current, exists := s.buckets[bucket][key]
+	if err := checkConditions(r.Header, current, exists); err != nil {
+		return err
+	}
+
+	obj := src
+	obj.Body = bytes.Clone(src.Body)
+	obj.Metadata = maps.Clone(src.Metadata)
+	obj.ACL = headers.ACL
+	obj.LastModified = time.Now().UTC().Truncate(time.Second)
+	if strings.EqualFold(r.Header.Get("x-amz-metadata-directive"), "REPLACE") {
+		obj.ContentType = headers.ContentType
+		obj.Metadata = headers.Metadata
+	}
+
+	s.buckets[bucket][key] = obj
+
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: obj.ETag, LastModified: obj.LastModified.Format(time.RFC3339)})
+	return nil
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	s.lock.Lock()
+	obj, ok := s.buckets[bucket][key]
+	s.lock.Unlock()
+
+	if !ok {
+		return errNoSuchKey
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != obj.ETag {
+		return errPreconditionFailed
+	}
+
+	h := w.Header()
+	h.Set("ETag", obj.ETag)
+	h.Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	for name, value := range obj.Metadata {
+		h.Set("x-amz-meta-"+name, value)
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && ifNoneMatch == obj.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	contentType := obj.ContentType
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(obj.Body)))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodGet {
+		w.Write(obj.Body)
+	}
+	return nil
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, bucket, key string) error {
+	s.lock.Lock()
+	delete(s.buckets[bucket], key)
+	s.lock.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	var req struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errMalformedXML
+	}
+	if len(req.Objects) > defaultMaxKeys {
+		return errMalformedXML
+	}
+
+	type deleted struct {
+		Key string
+	}
+	var result struct {
+		XMLName xml.Name  `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+		Deleted []deleted `xml:"Deleted"`
+	}
+
+	s.lock.Lock()
+	for _, obj := range req.Objects {
+		delete(s.buckets[bucket], obj.Key)
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deleted{Key: obj.Key})
+		}
+	}
+	s.lock.Unlock()
+
+	writeXML(w, result)
+	return nil
+}
+
+type listEntry struct {
+	key    string
+	prefix bool // key is a common prefix rather than an object
+}
+
+// listToken encodes where a listing stopped as a continuation token.
+func listToken(e listEntry) string {
+	kind := "k"
+	if e.prefix {
+		kind = "p"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + e.key))
+}
+
+func parseListToken(token string) (listEntry, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) == 0 {
+		return listEntry{}, &apiError{http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect"}
+	}
+	return listEntry{key: string(b[1:]), prefix: b[0] == 'p'}, nil
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) error {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	startAfter := q.Get("start-after")
+
+	var after listEntry
+	if token := q.Get("continuation-token"); token != "" {
+		var err error
+		if after, err = parseListToken(token); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	maxKeys := s.maxKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &apiError{http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer"}
+		}
+		maxKeys = min(maxKeys, n)
+	}
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		KeyCount              int            `xml:"KeyCount"`
+		MaxKeys               int            `xml:"MaxKeys"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        startAfter,
+		ContinuationToken: q.Get("continuation-token"),
+		MaxKeys:           maxKeys,
+	}
+
+	var last listEntry
+	for _, key := range slices.Sorted(maps.Keys(s.buckets[bucket])) {
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			continue
+		}
+		if after.key != "" && (key <= after.key || after.prefix && strings.HasPrefix(key, after.key)) {
+			continue
+		}
+
+		entry := listEntry{key: key}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = listEntry{key: key[:len(prefix)+i+len(delimiter)], prefix: true}
+			}
+		}
+		if entry.prefix && entry == last {
+			continue
+		}
+
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = listToken(last)
+			break
+		}
+
+		if entry.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry.key})
+		} else {
+			obj := s.buckets[bucket][key]
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: obj.LastModified.Format(time.RFC3339),
+				ETag:         obj.ETag,
+				Size:         len(obj.Body),
+				StorageClass: "STANDARD",
+			})
+		}
+		result.KeyCount++
+		last = entry
+	}
+
+	writeXML(w, result)
+	return nil
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	obj, err := objectFromHeaders(r.Header)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.uploads[id] = &upload{bucket: bucket, key: key, object: obj, parts: map[int][]byte{}}
+	s.lock.Unlock()
+
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: bucket, Key: key, UploadId: id})
+	return nil
+}
+
+// findUpload returns the upload r refers to. The lock must be held.
+func (s *Server) findUpload(r *http.Request) (*upload, error) {
+	u, ok := s.uploads[r.URL.Query().Get("uploadId")]
+	if !ok {
+		return nil, errNoSuchUpload
+	}
+	return u, nil
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > 10000 {
+		return &apiError{http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000"}
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return &apiError{http.StatusBadRequest, "IncompleteBody", err.Error()}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	u, err := s.findUpload(r)
+	if err != nil {
+		return err
+	}
+	u.parts[number] = body
+
+	sum := md5.Sum(body)
+	w.Header().Set("ETag", etag(sum[:]))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	u, err := s.findUpload(r)
+	if err != nil {
+		return err
+	}
+
+	var (
+		body bytes.Buffer
+		sums []byte
+	)
+	for _, part := range req.Parts {
+		data, ok := u.parts[part.PartNumber]
+		sum := md5.Sum(data)
+		if !ok || part.ETag != etag(sum[:]) {
+			return errInvalidPart
+		}
+		body.Write(data)
+		sums = append(sums, sum[:]...)
+	}
+
+	sum := md5.Sum(sums)
+	obj := u.object
+	obj.Body = body.Bytes()
+	obj.ETag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts))
+	obj.LastModified = time.Now().UTC().Truncate(time.Second)
+
+	s.buckets[bucket][key] = obj
+	delete(s.uploads, r.URL.Query().Get("uploadId"))
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: bucket, Key: key, ETag: obj.ETag})
+	return nil
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.findUpload(r); err != nil {
+		return err
+	}
+	delete(s.uploads, r.URL.Query().Get("uploadId"))
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,243 @@
+package s3test
+
+import (
+	"encoding/xml"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testAuth = "AWS4-HMAC-SHA256 Credential=" + AccessKeyID + "/20250101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=0"
+
+func do(t *testing.T, method, url, body string, header http.Header) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.NewRequest() error = %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func signed(h http.Header) http.Header {
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set("Authorization", testAuth)
+	return h
+}
+
+func TestServer_ChunkedPut(t *testing.T) {
+	s := NewServer(t, "bucket")
+
+	body := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0\r\nx-amz-checksum-crc32:AAAAAA==\r\n\r\n"
+	resp := do(t, http.MethodPut, s.URL+"/bucket/greeting", body, signed(http.Header{
+		"Content-Encoding":             {"aws-chunked"},
+		"X-Amz-Decoded-Content-Length": {"11"},
+		"Content-Type":                 {"text/plain"},
+		"X-Amz-Meta-Source":            {"test"},
+	}))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT status = %d, want 200", resp.StatusCode)
+	}
+
+	obj, ok := s.Object("bucket", "greeting")
+	if !ok {
+		t.Fatal("PUT didn't store the object")
+	}
+	if string(obj.Body) != "hello world" {
+		t.Errorf("stored body = %q, want %q", obj.Body, "hello world")
+	}
+	if obj.ContentType != "text/plain" || obj.Metadata["source"] != "test" || obj.ACL != "private" {
+		t.Errorf("stored object = %+v, want text/plain, source=test and private", obj)
+	}
+}
+
+func TestServer_Access(t *testing.T) {
+	s := NewServer(t, "bucket")
+
+	do(t, http.MethodPut, s.URL+"/bucket/public", "a", signed(http.Header{"X-Amz-Acl": {"public-read"}}))
+	do(t, http.MethodPut, s.URL+"/bucket/private", "b", signed(nil))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{name: "anonymous read of public object", method: http.MethodGet, path: "/bucket/public", want: http.StatusOK},
+		{name: "anonymous read of private object", method: http.MethodGet, path: "/bucket/private", want: http.StatusForbidden},
+		{name: "anonymous write", method: http.MethodPut, path: "/bucket/public", want: http.StatusForbidden},
+		{
+			name:   "unknown access key",
+			method: http.MethodGet,
+			path:   "/bucket/private",
+			header: http.Header{"Authorization": {"AWS4-HMAC-SHA256 Credential=nobody/20250101/us-east-1/s3/aws4_request"}},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "expired presigned URL",
+			method: http.MethodGet,
+			path:   "/bucket/private?X-Amz-Credential=" + AccessKeyID + "%2F20250101&X-Amz-Date=20250101T000000Z&X-Amz-Expires=60",
+			want:   http.StatusForbidden,
+		},
+		{name: "missing bucket", method: http.MethodGet, path: "/nope/key", header: signed(nil), want: http.StatusNotFound},
+		{name: "missing key", method: http.MethodGet, path: "/bucket/nope", header: signed(nil), want: http.StatusNotFound},
+		{
+			name:   "create over an existing key",
+			method: http.MethodPut,
+			path:   "/bucket/private",
+			header: signed(http.Header{"If-None-Match": {"*"}}),
+			want:   http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(t, tt.method, s.URL+tt.path, "", tt.header)
+			if resp.StatusCode != tt.want {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("%s %s status = %d, want %d: %s", tt.method, tt.path, resp.StatusCode, tt.want, body)
+			}
+		})
+	}
+}
+
+func TestServer_ListDelimiter(t *testing.T) {
+	s := NewServer(t, "bucket")
+	s.SetMaxKeys(2)
+
+	for _, key := range []string{"a/1", "a/2", "b", "c/1", "d"} {
+		do(t, http.MethodPut, s.URL+"/bucket/"+key, key, signed(nil))
+	}
+
+	var (
+		got   []string
+		token string
+	)
+	for range 10 {
+		url := s.URL + "/bucket?list-type=2&delimiter=/"
+		if token != "" {
+			url += "&continuation-token=" + token
+		}
+
+		var page struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct{ Key string }
+			CommonPrefixes        []struct{ Prefix string }
+		}
+		resp := do(t, http.MethodGet, url, "", signed(nil))
+		if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("can't decode listing: %v", err)
+		}
+
+		for _, p := range page.CommonPrefixes {
+			got = append(got, p.Prefix)
+		}
+		for _, c := range page.Contents {
+			got = append(got, c.Key)
+		}
+
+		if !page.IsTruncated {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+
+	slices.Sort(got)
+	if want := []string{"a/", "b", "c/", "d"}; !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+}
+
+func TestServer_Copy(t *testing.T) {
+	s := NewServer(t, "bucket", "other")
+
+	do(t, http.MethodPut, s.URL+"/bucket/a%20b", "value", signed(http.Header{
+		"Content-Type":      {"text/plain"},
+		"X-Amz-Meta-Source": {"test"},
+		"X-Amz-Acl":         {"public-read"},
+	}))
+	src, _ := s.Object("bucket", "a b")
+
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		want       int
+		wantObject Object
+	}{
+		{
+			name:       "keeps content type and metadata",
+			path:       "/other/copy",
+			header:     http.Header{"X-Amz-Copy-Source": {"bucket/a%20b"}},
+			want:       http.StatusOK,
+			wantObject: Object{ContentType: "text/plain", Metadata: map[string]string{"source": "test"}, ACL: "private"},
+		},
+		{
+			name: "replaces metadata",
+			path: "/bucket/replaced",
+			header: http.Header{
+				"X-Amz-Copy-Source":        {"/bucket/a%20b"},
+				"X-Amz-Metadata-Directive": {"REPLACE"},
+				"Content-Type":             {"application/json"},
+				"X-Amz-Acl":                {"public-read"},
+			},
+			want:       http.StatusOK,
+			wantObject: Object{ContentType: "application/json", ACL: "public-read"},
+		},
+		{
+			name:   "source ETag doesn't match",
+			path:   "/bucket/stale",
+			header: http.Header{"X-Amz-Copy-Source": {"bucket/a%20b"}, "X-Amz-Copy-Source-If-Match": {`"nope"`}},
+			want:   http.StatusPreconditionFailed,
+		},
+		{
+			name:   "missing source",
+			path:   "/bucket/missing",
+			header: http.Header{"X-Amz-Copy-Source": {"bucket/nope"}},
+			want:   http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(t, http.MethodPut, s.URL+tt.path, "", signed(tt.header))
+			if resp.StatusCode != tt.want {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("PUT %s status = %d, want %d: %s", tt.path, resp.StatusCode, tt.want, body)
+			}
+
+			bucket, key, _ := strings.Cut(strings.TrimPrefix(tt.path, "/"), "/")
+			obj, ok := s.Object(bucket, key)
+			if tt.want != http.StatusOK {
+				if ok {
+					t.Errorf("failed copy stored %+v", obj)
+				}
+				return
+			}
+
+			if string(obj.Body) != "value" || obj.ETag != src.ETag {
+				t.Errorf("copy = %q with ETag %s, want %q with ETag %s", obj.Body, obj.ETag, "value", src.ETag)
+			}
+			if obj.ContentType != tt.wantObject.ContentType || obj.ACL != tt.wantObject.ACL || !maps.Equal(obj.Metadata, tt.wantObject.Metadata) {
+				t.Errorf("copy = %+v, want %+v", obj, tt.wantObject)
+			}
+		})
+	}
+}
@@ -1,14 +1,15 @@
 package store
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"slices"
 	"strings"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
 )
 
 var (
@@ -27,12 +28,23 @@ var (
 	// ErrBadConfig is returned when a store adaptor's configuration is invalid.
 	ErrBadConfig = errors.New("store: configuration is invalid")
 
-	iopsMetrics = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "tigris_gtm",
-		Subsystem: "glue",
-		Name:      "store_iops",
-		Help:      "The number of times each store iop is called",
-	}, []string{"driver", "action"})
+	// ErrConflict is returned when a conditional write's precondition doesn't
+	// hold, such as creating a key that already exists or swapping a value that
+	// has changed since it was read.
+	ErrConflict = errors.New("store: precondition failed")
+
+	// ErrInvalidKey is returned when a key can't be represented by a store
+	// implementation, such as an empty key or one that escapes a directory.
+	ErrInvalidKey = errors.New("store: key is invalid")
+
+	// ErrPermission is returned when the store refuses an operation because
+	// the credentials it was given aren't allowed to do it.
+	ErrPermission = errors.New("store: permission denied")
+
+	// ErrUnavailable is returned when the store can't be reached or is
+	// overloaded, such as a network timeout, throttling or a server error.
+	// Operations that fail with it may succeed if they are tried again.
+	ErrUnavailable = errors.New("store: temporarily unavailable")
 )
 
 // Interface defines the calls for a generic key value storage interface. This can be
@@ -47,83 +59,330 @@ type Interface interface {
 	// Get returns the value of a key assuming that value exists and has not expired.
 	Get(ctx context.Context, key string) ([]byte, error)
 
-	// Set puts a value into the store that expires according to its expiry.
+	// Set puts a value into the store. Values written with Set never expire, use
+	// SetWithTTL for values that should.
 	Set(ctx context.Context, key string, value []byte) error
 
 	// List lists the keys in this keyspace optionally matching by a prefix.
 	List(ctx context.Context, prefix string) ([]string, error)
 }
 
-func z[T any]() T { return *new(T) }
+// TTLSetter is implemented by stores that can expire values on their own.
+type TTLSetter interface {
+	// SetWithTTL puts a value into the store that expires after ttl. Once a value
+	// has expired, Get and Exists return ErrNotFound. List omits it too, except
+	// on stores that document otherwise (see S3Options.HideExpired). A ttl of
+	// zero or less means the value never expires.
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// SetWithTTL puts a value into s that expires after ttl if s implements TTLSetter.
+// Stores that can't expire values keep them until they are deleted, the same as
+// Set.
+func SetWithTTL(ctx context.Context, s Interface, key string, value []byte, ttl time.Duration) error {
+	if ts, ok := s.(TTLSetter); ok {
+		return ts.SetWithTTL(ctx, key, value, ttl)
+	}
 
-type JSON[T any] struct {
-	Underlying Interface
-	Prefix     string
+	return s.Set(ctx, key, value)
 }
 
-func (j *JSON[T]) Delete(ctx context.Context, key string) error {
-	if j.Prefix != "" {
-		key = j.Prefix + "/" + key
+// ListOptions controls how Iterate walks a keyspace.
+type ListOptions struct {
+	// StartAfter skips every key that sorts at or before it.
+	StartAfter string
+
+	// Limit stops iteration after this many keys. Zero means no limit.
+	Limit int
+}
+
+// Iterator is implemented by stores that can stream keys without loading the
+// whole listing into memory.
+type Iterator interface {
+	// Iterate yields the keys matching prefix in lexicographic order. If listing
+	// fails, the error is yielded once and iteration stops.
+	Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error]
+}
+
+// Iterate streams the keys in s matching prefix. Stores that don't implement
+// Iterator are listed in full and then filtered.
+func Iterate(ctx context.Context, s Interface, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	if it, ok := s.(Iterator); ok {
+		return it.Iterate(ctx, prefix, opts)
+	}
+
+	return func(yield func(string, error) bool) {
+		keys, err := s.List(ctx, prefix)
+		if err != nil {
+			yield("", err)
+			return
+		}
+
+		slices.Sort(keys)
+
+		count := 0
+		for _, key := range keys {
+			if opts.StartAfter != "" && key <= opts.StartAfter {
+				continue
+			}
+
+			if !yield(key, nil) {
+				return
+			}
+
+			count++
+			if opts.Limit > 0 && count >= opts.Limit {
+				return
+			}
+		}
 	}
+}
+
+// StreamInfo describes a value that is read or written as a stream.
+type StreamInfo struct {
+	// ContentLength is the size of the value in bytes, or -1 if it isn't known.
+	ContentLength int64
+
+	// ContentType is the MIME type of the value, if known.
+	ContentType string
+}
+
+// Streamer is implemented by stores that can move values without holding them
+// in memory all at once.
+type Streamer interface {
+	// GetReader opens a value for reading. The caller must close the reader.
+	GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error)
 
-	return j.Underlying.Delete(ctx, key)
+	// SetReader puts the contents of r into the store. info.ContentLength may be
+	// -1 if the size of r isn't known ahead of time.
+	SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error
 }
 
-func (j *JSON[T]) Exists(ctx context.Context, key string) error {
-	if j.Prefix != "" {
-		key = j.Prefix + "/" + key
+// GetReader opens a value in s for reading. Stores that don't implement
+// Streamer have the whole value read into memory first.
+func GetReader(ctx context.Context, s Interface, key string) (io.ReadCloser, StreamInfo, error) {
+	if st, ok := s.(Streamer); ok {
+		return st.GetReader(ctx, key)
 	}
 
-	return j.Underlying.Exists(ctx, key)
+	data, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, StreamInfo{}, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), StreamInfo{ContentLength: int64(len(data))}, nil
 }
 
-func (j *JSON[T]) Get(ctx context.Context, key string) (T, error) {
-	if j.Prefix != "" {
-		key = j.Prefix + "/" + key
+// SetReader puts the contents of r into s. Stores that don't implement Streamer
+// have r read into memory first.
+func SetReader(ctx context.Context, s Interface, key string, r io.Reader, info StreamInfo) error {
+	if st, ok := s.(Streamer); ok {
+		return st.SetReader(ctx, key, r, info)
 	}
 
-	data, err := j.Underlying.Get(ctx, key)
+	data, err := io.ReadAll(r)
 	if err != nil {
-		return z[T](), err
+		return fmt.Errorf("can't read value for %s: %w", key, err)
 	}
 
-	var result T
-	if err := json.Unmarshal(data, &result); err != nil {
-		return z[T](), fmt.Errorf("%w: %w", ErrCantDecode, err)
+	return s.Set(ctx, key, data)
+}
+
+// Condition is the precondition for a conditional write. The zero value always
+// holds.
+type Condition struct {
+	// IfNotExists only writes the value if the key doesn't exist yet.
+	IfNotExists bool
+
+	// IfVersion only writes the value if the key's current version matches, as
+	// returned by GetVersion or SetIf.
+	IfVersion string
+
+	// Options are written along with the value, the same as with
+	// SetWithOptions. Like any write, a conditional write replaces the TTL,
+	// content type and metadata the key had before.
+	Options SetOptions
+}
+
+// ConditionalSetter is implemented by stores that can atomically check the
+// current state of a key before writing it.
+type ConditionalSetter interface {
+	// GetVersion returns a value along with an opaque version identifier that
+	// changes every time the value is written.
+	GetVersion(ctx context.Context, key string) ([]byte, string, error)
+
+	// SetIf writes a value if cond holds and returns its new version. If cond
+	// doesn't hold, it returns ErrConflict and leaves the value untouched, or
+	// ErrNotFound if cond.IfVersion is set and the key doesn't exist.
+	SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error)
+}
+
+// GetVersion returns a value in s along with its version.
+func GetVersion(ctx context.Context, s Interface, key string) ([]byte, string, error) {
+	cs, ok := s.(ConditionalSetter)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %T doesn't support versioned reads", errors.ErrUnsupported, s)
 	}
 
-	return result, nil
+	return cs.GetVersion(ctx, key)
 }
 
-func (j *JSON[T]) Set(ctx context.Context, key string, value T) error {
-	if j.Prefix != "" {
-		key = j.Prefix + "/" + key
+// Create puts a value into s only if key doesn't exist yet. It returns
+// ErrConflict if it does, which makes it usable as an atomic "claim this key"
+// primitive.
+func Create(ctx context.Context, s Interface, key string, value []byte) error {
+	cs, ok := s.(ConditionalSetter)
+	if !ok {
+		return fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, s)
 	}
 
-	data, err := json.Marshal(value)
-	if err != nil {
-		return fmt.Errorf("%w: %w", ErrCantEncode, err)
+	_, err := cs.SetIf(ctx, key, value, Condition{IfNotExists: true})
+	return err
+}
+
+// CompareAndSwap replaces a value in s only if its version is still version.
+// It returns the new version, ErrConflict if the value changed in the
+// meantime, or ErrNotFound if it was deleted.
+func CompareAndSwap(ctx context.Context, s Interface, key, version string, value []byte) (string, error) {
+	cs, ok := s.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, s)
 	}
 
-	if err := j.Underlying.Set(ctx, key, data); err != nil {
-		return err
+	return cs.SetIf(ctx, key, value, Condition{IfVersion: version})
+}
+
+// ObjectInfo describes a value in a store.
+type ObjectInfo struct {
+	// Key is the key the value is stored under.
+	Key string
+
+	// Size is the length of the value in bytes.
+	Size int64
+
+	// LastModified is when the value was last written.
+	LastModified time.Time
+
+	// Version is the value's version as returned by GetVersion, or empty if
+	// the store can't tell without reading the value.
+	Version string
+
+	// ContentType is the MIME type the value was written with, if any.
+	ContentType string
+
+	// Metadata is the user metadata the value was written with, with
+	// lowercase keys.
+	Metadata map[string]string
+
+	// Expires is when a value written with a TTL expires, or the zero time if
+	// it never does.
+	Expires time.Time
+}
+
+// Statter is implemented by stores that can describe a value without reading
+// it.
+type Statter interface {
+	// Stat returns information about a value, or ErrNotFound if it doesn't
+	// exist or has expired.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+// Stat describes a value in s. Stores that don't implement Statter return
+// errors.ErrUnsupported rather than making up a modification time.
+func Stat(ctx context.Context, s Interface, key string) (ObjectInfo, error) {
+	st, ok := s.(Statter)
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: %T doesn't support stat", errors.ErrUnsupported, s)
 	}
 
-	return nil
+	return st.Stat(ctx, key)
 }
 
-func (j *JSON[T]) List(ctx context.Context, prefix string) ([]string, error) {
-	fullPrefix := j.Prefix + "/" + prefix
-	keys, err := j.Underlying.List(ctx, fullPrefix)
+// SetOptions are the attributes of a value written with SetWithOptions.
+type SetOptions struct {
+	// ContentType is the MIME type of the value.
+	ContentType string
+
+	// Metadata is user metadata kept alongside the value. Keys are
+	// case-insensitive and are stored in lowercase.
+	Metadata map[string]string
+
+	// TTL is how long the value lasts, the same as with SetWithTTL. Zero or
+	// less means it never expires.
+	TTL time.Duration
+
+	// Public makes the value readable by anyone at its PublicURL.
+	Public bool
+}
+
+// storedOptions returns the content type, metadata and remaining TTL of key in
+// s, so the value can be rewritten without losing them. Stores that can't
+// Stat give the zero SetOptions. It returns ErrNotFound if key has expired.
+func storedOptions(ctx context.Context, s Interface, key string) (SetOptions, error) {
+	info, err := Stat(ctx, s, key)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return SetOptions{}, nil
+	}
 	if err != nil {
-		return nil, err
+		return SetOptions{}, err
 	}
 
-	// Strip the full prefix from each key.
-	result := make([]string, 0, len(keys))
-	for _, k := range keys {
-		result = append(result, strings.TrimPrefix(k, fullPrefix))
+	opts := SetOptions{
+		ContentType: info.ContentType,
+		Metadata:    info.Metadata,
+	}
+	if !info.Expires.IsZero() {
+		opts.TTL = time.Until(info.Expires)
+		if opts.TTL <= 0 {
+			return SetOptions{}, fmt.Errorf("%w: %s has expired", ErrNotFound, key)
+		}
+	}
+
+	return opts, nil
+}
+
+// MetadataSetter is implemented by stores that can keep a content type and
+// user metadata alongside a value.
+type MetadataSetter interface {
+	// SetWithOptions puts a value into the store with the attributes in opts,
+	// replacing any the key had before.
+	SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error
+}
+
+// SetWithOptions puts a value into s with the attributes in opts. Stores that
+// don't implement MetadataSetter keep the value and its TTL, the same as
+// SetWithTTL, but drop the content type and metadata. They return
+// errors.ErrUnsupported for public values, since nobody else could read them.
+func SetWithOptions(ctx context.Context, s Interface, key string, value []byte, opts SetOptions) error {
+	if ms, ok := s.(MetadataSetter); ok {
+		return ms.SetWithOptions(ctx, key, value, opts)
+	}
+
+	if opts.Public {
+		return fmt.Errorf("%w: %T doesn't support public values", errors.ErrUnsupported, s)
 	}
 
-	return result, nil
+	return SetWithTTL(ctx, s, key, value, opts.TTL)
 }
+
+// lowerKeys returns a copy of metadata with lowercase keys, or nil if it's
+// empty.
+func lowerKeys(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[strings.ToLower(k)] = v
+	}
+
+	return result
+}
+
+func z[T any]() T { return *new(T) }
+
+// JSON is a Typed store that keeps values as plain JSON without a header, the
+// format every store in this repo used before Typed had codecs. It reads values
+// written with any codec or compression.
+type JSON[T any] = Typed[T]
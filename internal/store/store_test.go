@@ -3,7 +3,12 @@ package store
 import (
 	"context"
 	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // mockStore is an in-memory implementation for testing.
@@ -817,6 +822,28 @@ func TestJSON_List(t *testing.T) {
 			want:    []string{"a", "b"},
 			wantErr: nil,
 		},
+		{
+			name: "lists every key without a prefix",
+			setup: func(m *mockStore) {
+				m.data["a"] = []byte(`{"name":"a"}`)
+				m.data["sub/b"] = []byte(`{"name":"b"}`)
+			},
+			prefix:  "",
+			listArg: "",
+			want:    []string{"a", "sub/b"},
+			wantErr: nil,
+		},
+		{
+			name: "lists keys with sub-prefix without a prefix",
+			setup: func(m *mockStore) {
+				m.data["a"] = []byte(`{"name":"a"}`)
+				m.data["sub/b"] = []byte(`{"name":"b"}`)
+			},
+			prefix:  "",
+			listArg: "sub/",
+			want:    []string{"b"},
+			wantErr: nil,
+		},
 		{
 			name:    "returns empty list for non-existent prefix",
 			setup:   func(m *mockStore) {},
@@ -850,6 +877,402 @@ func TestJSON_List(t *testing.T) {
 	}
 }
 
+func TestJSON_SetWithTTL(t *testing.T) {
+	type testStruct struct {
+		Name string `json:"name"`
+	}
+
+	ctx := context.Background()
+	clock := newFakeClock()
+	m := NewMemory()
+	m.now = clock.Now
+
+	j := &JSON[testStruct]{
+		Underlying: m,
+		Prefix:     "testprefix",
+	}
+
+	if err := j.SetWithTTL(ctx, "mykey", testStruct{Name: "test"}, time.Hour); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+
+	if got, err := j.Get(ctx, "mykey"); err != nil || got.Name != "test" {
+		t.Errorf("Get() got = %v, %v, want name %q", got, err, "test")
+	}
+
+	if info, err := m.Stat(ctx, "testprefix/mykey"); err != nil || info.ContentType != "application/json" {
+		t.Errorf("Stat() = %+v, %v, want content type %q like Set", info, err, "application/json")
+	}
+
+	clock.Advance(time.Hour)
+
+	if _, err := j.Get(ctx, "mykey"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after expiry error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := j.Exists(ctx, "mykey"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() after expiry error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestSetWithTTL_Fallback(t *testing.T) {
+	m := newMockStore()
+
+	if err := SetWithTTL(context.Background(), m, "key", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+
+	if got := m.data["key"]; string(got) != "value" {
+		t.Errorf("SetWithTTL() stored value = %q, want %q", got, "value")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		opts   ListOptions
+		stopAt int
+		want   []string
+	}{
+		{
+			name:   "yields every matching key in order",
+			prefix: "foo/",
+			want:   []string{"foo/a", "foo/b", "foo/c", "foo/d"},
+		},
+		{
+			name:   "starts after a key",
+			prefix: "foo/",
+			opts:   ListOptions{StartAfter: "foo/b"},
+			want:   []string{"foo/c", "foo/d"},
+		},
+		{
+			name:   "stops at the limit",
+			prefix: "foo/",
+			opts:   ListOptions{Limit: 2},
+			want:   []string{"foo/a", "foo/b"},
+		},
+		{
+			name:   "combines start after and limit",
+			prefix: "",
+			opts:   ListOptions{StartAfter: "bar/a", Limit: 1},
+			want:   []string{"foo/a"},
+		},
+		{
+			name:   "stops when the caller breaks",
+			prefix: "foo/",
+			stopAt: 3,
+			want:   []string{"foo/a", "foo/b", "foo/c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockStore()
+			for _, k := range []string{"foo/d", "foo/c", "foo/b", "foo/a", "bar/a"} {
+				m.data[k] = []byte(k)
+			}
+
+			var got []string
+			for key, err := range Iterate(context.Background(), m, tt.prefix, tt.opts) {
+				if err != nil {
+					t.Fatalf("Iterate() error = %v", err)
+				}
+
+				got = append(got, key)
+				if tt.stopAt != 0 && len(got) == tt.stopAt {
+					break
+				}
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Iterate() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSON_Iterate(t *testing.T) {
+	m := newMockStore()
+	for _, k := range []string{"testprefix/a", "testprefix/b", "testprefix/c", "other/x"} {
+		m.data[k] = []byte(`{}`)
+	}
+
+	j := &JSON[struct{}]{
+		Underlying: m,
+		Prefix:     "testprefix",
+	}
+
+	var got []string
+	for key, err := range j.Iterate(context.Background(), "", ListOptions{StartAfter: "a"}) {
+		if err != nil {
+			t.Fatalf("Iterate() error = %v", err)
+		}
+		got = append(got, key)
+	}
+
+	if want := []string{"b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("Iterate() got = %v, want %v", got, want)
+	}
+}
+
+func TestStreamFallback(t *testing.T) {
+	ctx := context.Background()
+	m := newMockStore()
+
+	if err := SetReader(ctx, m, "key", strings.NewReader("streamed"), StreamInfo{ContentLength: -1}); err != nil {
+		t.Fatalf("SetReader() error = %v", err)
+	}
+
+	if got := m.data["key"]; string(got) != "streamed" {
+		t.Errorf("SetReader() stored value = %q, want %q", got, "streamed")
+	}
+
+	rc, info, err := GetReader(ctx, m, "key")
+	if err != nil {
+		t.Fatalf("GetReader() error = %v", err)
+	}
+	defer rc.Close()
+
+	if info.ContentLength != int64(len("streamed")) {
+		t.Errorf("GetReader() content length = %d, want %d", info.ContentLength, len("streamed"))
+	}
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != "streamed" {
+		t.Errorf("GetReader() got = %q, want %q", got, "streamed")
+	}
+
+	if _, _, err := GetReader(ctx, m, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetReader() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestJSON_Stream(t *testing.T) {
+	type testStruct struct {
+		Name string `json:"name"`
+	}
+
+	ctx := context.Background()
+	d := newTestDirectory(t, nil)
+
+	j := &JSON[testStruct]{
+		Underlying: d,
+		Prefix:     "testprefix",
+	}
+
+	if err := j.Set(ctx, "mykey", testStruct{Name: "test"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := j.Get(ctx, "mykey")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Name != "test" {
+		t.Errorf("Get() got = %v, want name %q", got, "test")
+	}
+
+	bad := &JSON[chan int]{Underlying: d}
+	if err := bad.Set(ctx, "bad", make(chan int)); !errors.Is(err, ErrCantEncode) {
+		t.Errorf("Set() error = %v, want %v", err, ErrCantEncode)
+	}
+
+	if err := d.Set(ctx, "testprefix/garbage", []byte("not json")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if _, err := j.Get(ctx, "garbage"); !errors.Is(err, ErrCantDecode) {
+		t.Errorf("Get() error = %v, want %v", err, ErrCantDecode)
+	}
+}
+
+func TestConditionalSetter(t *testing.T) {
+	drivers := map[string]func(t *testing.T) Interface{
+		"memory": func(t *testing.T) Interface { return NewMemory() },
+		"directory": func(t *testing.T) Interface {
+			return newTestDirectory(t, nil)
+		},
+		"lru": func(t *testing.T) Interface {
+			l, err := NewLRUCache(NewMemory())
+			if err != nil {
+				t.Fatalf("NewLRUCache() error = %v", err)
+			}
+			return l
+		},
+		"s3api": func(t *testing.T) Interface {
+			s, _ := newTestS3API(t)
+			return s
+		},
+	}
+
+	for name, newStore := range drivers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+
+			if err := Create(ctx, s, "claim", []byte("first")); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			if err := Create(ctx, s, "claim", []byte("second")); !errors.Is(err, ErrConflict) {
+				t.Errorf("Create() on existing key error = %v, want %v", err, ErrConflict)
+			}
+
+			value, version, err := GetVersion(ctx, s, "claim")
+			if err != nil {
+				t.Fatalf("GetVersion() error = %v", err)
+			}
+			if string(value) != "first" {
+				t.Errorf("GetVersion() value = %q, want %q", value, "first")
+			}
+
+			newVersion, err := CompareAndSwap(ctx, s, "claim", version, []byte("swapped"))
+			if err != nil {
+				t.Fatalf("CompareAndSwap() error = %v", err)
+			}
+			if newVersion == version {
+				t.Errorf("CompareAndSwap() returned unchanged version %q", version)
+			}
+
+			if _, err := CompareAndSwap(ctx, s, "claim", version, []byte("stale")); !errors.Is(err, ErrConflict) {
+				t.Errorf("CompareAndSwap() with stale version error = %v, want %v", err, ErrConflict)
+			}
+
+			if got, err := s.Get(ctx, "claim"); err != nil || string(got) != "swapped" {
+				t.Errorf("Get() got = %q, %v, want %q", got, err, "swapped")
+			}
+
+			if _, err := CompareAndSwap(ctx, s, "missing", version, []byte("value")); !errors.Is(err, ErrNotFound) {
+				t.Errorf("CompareAndSwap() on missing key error = %v, want %v", err, ErrNotFound)
+			}
+
+			if _, _, err := GetVersion(ctx, s, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetVersion() on missing key error = %v, want %v", err, ErrNotFound)
+			}
+		})
+
+		t.Run(name+"/concurrent create", func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+
+			var (
+				wg   sync.WaitGroup
+				lock sync.Mutex
+				wins int
+			)
+
+			for range 16 {
+				wg.Go(func() {
+					err := Create(ctx, s, "claim", []byte("mine"))
+					if err != nil && !errors.Is(err, ErrConflict) {
+						t.Errorf("Create() error = %v", err)
+						return
+					}
+
+					if err == nil {
+						lock.Lock()
+						wins++
+						lock.Unlock()
+					}
+				})
+			}
+			wg.Wait()
+
+			if wins != 1 {
+				t.Errorf("Create() succeeded %d times, want 1", wins)
+			}
+		})
+	}
+}
+
+func TestConditionalSetter_Options(t *testing.T) {
+	drivers := map[string]func(t *testing.T) Interface{
+		"memory": func(t *testing.T) Interface { return NewMemory() },
+		"s3api": func(t *testing.T) Interface {
+			s, _ := newTestS3API(t)
+			return s
+		},
+	}
+
+	for name, newStore := range drivers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			cs := s.(ConditionalSetter)
+
+			opts := SetOptions{TTL: time.Hour, ContentType: "text/plain", Metadata: map[string]string{"owner": "test"}}
+			version, err := cs.SetIf(ctx, "key", []byte("first"), Condition{IfNotExists: true, Options: opts})
+			if err != nil {
+				t.Fatalf("SetIf() error = %v", err)
+			}
+			if _, err := cs.SetIf(ctx, "key", []byte("second"), Condition{IfVersion: version, Options: opts}); err != nil {
+				t.Fatalf("SetIf() error = %v", err)
+			}
+
+			info, err := Stat(ctx, s, "key")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if info.ContentType != "text/plain" || info.Metadata["owner"] != "test" {
+				t.Errorf("Stat() = %+v, want the content type and metadata written", info)
+			}
+			if until := time.Until(info.Expires); until <= 0 || until > time.Hour {
+				t.Errorf("Stat().Expires is %v from now, want within the TTL", until)
+			}
+		})
+	}
+}
+
+func TestConditionalSetter_Unsupported(t *testing.T) {
+	m := newMockStore()
+
+	if err := Create(context.Background(), m, "key", []byte("value")); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Create() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestJSON_CompareAndSwap(t *testing.T) {
+	type counter struct {
+		N int `json:"n"`
+	}
+
+	ctx := context.Background()
+	j := &JSON[counter]{
+		Underlying: NewMemory(),
+		Prefix:     "counters",
+	}
+
+	if err := j.Create(ctx, "hits", counter{N: 1}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := j.Create(ctx, "hits", counter{N: 100}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Create() on existing key error = %v, want %v", err, ErrConflict)
+	}
+
+	got, version, err := j.GetVersion(ctx, "hits")
+	if err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+
+	got.N++
+	if _, err := j.CompareAndSwap(ctx, "hits", version, got); err != nil {
+		t.Fatalf("CompareAndSwap() error = %v", err)
+	}
+
+	if _, err := j.CompareAndSwap(ctx, "hits", version, counter{N: 100}); !errors.Is(err, ErrConflict) {
+		t.Errorf("CompareAndSwap() with stale version error = %v, want %v", err, ErrConflict)
+	}
+
+	if got, err := j.Get(ctx, "hits"); err != nil || got.N != 2 {
+		t.Errorf("Get() got = %v, %v, want n=2", got, err)
+	}
+}
+
 // equalBytes compares byte slices for equality.
 func equalBytes(a, b []byte) bool {
 	if len(a) != len(b) {
@@ -879,3 +1302,131 @@ func equalStringSlicesUnordered(a, b []string) bool {
 	}
 	return true
 }
+
+func TestStat_Unsupported(t *testing.T) {
+	if _, err := Stat(context.Background(), newMockStore(), "key"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Stat() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestSetWithOptions_Fallback(t *testing.T) {
+	m := newMockStore()
+
+	opts := SetOptions{ContentType: "text/plain", Metadata: map[string]string{"a": "b"}}
+	if err := SetWithOptions(context.Background(), m, "key", []byte("value"), opts); err != nil {
+		t.Fatalf("SetWithOptions() error = %v", err)
+	}
+
+	if got := m.data["key"]; string(got) != "value" {
+		t.Errorf("SetWithOptions() stored value = %q, want %q", got, "value")
+	}
+
+	opts.Public = true
+	if err := SetWithOptions(context.Background(), m, "public", []byte("value"), opts); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetWithOptions() with Public error = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestStat_Decorators(t *testing.T) {
+	ctx := context.Background()
+	opts := SetOptions{ContentType: "text/plain", Metadata: map[string]string{"source": "feed"}}
+
+	tests := []struct {
+		name            string
+		wrap            func(t *testing.T, m *Memory) Interface
+		wantContentType string
+	}{
+		{
+			name:            "prefixed",
+			wrap:            func(t *testing.T, m *Memory) Interface { return NewPrefixed(m, "scope") },
+			wantContentType: "text/plain",
+		},
+		{
+			name: "lru",
+			wrap: func(t *testing.T, m *Memory) Interface {
+				l, err := NewLRUCache(m)
+				if err != nil {
+					t.Fatalf("NewLRUCache() error = %v", err)
+				}
+				return l
+			},
+			wantContentType: "text/plain",
+		},
+		{
+			name: "encrypted",
+			wrap: func(t *testing.T, m *Memory) Interface {
+				return newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+			},
+			wantContentType: "",
+		},
+		{
+			name:            "observed",
+			wrap:            func(t *testing.T, m *Memory) Interface { return observed(m, "test-stat") },
+			wantContentType: "text/plain",
+		},
+		{
+			name: "retry",
+			wrap: func(t *testing.T, m *Memory) Interface {
+				r, _ := newTestRetry(t, m, RetryOptions{})
+				return r
+			},
+			wantContentType: "text/plain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.wrap(t, NewMemory())
+
+			if err := SetWithOptions(ctx, s, "key", []byte("value"), opts); err != nil {
+				t.Fatalf("SetWithOptions() error = %v", err)
+			}
+
+			info, err := Stat(ctx, s, "key")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+
+			if info.Key != "key" {
+				t.Errorf("Stat() Key = %q, want %q", info.Key, "key")
+			}
+			if info.Size < 5 {
+				t.Errorf("Stat() Size = %d, want at least 5", info.Size)
+			}
+			if info.ContentType != tt.wantContentType {
+				t.Errorf("Stat() ContentType = %q, want %q", info.ContentType, tt.wantContentType)
+			}
+			if info.Metadata["source"] != "feed" {
+				t.Errorf("Stat() Metadata = %v, want source=feed", info.Metadata)
+			}
+
+			got, err := s.Get(ctx, "key")
+			if err != nil || string(got) != "value" {
+				t.Errorf("Get() = %q, %v, want %q", got, err, "value")
+			}
+
+			if _, err := Stat(ctx, s, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat() of missing key error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestJSON_Stat(t *testing.T) {
+	ctx := context.Background()
+	j := &JSON[string]{Underlying: NewMemory(), Prefix: "test"}
+
+	if err := j.SetWithOptions(ctx, "key", "value", SetOptions{Metadata: map[string]string{"source": "feed"}}); err != nil {
+		t.Fatalf("SetWithOptions() error = %v", err)
+	}
+
+	info, err := j.Stat(ctx, "key")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+
+	want := ObjectInfo{Key: "key", Size: int64(len(`"value"`)), ContentType: "application/json", Metadata: map[string]string{"source": "feed"}}
+	if info.Key != want.Key || info.Size != want.Size || info.ContentType != want.ContentType || info.Metadata["source"] != "feed" {
+		t.Errorf("Stat() = %+v, want %+v", info, want)
+	}
+}
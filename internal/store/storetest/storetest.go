@@ -0,0 +1,406 @@
+// Package storetest checks that a store.Interface implementation keeps the
+// contract the rest of the repo relies on. Drivers and decorators prove
+// themselves against it with one call to Run from their tests.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tigrisdata-community/glue/internal/store"
+)
+
+// LargeValueSize is the size of the value the large values test writes. It's
+// bigger than a single S3API multipart part.
+const LargeValueSize = 8<<20 + 1
+
+// Run runs the conformance suite against the stores made by newStore. Every
+// subtest gets its own store, which must start out empty.
+func Run(t *testing.T, newStore func(t *testing.T) store.Interface) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Interface)
+	}{
+		{"missing keys", testMissingKeys},
+		{"overwrite", testOverwrite},
+		{"prefix listing", testPrefixListing},
+		{"delimited listing", testDelimitedListing},
+		{"copy and move", testCopyAndMove},
+		{"empty key", testEmptyKey},
+		{"key characters", testKeyCharacters},
+		{"concurrent access", testConcurrentAccess},
+		{"large values", testLargeValues},
+		{"context cancellation", testContextCancellation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// mustSet writes a value and fails the test if it can't.
+func mustSet(t *testing.T, s store.Interface, key string, value []byte) {
+	t.Helper()
+
+	if err := s.Set(context.Background(), key, value); err != nil {
+		t.Fatalf("Set(%q) error = %v", key, err)
+	}
+}
+
+// checkValue fails the test unless key holds want.
+func checkValue(t *testing.T, s store.Interface, key string, want []byte) {
+	t.Helper()
+
+	got, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Errorf("Get(%q) error = %v", key, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Get(%q) = %q, want %q", key, truncate(got), truncate(want))
+	}
+}
+
+// listSorted lists prefix and sorts the keys, since List doesn't promise an
+// order.
+func listSorted(t *testing.T, s store.Interface, prefix string) []string {
+	t.Helper()
+
+	keys, err := s.List(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("List(%q) error = %v", prefix, err)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
+
+func truncate(b []byte) []byte {
+	if len(b) > 32 {
+		return b[:32]
+	}
+	return b
+}
+
+func testMissingKeys(t *testing.T, s store.Interface) {
+	ctx := context.Background()
+
+	if err := s.Exists(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Exists() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if err := s.Delete(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if keys := listSorted(t, s, ""); len(keys) != 0 {
+		t.Errorf("List() of an empty store = %v, want nothing", keys)
+	}
+
+	mustSet(t, s, "deleted", []byte("value"))
+	if err := s.Delete(ctx, "deleted"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "deleted"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if err := s.Exists(ctx, "deleted"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Exists() after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func testOverwrite(t *testing.T, s store.Interface) {
+	mustSet(t, s, "key", []byte("first value, which is longer"))
+	mustSet(t, s, "key", []byte("second"))
+	checkValue(t, s, "key", []byte("second"))
+
+	mustSet(t, s, "key", []byte{})
+	checkValue(t, s, "key", []byte{})
+
+	if keys := listSorted(t, s, ""); !slices.Equal(keys, []string{"key"}) {
+		t.Errorf("List() after overwriting = %v, want [key]", keys)
+	}
+}
+
+func testPrefixListing(t *testing.T, s store.Interface) {
+	for _, key := range []string{"foo/a", "foo/b", "foo/c/d", "foobar", "bar/a"} {
+		mustSet(t, s, key, []byte(key))
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"bar/a", "foo/a", "foo/b", "foo/c/d", "foobar"}},
+		{prefix: "foo/", want: []string{"foo/a", "foo/b", "foo/c/d"}},
+		{prefix: "foo", want: []string{"foo/a", "foo/b", "foo/c/d", "foobar"}},
+		{prefix: "foo/c/", want: []string{"foo/c/d"}},
+		{prefix: "foo/a", want: []string{"foo/a"}},
+		{prefix: "nope/", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := listSorted(t, s, tt.prefix); !slices.Equal(got, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+
+		var got []string
+		for key, err := range store.Iterate(context.Background(), s, tt.prefix, store.ListOptions{}) {
+			if err != nil {
+				t.Fatalf("Iterate(%q) error = %v", tt.prefix, err)
+			}
+			got = append(got, key)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Iterate(%q) = %v, want %v in order", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func testDelimitedListing(t *testing.T, s store.Interface) {
+	for _, key := range []string{"foo/a", "foo/b/c", "foo/b/d", "foobar", "bar/a"} {
+		mustSet(t, s, key, []byte(key))
+	}
+
+	tests := []struct {
+		prefix       string
+		wantKeys     []string
+		wantPrefixes []string
+	}{
+		{prefix: "", wantKeys: []string{"foobar"}, wantPrefixes: []string{"bar/", "foo/"}},
+		{prefix: "foo/", wantKeys: []string{"foo/a"}, wantPrefixes: []string{"foo/b/"}},
+		{prefix: "foo/b/", wantKeys: []string{"foo/b/c", "foo/b/d"}},
+		{prefix: "nope/"},
+	}
+
+	for _, tt := range tests {
+		got, err := store.ListDelimited(context.Background(), s, tt.prefix, "/")
+		if err != nil {
+			t.Fatalf("ListDelimited(%q) error = %v", tt.prefix, err)
+		}
+
+		if !slices.Equal(got.Keys, tt.wantKeys) || !slices.Equal(got.Prefixes, tt.wantPrefixes) {
+			t.Errorf("ListDelimited(%q) = %+v, want keys %v and prefixes %v in order", tt.prefix, got, tt.wantKeys, tt.wantPrefixes)
+		}
+	}
+}
+
+func testCopyAndMove(t *testing.T, s store.Interface) {
+	ctx := context.Background()
+
+	mustSet(t, s, "src/a", []byte("a"))
+	mustSet(t, s, "src/b/c", []byte("c"))
+	mustSet(t, s, "dst/a", []byte("overwritten"))
+
+	if err := store.Copy(ctx, s, "src/a", "dst/a"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	checkValue(t, s, "src/a", []byte("a"))
+	checkValue(t, s, "dst/a", []byte("a"))
+
+	if err := store.Move(ctx, s, "src/b/c", "moved/c"); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	checkValue(t, s, "moved/c", []byte("c"))
+	if err := s.Exists(ctx, "src/b/c"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Exists() after Move() error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	if err := store.Copy(ctx, s, "missing", "dst/missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Copy() of a missing key error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	n, err := store.MovePrefix(ctx, s, "dst/", "archive/", store.CopyOptions{})
+	if err != nil || n != 1 {
+		t.Fatalf("MovePrefix() = %d, %v, want 1, nil", n, err)
+	}
+	if got := listSorted(t, s, ""); !slices.Equal(got, []string{"archive/a", "moved/c", "src/a"}) {
+		t.Errorf("List() after MovePrefix() = %v, want [archive/a moved/c src/a]", got)
+	}
+}
+
+// testEmptyKey allows stores to refuse the empty key, as long as they refuse
+// it with ErrInvalidKey and don't half store it.
+func testEmptyKey(t *testing.T, s store.Interface) {
+	ctx := context.Background()
+
+	err := s.Set(ctx, "", []byte("empty"))
+	if err != nil {
+		if !errors.Is(err, store.ErrInvalidKey) {
+			t.Errorf("Set(\"\") error = %v, want nil or %v", err, store.ErrInvalidKey)
+		}
+		if _, err := s.Get(ctx, ""); err == nil {
+			t.Errorf("Get(\"\") succeeded after Set(\"\") failed")
+		}
+		if keys := listSorted(t, s, ""); len(keys) != 0 {
+			t.Errorf("List() after Set(\"\") failed = %q, want nothing", keys)
+		}
+		return
+	}
+
+	checkValue(t, s, "", []byte("empty"))
+	if err := s.Exists(ctx, ""); err != nil {
+		t.Errorf("Exists(\"\") error = %v", err)
+	}
+	if keys := listSorted(t, s, ""); !slices.Equal(keys, []string{""}) {
+		t.Errorf("List() = %q, want [\"\"]", keys)
+	}
+	if err := s.Delete(ctx, ""); err != nil {
+		t.Errorf("Delete(\"\") error = %v", err)
+	}
+}
+
+func testKeyCharacters(t *testing.T, s store.Interface) {
+	keys := []string{
+		"a/b/c/d",
+		"with space",
+		"percent%20encoded",
+		"plus+and&ampersand=equals?",
+		"日本語/キー",
+		"émoji-🙂",
+	}
+
+	for _, key := range keys {
+		mustSet(t, s, key, []byte(key))
+	}
+	for _, key := range keys {
+		checkValue(t, s, key, []byte(key))
+	}
+
+	want := slices.Sorted(slices.Values(keys))
+	if got := listSorted(t, s, ""); !slices.Equal(got, want) {
+		t.Errorf("List() = %q, want %q", got, want)
+	}
+	if got := listSorted(t, s, "日本語/"); !slices.Equal(got, []string{"日本語/キー"}) {
+		t.Errorf("List(\"日本語/\") = %q, want [日本語/キー]", got)
+	}
+}
+
+func testConcurrentAccess(t *testing.T, s store.Interface) {
+	ctx := context.Background()
+
+	const (
+		workers = 16
+		rounds  = 10
+	)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for r := range rounds {
+				own := fmt.Sprintf("worker/%d", w)
+				value := []byte(fmt.Sprintf("%d-%d", w, r))
+
+				if err := s.Set(ctx, own, value); err != nil {
+					t.Errorf("Set(%q) error = %v", own, err)
+					return
+				}
+				if got, err := s.Get(ctx, own); err != nil || !bytes.Equal(got, value) {
+					t.Errorf("Get(%q) = %q, %v, want %q", own, got, err, value)
+					return
+				}
+
+				if err := s.Set(ctx, "shared", value); err != nil {
+					t.Errorf("Set(shared) error = %v", err)
+					return
+				}
+				if _, err := s.List(ctx, "worker/"); err != nil {
+					t.Errorf("List() error = %v", err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := listSorted(t, s, "worker/"); len(got) != workers {
+		t.Errorf("List() found %d worker keys, want %d", len(got), workers)
+	}
+
+	// The shared key holds one worker's last write, not a mix of several.
+	got, err := s.Get(ctx, "shared")
+	if err != nil {
+		t.Fatalf("Get(shared) error = %v", err)
+	}
+	var w int
+	if _, err := fmt.Sscanf(string(got), fmt.Sprintf("%%d-%d", rounds-1), &w); err != nil || w < 0 || w >= workers {
+		t.Errorf("Get(shared) = %q, want one worker's last value", got)
+	}
+}
+
+func testLargeValues(t *testing.T, s store.Interface) {
+	value := make([]byte, LargeValueSize)
+	rand.Read(value)
+
+	mustSet(t, s, "large", value)
+
+	got, err := s.Get(context.Background(), "large")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("Get() returned %d bytes that don't match the %d written", len(got), len(value))
+	}
+}
+
+// testContextCancellation checks that operations on a done context return
+// promptly, and that any error they return says why. Stores that can finish
+// without blocking may ignore the context.
+func testContextCancellation(t *testing.T, s store.Interface) {
+	mustSet(t, s, "key", []byte("value"))
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for _, ctx := range []context.Context{canceled, expired} {
+		want := ctx.Err()
+
+		check := func(op string, err error) {
+			t.Helper()
+
+			if err != nil && !errors.Is(err, want) {
+				t.Errorf("%s with a done context error = %v, want nil or %v", op, err, want)
+			}
+			if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrUnavailable) {
+				t.Errorf("%s with a done context error = %v, which mustn't look like %v or %v", op, err, store.ErrNotFound, store.ErrUnavailable)
+			}
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			_, err := s.Get(ctx, "key")
+			check("Get()", err)
+			check("Exists()", s.Exists(ctx, "key"))
+			check("Set()", s.Set(ctx, "other", []byte("value")))
+			_, err = s.List(ctx, "")
+			check("List()", err)
+			for _, err := range store.Iterate(ctx, s, "", store.ListOptions{}) {
+				check("Iterate()", err)
+			}
+			check("Delete()", s.Delete(ctx, "other"))
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("operations with a done context (%v) didn't return within 10s", want)
+		}
+	}
+}
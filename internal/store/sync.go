@@ -0,0 +1,255 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+)
+
+// Divergence is how a key differs between the source and destination of Sync.
+type Divergence string
+
+const (
+	// DivergenceMissing is a key in the source that isn't in the destination.
+	DivergenceMissing Divergence = "missing"
+
+	// DivergenceDifferent is a key in both stores with different values.
+	DivergenceDifferent Divergence = "different"
+
+	// DivergenceExtra is a key in the destination that isn't in the source.
+	DivergenceExtra Divergence = "extra"
+)
+
+// SyncOptions configure Sync.
+type SyncOptions struct {
+	// Prefix limits Sync to the keys under it. Empty means every key.
+	Prefix string
+
+	// DryRun compares the stores without writing to the destination.
+	DryRun bool
+
+	// DeleteExtra deletes the keys in the destination that aren't in the
+	// source.
+	DeleteExtra bool
+
+	// BatchSize is how many keys are compared at once. Defaults to 1000.
+	BatchSize int
+
+	// OnDivergence is called for every key that differs, before it's
+	// repaired.
+	OnDivergence func(key string, d Divergence)
+
+	// Progress is called after each batch with the running totals.
+	Progress func(SyncReport)
+}
+
+// SyncReport counts what Sync found and did.
+type SyncReport struct {
+	// Checked is how many keys in the source were compared.
+	Checked int
+
+	// Missing, Different and Extra count the keys that diverged, by how.
+	Missing   int
+	Different int
+	Extra     int
+
+	// Copied is how many values were written to the destination, and Deleted
+	// how many extra keys were deleted from it.
+	Copied  int
+	Deleted int
+
+	// Skipped is how many diverged values were deleted or expired in the
+	// source before they could be copied.
+	Skipped int
+}
+
+// InSync reports whether Sync found the stores holding the same keys and
+// values.
+func (r SyncReport) InSync() bool {
+	return r.Missing == 0 && r.Different == 0 && r.Extra == 0
+}
+
+// Sync compares the values under opts.Prefix in src and dst, and copies every
+// value that is missing from dst or different there, so it can seed a new
+// store before it's mirrored to or check a mirror afterwards. Stores of any
+// driver can be synced. Values are copied with their content type, metadata
+// and remaining TTL where both stores support them, but not their public
+// access.
+//
+// Both stores are listed in order side by side, and values are compared a
+// batch at a time. Sync returns the report so far along with the first error.
+func Sync(ctx context.Context, src, dst Interface, opts SyncOptions) (SyncReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = copyBatchSize
+	}
+
+	var (
+		report SyncReport
+		batch  = make([]string, 0, opts.BatchSize)
+		extra  []string
+	)
+
+	diverged := func(key string, d Divergence) {
+		switch d {
+		case DivergenceMissing:
+			report.Missing++
+		case DivergenceDifferent:
+			report.Different++
+		case DivergenceExtra:
+			report.Extra++
+			extra = append(extra, key)
+		}
+
+		if opts.OnDivergence != nil {
+			opts.OnDivergence(key, d)
+		}
+	}
+
+	flush := func() error {
+		if err := syncBatch(ctx, src, dst, batch, opts, &report, diverged); err != nil {
+			return err
+		}
+		batch = batch[:0]
+
+		if opts.DeleteExtra && !opts.DryRun && len(extra) > 0 {
+			if err := DeleteMany(ctx, dst, extra); err != nil {
+				return fmt.Errorf("can't delete extra keys: %w", err)
+			}
+			report.Deleted += len(extra)
+		}
+		extra = extra[:0]
+
+		if opts.Progress != nil {
+			opts.Progress(report)
+		}
+		return nil
+	}
+
+	nextDst, stop := iter.Pull2(Iterate(ctx, dst, opts.Prefix, ListOptions{}))
+	defer stop()
+
+	dstKey, err, more := nextDst()
+
+	for key, serr := range Iterate(ctx, src, opts.Prefix, ListOptions{}) {
+		if serr != nil {
+			return report, fmt.Errorf("can't list source: %w", serr)
+		}
+
+		for ; more && err == nil && dstKey < key; dstKey, err, more = nextDst() {
+			diverged(dstKey, DivergenceExtra)
+		}
+		if err != nil {
+			return report, fmt.Errorf("can't list destination: %w", err)
+		}
+		if more && dstKey == key {
+			dstKey, err, more = nextDst()
+		}
+
+		batch = append(batch, key)
+		if len(batch) < opts.BatchSize {
+			continue
+		}
+
+		if err := flush(); err != nil {
+			return report, err
+		}
+	}
+
+	for ; more && err == nil; dstKey, err, more = nextDst() {
+		diverged(dstKey, DivergenceExtra)
+	}
+	if err != nil {
+		return report, fmt.Errorf("can't list destination: %w", err)
+	}
+
+	if len(batch) > 0 || len(extra) > 0 {
+		if err := flush(); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// syncBatch compares the values of keys in src and dst and, unless it's a dry
+// run, copies the ones that diverged.
+func syncBatch(ctx context.Context, src, dst Interface, keys []string, opts SyncOptions, report *SyncReport, diverged func(string, Divergence)) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	srcValues, err := GetMany(ctx, src, keys)
+	if err != nil {
+		return fmt.Errorf("can't read source: %w", err)
+	}
+	dstValues, err := GetMany(ctx, dst, keys)
+	if err != nil {
+		return fmt.Errorf("can't read destination: %w", err)
+	}
+
+	var stale []string
+	for _, key := range keys {
+		value, ok := srcValues[key]
+		if !ok {
+			// Deleted or expired since it was listed.
+			continue
+		}
+		report.Checked++
+
+		current, ok := dstValues[key]
+		switch {
+		case !ok:
+			diverged(key, DivergenceMissing)
+		case !bytes.Equal(value, current):
+			diverged(key, DivergenceDifferent)
+		default:
+			continue
+		}
+
+		stale = append(stale, key)
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	var lock sync.Mutex
+	err = each(ctx, stale, func(key string) error {
+		err := syncValue(ctx, src, dst, key, srcValues[key])
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch {
+		case errors.Is(err, errSyncSourceGone):
+			report.Skipped++
+		case err != nil:
+			return fmt.Errorf("can't copy %s: %w", key, err)
+		default:
+			report.Copied++
+		}
+		return nil
+	})
+	return err
+}
+
+// errSyncSourceGone is returned by syncValue when the value was deleted or
+// expired in the source since it was read.
+var errSyncSourceGone = errors.New("source value is gone")
+
+// syncValue writes value to key in dst with the content type, metadata and
+// remaining TTL it has in src.
+func syncValue(ctx context.Context, src, dst Interface, key string, value []byte) error {
+	opts, err := storedOptions(ctx, src, key)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: %w", errSyncSourceGone, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return SetWithOptions(ctx, dst, key, value, opts)
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		opts           SyncOptions
+		want           SyncReport
+		wantDst        map[string]string
+		wantDivergence []string
+	}{
+		{
+			name:           "dry run only compares",
+			opts:           SyncOptions{DryRun: true},
+			want:           SyncReport{Checked: 4, Missing: 2, Different: 1, Extra: 2},
+			wantDst:        map[string]string{"a": "a", "b": "stale", "c/0": "extra", "z": "extra"},
+			wantDivergence: []string{"b different", "c/0 extra", "c/1 missing", "d missing", "z extra"},
+		},
+		{
+			name:           "copies missing and different values",
+			want:           SyncReport{Checked: 4, Missing: 2, Different: 1, Extra: 2, Copied: 3},
+			wantDst:        map[string]string{"a": "a", "b": "b", "c/0": "extra", "c/1": "c/1", "d": "d", "z": "extra"},
+			wantDivergence: []string{"b different", "c/0 extra", "c/1 missing", "d missing", "z extra"},
+		},
+		{
+			name:           "deletes extra keys",
+			opts:           SyncOptions{DeleteExtra: true, BatchSize: 2},
+			want:           SyncReport{Checked: 4, Missing: 2, Different: 1, Extra: 2, Copied: 3, Deleted: 2},
+			wantDst:        map[string]string{"a": "a", "b": "b", "c/1": "c/1", "d": "d"},
+			wantDivergence: []string{"b different", "c/0 extra", "c/1 missing", "d missing", "z extra"},
+		},
+		{
+			name:           "limits itself to a prefix",
+			opts:           SyncOptions{Prefix: "c/", DeleteExtra: true},
+			want:           SyncReport{Checked: 1, Missing: 1, Extra: 1, Copied: 1, Deleted: 1},
+			wantDst:        map[string]string{"a": "a", "b": "stale", "c/1": "c/1", "z": "extra"},
+			wantDivergence: []string{"c/0 extra", "c/1 missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := NewMemory()
+			for _, key := range []string{"a", "b", "c/1", "d"} {
+				src.Set(ctx, key, []byte(key))
+			}
+
+			dst := newTestDirectory(t, map[string][]byte{
+				"a":   []byte("a"),
+				"b":   []byte("stale"),
+				"c/0": []byte("extra"),
+				"z":   []byte("extra"),
+			})
+
+			var divergence []string
+			tt.opts.OnDivergence = func(key string, d Divergence) {
+				divergence = append(divergence, fmt.Sprintf("%s %s", key, d))
+			}
+
+			got, err := Sync(ctx, src, dst, tt.opts)
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Sync() = %+v, want %+v", got, tt.want)
+			}
+
+			slices.Sort(divergence)
+			if !slices.Equal(divergence, tt.wantDivergence) {
+				t.Errorf("divergence = %v, want %v", divergence, tt.wantDivergence)
+			}
+
+			if contents := storeContents(t, dst); !maps.Equal(contents, tt.wantDst) {
+				t.Errorf("destination holds %v, want %v", contents, tt.wantDst)
+			}
+
+			again, err := Sync(ctx, src, dst, SyncOptions{Prefix: tt.opts.Prefix, DryRun: true})
+			if err != nil {
+				t.Fatalf("Sync() again error = %v", err)
+			}
+			if wantInSync := !tt.opts.DryRun && (tt.opts.DeleteExtra || tt.want.Extra == 0); again.InSync() != wantInSync {
+				t.Errorf("Sync() again = %+v, InSync() = %v, want %v", again, again.InSync(), wantInSync)
+			}
+		})
+	}
+}
+
+func TestSync_KeepsOptions(t *testing.T) {
+	ctx := context.Background()
+
+	src := NewMemory()
+	opts := SetOptions{TTL: time.Hour, ContentType: "text/plain", Metadata: map[string]string{"source": "test"}}
+	if err := SetWithOptions(ctx, src, "key", []byte("value"), opts); err != nil {
+		t.Fatalf("SetWithOptions() error = %v", err)
+	}
+
+	dst, _ := newTestS3API(t)
+	if _, err := Sync(ctx, src, dst, SyncOptions{}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	info, err := Stat(ctx, dst, "key")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.ContentType != "text/plain" || !maps.Equal(info.Metadata, opts.Metadata) {
+		t.Errorf("Stat() = %+v, want the source's content type and metadata", info)
+	}
+	if until := time.Until(info.Expires); until <= 0 || until > time.Hour {
+		t.Errorf("Stat().Expires is %v from now, want within the source's hour", until)
+	}
+}
+
+func TestSync_Progress(t *testing.T) {
+	ctx := context.Background()
+
+	src := NewMemory()
+	for i := range 5 {
+		src.Set(ctx, fmt.Sprintf("key/%d", i), []byte("value"))
+	}
+
+	var checked []int
+	_, err := Sync(ctx, src, NewMemory(), SyncOptions{
+		BatchSize: 2,
+		Progress:  func(r SyncReport) { checked = append(checked, r.Checked) },
+	})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if want := []int{2, 4, 5}; !slices.Equal(checked, want) {
+		t.Errorf("progress checked = %v, want %v", checked, want)
+	}
+}
+
+func TestSync_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	src := NewMemory()
+	src.Set(ctx, "a", []byte("a"))
+	src.Set(ctx, "secret", []byte("secret"))
+
+	dst := &permissionStore{Memory: NewMemory(), denied: map[string]bool{"secret": true}}
+
+	report, err := Sync(ctx, src, dst, SyncOptions{})
+	if !errors.Is(err, ErrPermission) {
+		t.Fatalf("Sync() error = %v, want %v", err, ErrPermission)
+	}
+	if report.Checked != 0 {
+		t.Errorf("Sync() checked %d keys, want none after the destination couldn't be read", report.Checked)
+	}
+}
+
+// vanishingStore deletes a value as soon as it's described, like a value that
+// is deleted just after it's read.
+type vanishingStore struct {
+	*Memory
+}
+
+func (v *vanishingStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	v.Memory.Delete(ctx, key)
+	return v.Memory.Stat(ctx, key)
+}
+
+func TestSync_SourceGone(t *testing.T) {
+	ctx := context.Background()
+
+	src := &vanishingStore{Memory: NewMemory()}
+	src.Set(ctx, "key", []byte("value"))
+	dst := NewMemory()
+
+	report, err := Sync(ctx, src, dst, SyncOptions{})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if want := (SyncReport{Checked: 1, Missing: 1, Skipped: 1}); report != want {
+		t.Errorf("Sync() = %+v, want %+v", report, want)
+	}
+	if err := dst.Exists(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() error = %v, want nothing copied", err)
+	}
+}
@@ -0,0 +1,294 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/tigrisdata-community/glue/internal/store"
+
+// NewTraced wraps underlying so that every operation creates an OpenTelemetry
+// span labeled with driver. Spans go to the global tracer provider, so this
+// costs next to nothing until tracing is set up.
+func NewTraced(underlying Interface, driver string) *Traced {
+	return &Traced{
+		underlying: underlying,
+		driver:     driver,
+		tracer:     otel.Tracer(tracerName),
+	}
+}
+
+// Traced is a store.Interface that creates a span for each operation on
+// another store, with the key or prefix it was called with.
+type Traced struct {
+	underlying Interface
+	driver     string
+	tracer     trace.Tracer
+}
+
+func (t *Traced) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("store.driver", t.driver))
+	return t.tracer.Start(ctx, "store."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// end finishes span, recording err if there was one. Missing keys are
+// expected often enough that they aren't marked as errors.
+func end(span trace.Span, err error) {
+	defer span.End()
+
+	if err == nil {
+		return
+	}
+
+	span.SetAttributes(attribute.String("store.error_class", errorClass(err)))
+	if errors.Is(err, ErrNotFound) {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func keyAttr(key string) attribute.KeyValue {
+	return attribute.String("store.key", key)
+}
+
+func prefixAttr(prefix string) attribute.KeyValue {
+	return attribute.String("store.prefix", prefix)
+}
+
+func (t *Traced) Delete(ctx context.Context, key string) error {
+	ctx, span := t.start(ctx, "Delete", keyAttr(key))
+	err := t.underlying.Delete(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) Exists(ctx context.Context, key string) error {
+	ctx, span := t.start(ctx, "Exists", keyAttr(key))
+	err := t.underlying.Exists(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, span := t.start(ctx, "Get", keyAttr(key))
+	value, err := t.underlying.Get(ctx, key)
+	span.SetAttributes(attribute.Int("store.value_size", len(value)))
+	end(span, err)
+	return value, err
+}
+
+func (t *Traced) Set(ctx context.Context, key string, value []byte) error {
+	ctx, span := t.start(ctx, "Set", keyAttr(key), attribute.Int("store.value_size", len(value)))
+	err := t.underlying.Set(ctx, key, value)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ctx, span := t.start(ctx, "SetWithTTL", keyAttr(key), attribute.Int("store.value_size", len(value)), attribute.String("store.ttl", ttl.String()))
+	err := SetWithTTL(ctx, t.underlying, key, value, ttl)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	ctx, span := t.start(ctx, "GetVersion", keyAttr(key))
+	value, version, err := GetVersion(ctx, t.underlying, key)
+	end(span, err)
+	return value, version, err
+}
+
+func (t *Traced) SetIf(ctx context.Context, key string, value []byte, cond Condition) (string, error) {
+	cs, ok := t.underlying.(ConditionalSetter)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support conditional writes", errors.ErrUnsupported, t.underlying)
+	}
+
+	ctx, span := t.start(ctx, "SetIf", keyAttr(key),
+		attribute.Bool("store.if_not_exists", cond.IfNotExists),
+		attribute.String("store.if_version", cond.IfVersion),
+	)
+	version, err := cs.SetIf(ctx, key, value, cond)
+	end(span, err)
+	return version, err
+}
+
+// GetReader's span covers opening a value, not reading it.
+func (t *Traced) GetReader(ctx context.Context, key string) (io.ReadCloser, StreamInfo, error) {
+	ctx, span := t.start(ctx, "GetReader", keyAttr(key))
+	rc, info, err := GetReader(ctx, t.underlying, key)
+	span.SetAttributes(attribute.Int64("store.content_length", info.ContentLength))
+	end(span, err)
+	return rc, info, err
+}
+
+func (t *Traced) SetReader(ctx context.Context, key string, r io.Reader, info StreamInfo) error {
+	ctx, span := t.start(ctx, "SetReader", keyAttr(key), attribute.Int64("store.content_length", info.ContentLength))
+	err := SetReader(ctx, t.underlying, key, r, info)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) List(ctx context.Context, prefix string) ([]string, error) {
+	ctx, span := t.start(ctx, "List", prefixAttr(prefix))
+	keys, err := t.underlying.List(ctx, prefix)
+	span.SetAttributes(attribute.Int("store.keys", len(keys)))
+	end(span, err)
+	return keys, err
+}
+
+// Iterate's span covers walking the whole listing, including the time spent by
+// the caller between keys.
+func (t *Traced) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		ctx, span := t.start(ctx, "Iterate", prefixAttr(prefix), attribute.String("store.start_after", opts.StartAfter), attribute.Int("store.limit", opts.Limit))
+
+		var (
+			err  error
+			keys int
+		)
+
+		defer func() {
+			span.SetAttributes(attribute.Int("store.keys", keys))
+			end(span, err)
+		}()
+
+		for key, ierr := range Iterate(ctx, t.underlying, prefix, opts) {
+			if ierr != nil {
+				err = ierr
+				yield("", ierr)
+				return
+			}
+
+			keys++
+			if !yield(key, nil) {
+				return
+			}
+		}
+	}
+}
+
+// IterateDelimited's span covers walking the whole listing, including the
+// time spent by the caller between entries.
+func (t *Traced) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		ctx, span := t.start(ctx, "IterateDelimited", prefixAttr(prefix), attribute.String("store.delimiter", delimiter), attribute.String("store.start_after", opts.StartAfter), attribute.Int("store.limit", opts.Limit))
+
+		var (
+			err     error
+			entries int
+		)
+
+		defer func() {
+			span.SetAttributes(attribute.Int("store.entries", entries))
+			end(span, err)
+		}()
+
+		for entry, ierr := range IterateDelimited(ctx, t.underlying, prefix, delimiter, opts) {
+			if ierr != nil {
+				err = ierr
+				yield(Entry{}, ierr)
+				return
+			}
+
+			entries++
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (t *Traced) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	ctx, span := t.start(ctx, "GetMany", attribute.Int("store.keys", len(keys)))
+	values, err := GetMany(ctx, t.underlying, keys)
+	span.SetAttributes(attribute.Int("store.found", len(values)))
+	end(span, err)
+	return values, err
+}
+
+func (t *Traced) SetMany(ctx context.Context, values map[string][]byte) error {
+	ctx, span := t.start(ctx, "SetMany", attribute.Int("store.keys", len(values)))
+	err := SetMany(ctx, t.underlying, values)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) DeleteMany(ctx context.Context, keys []string) error {
+	ctx, span := t.start(ctx, "DeleteMany", attribute.Int("store.keys", len(keys)))
+	err := DeleteMany(ctx, t.underlying, keys)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	ctx, span := t.start(ctx, "DeletePrefix", prefixAttr(prefix))
+	deleted, err := DeletePrefix(ctx, t.underlying, prefix)
+	span.SetAttributes(attribute.Int("store.keys", deleted))
+	end(span, err)
+	return deleted, err
+}
+
+func (t *Traced) Copy(ctx context.Context, src, dst string) error {
+	ctx, span := t.start(ctx, "Copy", keyAttr(src), attribute.String("store.destination", dst))
+	err := Copy(ctx, t.underlying, src, dst)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) Move(ctx context.Context, src, dst string) error {
+	ctx, span := t.start(ctx, "Move", keyAttr(src), attribute.String("store.destination", dst))
+	err := Move(ctx, t.underlying, src, dst)
+	end(span, err)
+	return err
+}
+
+func (t *Traced) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	ctx, span := t.start(ctx, "Stat", keyAttr(key))
+	info, err := Stat(ctx, t.underlying, key)
+	span.SetAttributes(attribute.Int64("store.value_size", info.Size))
+	end(span, err)
+	return info, err
+}
+
+// SetWithOptions records the content type and how many metadata entries there
+// are, but not the metadata itself.
+func (t *Traced) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	ctx, span := t.start(ctx, "SetWithOptions", keyAttr(key),
+		attribute.Int("store.value_size", len(value)),
+		attribute.String("store.ttl", opts.TTL.String()),
+		attribute.String("store.content_type", opts.ContentType),
+		attribute.Int("store.metadata", len(opts.Metadata)),
+	)
+	err := SetWithOptions(ctx, t.underlying, key, value, opts)
+	end(span, err)
+	return err
+}
+
+// PublicURL's span doesn't record the URL, only the key.
+func (t *Traced) PublicURL(ctx context.Context, key string) (string, error) {
+	ctx, span := t.start(ctx, "PublicURL", keyAttr(key))
+	u, err := PublicURL(ctx, t.underlying, key)
+	end(span, err)
+	return u, err
+}
+
+// PresignedURL's span doesn't record the URL, since anyone holding it can
+// read the value.
+func (t *Traced) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	ctx, span := t.start(ctx, "PresignedURL", keyAttr(key), attribute.String("store.ttl", ttl.String()))
+	u, err := PresignedURL(ctx, t.underlying, key, ttl)
+	end(span, err)
+	return u, err
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestTraced(t *testing.T, underlying Interface) (*Traced, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	tr := NewTraced(underlying, "memory")
+	tr.tracer = tp.Tracer(tracerName)
+
+	return tr, exp
+}
+
+func spanAttr(span tracetest.SpanStub, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTraced(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		call       func(s Interface) error
+		wantSpan   string
+		wantAttrs  map[string]string
+		wantStatus codes.Code
+	}{
+		{
+			name:      "set",
+			call:      func(s Interface) error { return s.Set(ctx, "things/a", []byte("value")) },
+			wantSpan:  "store.Set",
+			wantAttrs: map[string]string{"store.key": "things/a", "store.driver": "memory"},
+		},
+		{
+			name:      "missing key isn't an error",
+			call:      func(s Interface) error { _, err := s.Get(ctx, "missing"); return err },
+			wantSpan:  "store.Get",
+			wantAttrs: map[string]string{"store.key": "missing", "store.error_class": "not_found"},
+		},
+		{
+			name:       "conflict",
+			call:       func(s Interface) error { return Create(ctx, s, "existing", []byte("value")) },
+			wantSpan:   "store.SetIf",
+			wantAttrs:  map[string]string{"store.key": "existing", "store.error_class": "conflict"},
+			wantStatus: codes.Error,
+		},
+		{
+			name: "iterate",
+			call: func(s Interface) error {
+				for _, err := range Iterate(ctx, s, "exist", ListOptions{}) {
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantSpan:  "store.Iterate",
+			wantAttrs: map[string]string{"store.prefix": "exist"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			if err := m.Set(ctx, "existing", []byte("value")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			tr, exp := newTestTraced(t, m)
+			tt.call(tr)
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+
+			if span.Name != tt.wantSpan {
+				t.Errorf("span name = %q, want %q", span.Name, tt.wantSpan)
+			}
+
+			for key, want := range tt.wantAttrs {
+				if got, ok := spanAttr(span, key); !ok || got.Emit() != want {
+					t.Errorf("span attribute %s = %q, want %q", key, got.Emit(), want)
+				}
+			}
+
+			if span.Status.Code != tt.wantStatus {
+				t.Errorf("span status = %v, want %v", span.Status.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestTraced_Iterate_CountsKeys(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := m.Set(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	tr, exp := newTestTraced(t, m)
+
+	for range tr.Iterate(ctx, "", ListOptions{}) {
+		break
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+
+	if got, _ := spanAttr(spans[0], "store.keys"); got.AsInt64() != 1 {
+		t.Errorf("store.keys = %d after stopping early, want 1", got.AsInt64())
+	}
+}
@@ -0,0 +1,472 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"time"
+)
+
+// Typed stores values of type T in an underlying store, encoding them with a
+// Codec and optionally compressing them. Keys are scoped under Prefix if it is
+// set.
+//
+// When Codec and Compression are both unset, values are written as plain JSON
+// (see JSON). Otherwise each value starts with a short header naming its codec
+// and compression, so a Typed store can read values written with different
+// settings, including plain JSON, and settings can change without rewriting
+// existing values.
+type Typed[T any] struct {
+	Underlying Interface
+	Prefix     string
+
+	// Codec encodes values. Defaults to JSONCodec.
+	Codec Codec
+
+	// Compression compresses encoded values. Defaults to CompressionNone.
+	Compression Compression
+}
+
+// plain reports whether values are written as plain JSON without a header.
+func (t *Typed[T]) plain() bool {
+	return t.Codec == nil && t.Compression == CompressionNone
+}
+
+func (t *Typed[T]) contentType() string {
+	if t.plain() {
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+// encode writes value to w, preceded by a header unless it's plain JSON.
+func (t *Typed[T]) encode(w io.Writer, value T) error {
+	if t.plain() {
+		if err := JSONCodec.Encode(w, value); err != nil {
+			return fmt.Errorf("%w: %w", ErrCantEncode, err)
+		}
+		return nil
+	}
+
+	codec := t.Codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	cw, err := compressWriter(w, t.Compression)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCantEncode, err)
+	}
+
+	// Compressors don't write anything until they're given data, so the header
+	// still comes first.
+	if _, err := w.Write(typedHeader(codec, t.Compression)); err != nil {
+		return err
+	}
+
+	if err := codec.Encode(cw, value); err != nil {
+		cw.Close()
+		return fmt.Errorf("%w: %w", ErrCantEncode, err)
+	}
+
+	return cw.Close()
+}
+
+// decode reads a value from r with the codec and compression named in its
+// header.
+func (t *Typed[T]) decode(r io.Reader) (T, error) {
+	br := bufio.NewReader(r)
+
+	codec, compression, err := readTypedHeader(br, t.Codec)
+	if err != nil {
+		return z[T](), fmt.Errorf("%w: %w", ErrCantDecode, err)
+	}
+
+	rc, err := decompressReader(br, compression)
+	if err != nil {
+		return z[T](), fmt.Errorf("%w: %w", ErrCantDecode, err)
+	}
+	defer rc.Close()
+
+	var result T
+	if err := codec.Decode(rc, &result); err != nil {
+		return z[T](), fmt.Errorf("%w: %w", ErrCantDecode, err)
+	}
+
+	return result, nil
+}
+
+func (t *Typed[T]) marshal(value T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.encode(&buf, value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (t *Typed[T]) Delete(ctx context.Context, key string) error {
+	if t.Prefix != "" {
+		key = t.Prefix + "/" + key
+	}
+
+	return t.Underlying.Delete(ctx, key)
+}
+
+func (t *Typed[T]) Exists(ctx context.Context, key string) error {
+	if t.Prefix != "" {
+		key = t.Prefix + "/" + key
+	}
+
+	return t.Underlying.Exists(ctx, key)
+}
+
+func (t *Typed[T]) Get(ctx context.Context, key string) (T, error) {
+	if t.Prefix != "" {
+		key = t.Prefix + "/" + key
+	}
+
+	if st, ok := t.Underlying.(Streamer); ok {
+		return t.getStream(ctx, st, key)
+	}
+
+	data, err := t.Underlying.Get(ctx, key)
+	if err != nil {
+		return z[T](), err
+	}
+
+	return t.decode(bytes.NewReader(data))
+}
+
+// getStream decodes a value straight from the underlying store so that large
+// values never have to be buffered in full.
+func (t *Typed[T]) getStream(ctx context.Context, st Streamer, key string) (T, error) {
+	rc, _, err := st.GetReader(ctx, key)
+	if err != nil {
+		return z[T](), err
+	}
+	defer rc.Close()
+
+	return t.decode(rc)
+}
+
+func (t *Typed[T]) Set(ctx context.Context, key string, value T) error {
+	if t.Prefix != "" {
+		key = t.Prefix + "/" + key
+	}
+
+	data, err := t.marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return SetWithOptions(ctx, t.Underlying, key, data, SetOptions{ContentType: t.contentType()})
+}
+
+// SetStream encodes a value straight into the underlying store so that large
+// values never have to be buffered in full. The length isn't known up front,
+// so stores can't retry or cache the write and may buffer it in parts; use Set
+// for values that comfortably fit in memory.
+func (t *Typed[T]) SetStream(ctx context.Context, key string, value T) error {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(t.encode(pw, value))
+	}()
+
+	err := SetReader(ctx, t.Underlying, t.key(key), pr, StreamInfo{ContentLength: -1, ContentType: t.contentType()})
+
+	// Unblock the encoder if the store stopped reading early.
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	return err
+}
+
+// SetWithTTL encodes a value and puts it into the underlying store so that it
+// expires after ttl, with the same content type as Set.
+func (t *Typed[T]) SetWithTTL(ctx context.Context, key string, value T, ttl time.Duration) error {
+	return t.SetWithOptions(ctx, key, value, SetOptions{TTL: ttl})
+}
+
+// GetVersion decodes a value along with its version for use with
+// CompareAndSwap.
+func (t *Typed[T]) GetVersion(ctx context.Context, key string) (T, string, error) {
+	if t.Prefix != "" {
+		key = t.Prefix + "/" + key
+	}
+
+	data, version, err := GetVersion(ctx, t.Underlying, key)
+	if err != nil {
+		return z[T](), "", err
+	}
+
+	result, err := t.decode(bytes.NewReader(data))
+	if err != nil {
+		return z[T](), "", err
+	}
+
+	return result, version, nil
+}
+
+// Create encodes a value and puts it into the underlying store only if key
+// doesn't exist yet. It returns ErrConflict if it does.
+func (t *Typed[T]) Create(ctx context.Context, key string, value T) error {
+	if t.Prefix != "" {
+		key = t.Prefix + "/" + key
+	}
+
+	data, err := t.marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return Create(ctx, t.Underlying, key, data)
+}
+
+// CompareAndSwap encodes a value and replaces the stored one only if its
+// version is still version. It returns ErrConflict if the value changed, or
+// ErrNotFound if it was deleted.
+func (t *Typed[T]) CompareAndSwap(ctx context.Context, key, version string, value T) (string, error) {
+	if t.Prefix != "" {
+		key = t.Prefix + "/" + key
+	}
+
+	data, err := t.marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return CompareAndSwap(ctx, t.Underlying, key, version, data)
+}
+
+func (t *Typed[T]) List(ctx context.Context, prefix string) ([]string, error) {
+	fullPrefix := t.key(prefix)
+	keys, err := t.Underlying.List(ctx, fullPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip the full prefix from each key.
+	result := make([]string, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, strings.TrimPrefix(k, fullPrefix))
+	}
+
+	return result, nil
+}
+
+// Iterate streams the keys under this store's prefix with the prefix removed.
+// opts.StartAfter is relative to the full prefix, the same as the yielded keys.
+func (t *Typed[T]) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	fullPrefix := t.key(prefix)
+	if opts.StartAfter != "" {
+		opts.StartAfter = fullPrefix + opts.StartAfter
+	}
+
+	return func(yield func(string, error) bool) {
+		for key, err := range Iterate(ctx, t.Underlying, fullPrefix, opts) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			if !yield(strings.TrimPrefix(key, fullPrefix), nil) {
+				return
+			}
+		}
+	}
+}
+
+// IterateDelimited streams one level of the keys under this store's prefix
+// with the full prefix removed, collapsing the keys nested deeper into common
+// prefixes (see DelimitedIterator). opts.StartAfter is relative to the full
+// prefix, the same as the yielded names.
+func (t *Typed[T]) IterateDelimited(ctx context.Context, prefix, delimiter string, opts ListOptions) iter.Seq2[Entry, error] {
+	fullPrefix := t.key(prefix)
+	if opts.StartAfter != "" {
+		opts.StartAfter = fullPrefix + opts.StartAfter
+	}
+
+	return func(yield func(Entry, error) bool) {
+		for entry, err := range IterateDelimited(ctx, t.Underlying, fullPrefix, delimiter, opts) {
+			if err != nil {
+				yield(Entry{}, err)
+				return
+			}
+
+			entry.Name = strings.TrimPrefix(entry.Name, fullPrefix)
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ListDelimited lists one level of the keys under this store's prefix with
+// the full prefix removed, eg: ListDelimited(ctx, "", "/") on the
+// discourse-thread store lists the threads kept at its top level and the
+// categories nested under it as "tigris/".
+func (t *Typed[T]) ListDelimited(ctx context.Context, prefix, delimiter string) (Listing, error) {
+	var result Listing
+
+	for entry, err := range t.IterateDelimited(ctx, prefix, delimiter, ListOptions{}) {
+		if err != nil {
+			return Listing{}, err
+		}
+
+		if entry.IsPrefix {
+			result.Prefixes = append(result.Prefixes, entry.Name)
+		} else {
+			result.Keys = append(result.Keys, entry.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// Keyspace returns the keyspace this store's values are kept in.
+func (t *Typed[T]) Keyspace() Keyspace {
+	return NewKeyspace(t.Prefix)
+}
+
+// Sub returns a store for the same type of values, kept in the keyspace
+// nested under this one by parts, eg: threads.Sub("tigris") keeps its values
+// under discourse-thread/tigris.
+func (t *Typed[T]) Sub(parts ...string) *Typed[T] {
+	sub := *t
+	sub.Prefix = t.Keyspace().Sub(parts...).String()
+	return &sub
+}
+
+// key returns where key is kept in the underlying store.
+func (t *Typed[T]) key(key string) string {
+	if t.Prefix == "" {
+		return key
+	}
+	return t.Prefix + "/" + key
+}
+
+// GetMany decodes the values of the keys that exist. Keys that don't exist are
+// left out of the result. Keys whose values can't be decoded are left out too,
+// and the rest are returned along with KeyErrors saying why; any other error
+// returns no values.
+func (t *Typed[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	full := make([]string, len(keys))
+	for i, key := range keys {
+		full[i] = t.key(key)
+	}
+
+	values, err := GetMany(ctx, t.Underlying, full)
+	var underlyingErrs KeyErrors
+	if err != nil && !errors.As(err, &underlyingErrs) {
+		return nil, err
+	}
+
+	result := make(map[string]T, len(values))
+	errs := KeyErrors{}
+
+	for _, key := range keys {
+		if err, ok := underlyingErrs[t.key(key)]; ok {
+			errs[key] = err
+			continue
+		}
+
+		data, ok := values[t.key(key)]
+		if !ok {
+			continue
+		}
+
+		value, err := t.decode(bytes.NewReader(data))
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		result[key] = value
+	}
+
+	if len(errs) != 0 {
+		return result, errs
+	}
+
+	return result, nil
+}
+
+// SetMany encodes every value and puts them into the underlying store in one
+// batch.
+func (t *Typed[T]) SetMany(ctx context.Context, values map[string]T) error {
+	encoded := make(map[string][]byte, len(values))
+	for key, value := range values {
+		data, err := t.marshal(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		encoded[t.key(key)] = data
+	}
+
+	return SetMany(ctx, t.Underlying, encoded)
+}
+
+func (t *Typed[T]) DeleteMany(ctx context.Context, keys []string) error {
+	full := make([]string, len(keys))
+	for i, key := range keys {
+		full[i] = t.key(key)
+	}
+
+	return DeleteMany(ctx, t.Underlying, full)
+}
+
+// DeletePrefix removes every value under this store's prefix matching prefix.
+// If Prefix is empty, an empty prefix removes every key in the underlying
+// store.
+func (t *Typed[T]) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	return DeletePrefix(ctx, t.Underlying, t.key(prefix))
+}
+
+// Copy copies the stored value of src to dst without decoding it.
+func (t *Typed[T]) Copy(ctx context.Context, src, dst string) error {
+	return Copy(ctx, t.Underlying, t.key(src), t.key(dst))
+}
+
+// Move moves the stored value of src to dst without decoding it.
+func (t *Typed[T]) Move(ctx context.Context, src, dst string) error {
+	return Move(ctx, t.Underlying, t.key(src), t.key(dst))
+}
+
+// Stat describes the encoded form of a value.
+func (t *Typed[T]) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := Stat(ctx, t.Underlying, t.key(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info.Key = key
+	return info, nil
+}
+
+// SetWithOptions encodes a value and puts it into the underlying store with
+// opts. The content type defaults to the one Set would use.
+func (t *Typed[T]) SetWithOptions(ctx context.Context, key string, value T, opts SetOptions) error {
+	data, err := t.marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if opts.ContentType == "" {
+		opts.ContentType = t.contentType()
+	}
+
+	return SetWithOptions(ctx, t.Underlying, t.key(key), data, opts)
+}
+
+func (t *Typed[T]) PublicURL(ctx context.Context, key string) (string, error) {
+	return PublicURL(ctx, t.Underlying, t.key(key))
+}
+
+func (t *Typed[T]) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return PresignedURL(ctx, t.Underlying, t.key(key), ttl)
+}
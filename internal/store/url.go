@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Linker is implemented by stores that can hand out URLs for reading values
+// without going through the store.
+type Linker interface {
+	// PublicURL returns the URL a value can be read from without credentials
+	// if it was written with SetOptions.Public. It doesn't check that the value
+	// exists or is public.
+	PublicURL(ctx context.Context, key string) (string, error)
+
+	// PresignedURL returns a URL that anyone can read a value from until ttl
+	// passes, whether or not the value is public.
+	PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// PublicURL returns the URL a public value in s can be read from. Stores that
+// don't implement Linker return errors.ErrUnsupported.
+func PublicURL(ctx context.Context, s Interface, key string) (string, error) {
+	l, ok := s.(Linker)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support URLs", errors.ErrUnsupported, s)
+	}
+
+	return l.PublicURL(ctx, key)
+}
+
+// PresignedURL returns a URL that can read a value in s until ttl passes.
+// Stores that don't implement Linker return errors.ErrUnsupported.
+func PresignedURL(ctx context.Context, s Interface, key string, ttl time.Duration) (string, error) {
+	l, ok := s.(Linker)
+	if !ok {
+		return "", fmt.Errorf("%w: %T doesn't support URLs", errors.ErrUnsupported, s)
+	}
+
+	return l.PresignedURL(ctx, key, ttl)
+}
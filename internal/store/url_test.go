@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// linkStore hands out made up URLs for the keys in a Memory.
+type linkStore struct {
+	*Memory
+}
+
+func (l *linkStore) PublicURL(ctx context.Context, key string) (string, error) {
+	return "https://public.example/" + key, nil
+}
+
+func (l *linkStore) PresignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return "https://signed.example/" + key + "?ttl=" + ttl.String(), nil
+}
+
+func TestLinker_Decorators(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		wrap      func(t *testing.T, s Interface) Interface
+		wantKey   string
+		wantError error
+	}{
+		{
+			name:    "prefixed",
+			wrap:    func(t *testing.T, s Interface) Interface { return NewPrefixed(s, "scope") },
+			wantKey: "scope/a",
+		},
+		{
+			name: "lru",
+			wrap: func(t *testing.T, s Interface) Interface {
+				l, err := NewLRU(s, LRUOptions{})
+				if err != nil {
+					t.Fatalf("NewLRU() error = %v", err)
+				}
+				return l
+			},
+			wantKey: "a",
+		},
+		{
+			name:    "observed",
+			wrap:    func(t *testing.T, s Interface) Interface { return observed(s, "test-linker") },
+			wantKey: "a",
+		},
+		{
+			name: "retry",
+			wrap: func(t *testing.T, s Interface) Interface {
+				r, _ := newTestRetry(t, s, RetryOptions{})
+				return r
+			},
+			wantKey: "a",
+		},
+		{
+			name: "encrypted",
+			wrap: func(t *testing.T, s Interface) Interface {
+				return newTestEncrypted(t, s, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+			},
+			wantError: errors.ErrUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.wrap(t, &linkStore{Memory: NewMemory()})
+
+			got, err := PublicURL(ctx, s, "a")
+			if !errors.Is(err, tt.wantError) {
+				t.Fatalf("PublicURL() error = %v, want %v", err, tt.wantError)
+			}
+			if want := "https://public.example/" + tt.wantKey; err == nil && got != want {
+				t.Errorf("PublicURL() = %q, want %q", got, want)
+			}
+
+			got, err = PresignedURL(ctx, s, "a", time.Minute)
+			if !errors.Is(err, tt.wantError) {
+				t.Fatalf("PresignedURL() error = %v, want %v", err, tt.wantError)
+			}
+			if want := "https://signed.example/" + tt.wantKey + "?ttl=1m0s"; err == nil && got != want {
+				t.Errorf("PresignedURL() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestJSON_URLs(t *testing.T) {
+	ctx := context.Background()
+	s := &JSON[codecTestValue]{Underlying: &linkStore{Memory: NewMemory()}, Prefix: "test"}
+
+	got, err := s.PublicURL(ctx, "a")
+	if err != nil {
+		t.Fatalf("PublicURL() error = %v", err)
+	}
+	if want := "https://public.example/test/a"; got != want {
+		t.Errorf("PublicURL() = %q, want %q", got, want)
+	}
+
+	got, err = s.PresignedURL(ctx, "a", time.Minute)
+	if err != nil {
+		t.Fatalf("PresignedURL() error = %v", err)
+	}
+	if want := "https://signed.example/test/a?ttl=1m0s"; got != want {
+		t.Errorf("PresignedURL() = %q, want %q", got, want)
+	}
+}
+
+func TestLinker_Unsupported(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if _, err := PublicURL(ctx, m, "a"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("PublicURL() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if _, err := PresignedURL(ctx, m, "a", time.Minute); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("PresignedURL() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+
+	enc := newTestEncrypted(t, m, EncryptedOptions{Keys: []EncryptionKey{testKey("a", 1)}})
+	if err := SetWithOptions(ctx, enc, "a", []byte("1"), SetOptions{Public: true}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetWithOptions() on an encrypted store error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if err := m.Exists(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetWithOptions() wrote a value it should have refused: %v", err)
+	}
+}
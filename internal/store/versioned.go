@@ -0,0 +1,445 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionedKeep is how many prior revisions Versioned keeps by default.
+const versionedKeep = 10
+
+// Revision is one value a Versioned store held for a key.
+type Revision[T any] struct {
+	// ID numbers the revisions of a key from 1, in the order they were
+	// written.
+	ID int `json:"id"`
+
+	// Time is when the revision was written.
+	Time time.Time `json:"time"`
+
+	// Note says why the revision was written, if anything did.
+	Note string `json:"note,omitempty"`
+
+	// Value is the value the key held.
+	Value T `json:"value"`
+}
+
+// VersionedOptions configure a Versioned store.
+type VersionedOptions struct {
+	// Keep is how many revisions are kept per key besides the current one.
+	// Older ones are deleted as new ones are written. Defaults to 10.
+	Keep int
+
+	// HistoryPrefix is where revisions are kept in the underlying store, as
+	// <HistoryPrefix>/<key>/<id>. It can't be inside the store's Prefix or
+	// contain it. Defaults to the store's Prefix with -history added, eg:
+	// discourse-thread-history.
+	HistoryPrefix string
+}
+
+// Versioned is a Typed store that keeps the last few revisions of every key
+// along with when they were written and an optional note, so a value that was
+// overwritten can be read, compared with Diff or rolled back.
+//
+// The current value of a key is stored where the Typed store keeps it, so
+// readers that don't care about history can keep using a plain Typed store
+// with the same Prefix. Revisions are encoded with the same codec and
+// compression.
+type Versioned[T any] struct {
+	current *Typed[T]
+	history *Typed[Revision[T]]
+	keep    int
+}
+
+// NewVersioned keeps the history of the values written through current.
+func NewVersioned[T any](current *Typed[T], opts VersionedOptions) (*Versioned[T], error) {
+	if opts.Keep < 0 {
+		return nil, fmt.Errorf("%w: can't keep %d revisions", ErrBadConfig, opts.Keep)
+	}
+	if opts.Keep == 0 {
+		opts.Keep = versionedKeep
+	}
+
+	if current.Prefix == "" {
+		return nil, fmt.Errorf("%w: a versioned store needs a prefix to keep its history apart", ErrBadConfig)
+	}
+
+	keyspace := current.Keyspace()
+	if opts.HistoryPrefix == "" {
+		opts.HistoryPrefix = keyspace.String() + "-history"
+	}
+
+	history := NewKeyspace(opts.HistoryPrefix)
+	_, inside := keyspace.Rel(history.String())
+	_, around := history.Rel(keyspace.String())
+	if history == keyspace || inside || around {
+		return nil, fmt.Errorf("%w: history prefix %q overlaps the store's prefix %q", ErrBadConfig, opts.HistoryPrefix, current.Prefix)
+	}
+
+	return &Versioned[T]{
+		current: current,
+		history: &Typed[Revision[T]]{
+			Underlying:  current.Underlying,
+			Prefix:      history.String(),
+			Codec:       current.Codec,
+			Compression: current.Compression,
+		},
+		keep: opts.Keep,
+	}, nil
+}
+
+// revisionKey returns where revision id of key is kept in the history store.
+// IDs are padded so that they list in order.
+func revisionKey(key string, id int) string {
+	return fmt.Sprintf("%s/%010d", key, id)
+}
+
+// revisions returns the IDs of the revisions kept for key, oldest first.
+func (v *Versioned[T]) revisions(ctx context.Context, key string) ([]int, error) {
+	var ids []int
+
+	for name, err := range v.history.Iterate(ctx, key+"/", ListOptions{}) {
+		if err != nil {
+			return nil, err
+		}
+
+		// Revisions of keys nested under key are further down.
+		if strings.Contains(name, "/") {
+			continue
+		}
+
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (v *Versioned[T]) Exists(ctx context.Context, key string) error {
+	return v.current.Exists(ctx, key)
+}
+
+func (v *Versioned[T]) Get(ctx context.Context, key string) (T, error) {
+	return v.current.Get(ctx, key)
+}
+
+func (v *Versioned[T]) List(ctx context.Context, prefix string) ([]string, error) {
+	return v.current.List(ctx, prefix)
+}
+
+// Set writes value as the current value of key and records it as a new
+// revision with note, returning the revision's ID. A value that was written
+// before the key had any history is recorded as a revision first, so it isn't
+// lost.
+//
+// Set returns ErrConflict if another revision of key was written at the same
+// time. If the revision was written but older ones couldn't be deleted, Set
+// returns its ID along with the error.
+func (v *Versioned[T]) Set(ctx context.Context, key string, value T, note string) (int, error) {
+	ids, err := v.revisions(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("can't list revisions of %s: %w", key, err)
+	}
+
+	if len(ids) == 0 {
+		adopted, err := v.adopt(ctx, key)
+		if err != nil {
+			return 0, fmt.Errorf("can't keep the current value of %s: %w", key, err)
+		}
+		ids = adopted
+	}
+
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	if err := v.record(ctx, key, Revision[T]{ID: id, Time: time.Now().UTC(), Note: note, Value: value}); err != nil {
+		return 0, err
+	}
+
+	if err := v.current.Set(ctx, key, value); err != nil {
+		return 0, err
+	}
+
+	ids = append(ids, id)
+	if stale := len(ids) - v.keep - 1; stale > 0 {
+		keys := make([]string, stale)
+		for i, old := range ids[:stale] {
+			keys[i] = revisionKey(key, old)
+		}
+
+		if err := v.history.DeleteMany(ctx, keys); err != nil {
+			return id, fmt.Errorf("can't delete old revisions of %s: %w", key, err)
+		}
+	}
+
+	return id, nil
+}
+
+// adopt records the current value of a key without history as its first
+// revision, and returns the IDs it has afterwards.
+func (v *Versioned[T]) adopt(ctx context.Context, key string) ([]int, error) {
+	value, err := v.current.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rev := Revision[T]{ID: 1, Note: "written before history was kept", Value: value}
+	if info, err := v.current.Stat(ctx, key); err == nil {
+		rev.Time = info.LastModified.UTC()
+	}
+
+	if err := v.record(ctx, key, rev); err != nil {
+		return nil, err
+	}
+
+	return []int{1}, nil
+}
+
+// record writes rev unless a revision with its ID already exists.
+func (v *Versioned[T]) record(ctx context.Context, key string, rev Revision[T]) error {
+	err := v.history.Create(ctx, revisionKey(key, rev.ID), rev)
+	if errors.Is(err, errors.ErrUnsupported) {
+		err = v.history.Set(ctx, revisionKey(key, rev.ID), rev)
+	}
+	if err != nil {
+		return fmt.Errorf("can't write revision %d of %s: %w", rev.ID, key, err)
+	}
+
+	return nil
+}
+
+// Delete removes the current value of key along with its history.
+func (v *Versioned[T]) Delete(ctx context.Context, key string) error {
+	ids, err := v.revisions(ctx, key)
+	if err != nil {
+		return fmt.Errorf("can't list revisions of %s: %w", key, err)
+	}
+
+	err = v.current.Delete(ctx, key)
+	if err != nil && (!errors.Is(err, ErrNotFound) || len(ids) == 0) {
+		return err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = revisionKey(key, id)
+	}
+
+	return v.history.DeleteMany(ctx, keys)
+}
+
+// History returns the revisions kept for key, newest first. The first one
+// holds the current value. It returns ErrNotFound if key has no history.
+func (v *Versioned[T]) History(ctx context.Context, key string) ([]Revision[T], error) {
+	ids, err := v.revisions(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("can't list revisions of %s: %w", key, err)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: %s has no history", ErrNotFound, key)
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = revisionKey(key, id)
+	}
+
+	revs, err := v.history.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Revision[T], 0, len(revs))
+	for _, k := range slices.Backward(keys) {
+		// Revisions deleted since they were listed are left out.
+		if rev, ok := revs[k]; ok {
+			result = append(result, rev)
+		}
+	}
+
+	return result, nil
+}
+
+// Revision reads revision id of key. It returns ErrNotFound if the revision
+// was never written or is too old to be kept.
+func (v *Versioned[T]) Revision(ctx context.Context, key string, id int) (Revision[T], error) {
+	return v.history.Get(ctx, revisionKey(key, id))
+}
+
+// Diff compares revisions from and to of key (see Diff).
+func (v *Versioned[T]) Diff(ctx context.Context, key string, from, to int) ([]Change, error) {
+	a, err := v.Revision(ctx, key, from)
+	if err != nil {
+		return nil, fmt.Errorf("can't read revision %d of %s: %w", from, key, err)
+	}
+
+	b, err := v.Revision(ctx, key, to)
+	if err != nil {
+		return nil, fmt.Errorf("can't read revision %d of %s: %w", to, key, err)
+	}
+
+	return Diff(a.Value, b.Value)
+}
+
+// Rollback makes the value of revision id the current value of key again,
+// recording it as a new revision so the history stays linear. The note
+// defaults to naming the revision rolled back to.
+func (v *Versioned[T]) Rollback(ctx context.Context, key string, id int, note string) (int, error) {
+	rev, err := v.Revision(ctx, key, id)
+	if err != nil {
+		return 0, fmt.Errorf("can't read revision %d of %s: %w", id, key, err)
+	}
+
+	if note == "" {
+		note = fmt.Sprintf("rolled back to revision %d", id)
+	}
+
+	return v.Set(ctx, key, rev.Value, note)
+}
+
+// ChangeOp is what a Change did to a part of a value.
+type ChangeOp string
+
+const (
+	// ChangeAdd is a part that's only in the newer value.
+	ChangeAdd ChangeOp = "add"
+
+	// ChangeRemove is a part that's only in the older value.
+	ChangeRemove ChangeOp = "remove"
+
+	// ChangeReplace is a part that's in both values, differently.
+	ChangeReplace ChangeOp = "replace"
+)
+
+// Change is one difference between two values found by Diff.
+type Change struct {
+	Op ChangeOp
+
+	// Path is a JSON pointer to the part that changed, eg: /posts/2/body. The
+	// whole value is "".
+	Path string
+
+	// From and To are the part before and after the change, as decoded JSON.
+	// From is nil when it was added and To when it was removed.
+	From, To any
+}
+
+// Diff compares the JSON encodings of two values and returns every part that
+// changed from one to the other, in order of path. Parts of arrays are
+// compared by index, so an item inserted at the start shows up as every item
+// after it being replaced.
+func Diff[T any](from, to T) ([]Change, error) {
+	a, err := toJSONValue(from)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := toJSONValue(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffJSON("", a, b, nil), nil
+}
+
+// toJSONValue converts value to its decoded JSON form, keeping numbers exact.
+func toJSONValue(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCantEncode, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var result any
+	if err := dec.Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCantDecode, err)
+	}
+
+	return result, nil
+}
+
+// diffJSON appends the changes from a to b under path to changes.
+func diffJSON(path string, a, b any, changes []Change) []Change {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+
+		for _, k := range slices.Sorted(maps.Keys(mergeKeys(a, b))) {
+			p := path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+			av, inA := a[k]
+			bv, inB := b[k]
+
+			switch {
+			case !inA:
+				changes = append(changes, Change{Op: ChangeAdd, Path: p, To: bv})
+			case !inB:
+				changes = append(changes, Change{Op: ChangeRemove, Path: p, From: av})
+			default:
+				changes = diffJSON(p, av, bv, changes)
+			}
+		}
+		return changes
+
+	case []any:
+		b, ok := b.([]any)
+		if !ok {
+			break
+		}
+
+		for i := range max(len(a), len(b)) {
+			p := path + "/" + strconv.Itoa(i)
+
+			switch {
+			case i >= len(a):
+				changes = append(changes, Change{Op: ChangeAdd, Path: p, To: b[i]})
+			case i >= len(b):
+				changes = append(changes, Change{Op: ChangeRemove, Path: p, From: a[i]})
+			default:
+				changes = diffJSON(p, a[i], b[i], changes)
+			}
+		}
+		return changes
+
+	default:
+		// Scalars are comparable, and never equal to objects or arrays.
+		if a == b {
+			return changes
+		}
+	}
+
+	return append(changes, Change{Op: ChangeReplace, Path: path, From: a, To: b})
+}
+
+// mergeKeys returns the set of keys in either map.
+func mergeKeys(a, b map[string]any) map[string]struct{} {
+	result := make(map[string]struct{}, len(a)+len(b))
+	for k := range a {
+		result[k] = struct{}{}
+	}
+	for k := range b {
+		result[k] = struct{}{}
+	}
+	return result
+}
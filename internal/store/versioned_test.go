@@ -0,0 +1,332 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestVersioned(t *testing.T, underlying Interface, opts VersionedOptions) *Versioned[codecTestValue] {
+	t.Helper()
+
+	v, err := NewVersioned(&Typed[codecTestValue]{Underlying: underlying, Prefix: "values"}, opts)
+	if err != nil {
+		t.Fatalf("NewVersioned() error = %v", err)
+	}
+	return v
+}
+
+// revisionIDs returns the IDs of revs in order.
+func revisionIDs[T any](revs []Revision[T]) []int {
+	ids := make([]int, len(revs))
+	for i, rev := range revs {
+		ids[i] = rev.ID
+	}
+	return ids
+}
+
+func TestNewVersioned(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		opts    VersionedOptions
+		wantErr error
+	}{
+		{
+			name:   "default history prefix",
+			prefix: "values",
+		},
+		{
+			name:   "separate history prefix",
+			prefix: "values",
+			opts:   VersionedOptions{HistoryPrefix: "history/values", Keep: 3},
+		},
+		{
+			name:    "no prefix",
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "history inside the values",
+			prefix:  "values",
+			opts:    VersionedOptions{HistoryPrefix: "values/history"},
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "history around the values",
+			prefix:  "history/values",
+			opts:    VersionedOptions{HistoryPrefix: "history/"},
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "history in the values",
+			prefix:  "values",
+			opts:    VersionedOptions{HistoryPrefix: "/values/"},
+			wantErr: ErrBadConfig,
+		},
+		{
+			name:    "negative keep",
+			prefix:  "values",
+			opts:    VersionedOptions{Keep: -1},
+			wantErr: ErrBadConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVersioned(&Typed[codecTestValue]{Underlying: NewMemory(), Prefix: tt.prefix}, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewVersioned() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVersioned(t *testing.T) {
+	ctx := context.Background()
+
+	stores := map[string]func(t *testing.T) Interface{
+		"memory":    func(t *testing.T) Interface { return NewMemory() },
+		"directory": func(t *testing.T) Interface { return newTestDirectory(t, nil) },
+		"s3api": func(t *testing.T) Interface {
+			s, _ := newTestS3API(t)
+			return s
+		},
+		// Only the methods of Interface, so revisions can't be claimed with
+		// Create.
+		"unconditional": func(t *testing.T) Interface { return struct{ Interface }{NewMemory()} },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			underlying := newStore(t)
+			v := newTestVersioned(t, underlying, VersionedOptions{Keep: 2})
+
+			before := time.Now().Add(-time.Second)
+			for i := range 4 {
+				id, err := v.Set(ctx, "a/thread", codecTestValue{Name: "thread", Value: i}, fmt.Sprintf("run %d", i))
+				if err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+				if id != i+1 {
+					t.Errorf("Set() = %d, want %d", id, i+1)
+				}
+			}
+
+			got, err := v.Get(ctx, "a/thread")
+			if err != nil || got.Value != 3 {
+				t.Errorf("Get() = %+v, %v, want the last value", got, err)
+			}
+
+			// Plain Typed readers see the current value.
+			plain := &Typed[codecTestValue]{Underlying: underlying, Prefix: "values"}
+			if got, err := plain.Get(ctx, "a/thread"); err != nil || got.Value != 3 {
+				t.Errorf("Typed.Get() = %+v, %v, want the last value", got, err)
+			}
+			if keys, _ := v.List(ctx, ""); !slices.Equal(keys, []string{"a/thread"}) {
+				t.Errorf("List() = %v, want only the current value", keys)
+			}
+
+			history, err := v.History(ctx, "a/thread")
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			if ids := revisionIDs(history); !slices.Equal(ids, []int{4, 3, 2}) {
+				t.Errorf("History() IDs = %v, want the current and 2 prior revisions newest first", ids)
+			}
+			if history[0].Note != "run 3" || history[0].Value.Value != 3 || history[0].Time.Before(before) {
+				t.Errorf("History()[0] = %+v, want the last revision", history[0])
+			}
+
+			if _, err := v.Revision(ctx, "a/thread", 1); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Revision(1) error = %v, want %v after it was pruned", err, ErrNotFound)
+			}
+
+			rev, err := v.Revision(ctx, "a/thread", 2)
+			if err != nil || rev.Value.Value != 1 || rev.Note != "run 1" {
+				t.Errorf("Revision(2) = %+v, %v, want the second value", rev, err)
+			}
+
+			changes, err := v.Diff(ctx, "a/thread", 2, 4)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+			if want := []Change{{Op: ChangeReplace, Path: "/value", From: json.Number("1"), To: json.Number("3")}}; fmt.Sprint(changes) != fmt.Sprint(want) {
+				t.Errorf("Diff() = %v, want %v", changes, want)
+			}
+
+			id, err := v.Rollback(ctx, "a/thread", 2, "")
+			if err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+			if got, _ := v.Get(ctx, "a/thread"); id != 5 || got.Value != 1 {
+				t.Errorf("Rollback() = %d and Get() = %+v, want revision 5 holding the second value", id, got)
+			}
+			if rev, _ := v.Revision(ctx, "a/thread", 5); rev.Note != "rolled back to revision 2" {
+				t.Errorf("Rollback() note = %q, want the revision rolled back to", rev.Note)
+			}
+
+			// Revisions of nested keys aren't mixed up with their parent's.
+			// Directories can't hold a key and keys nested under it.
+			parent := name != "directory"
+			if parent {
+				if _, err := v.Set(ctx, "a", codecTestValue{Name: "parent"}, ""); err != nil {
+					t.Fatalf("Set(a) error = %v", err)
+				}
+				if history, err := v.History(ctx, "a"); err != nil || !slices.Equal(revisionIDs(history), []int{1}) {
+					t.Errorf("History(a) = %v, %v, want its own revision", revisionIDs(history), err)
+				}
+			}
+
+			if err := v.Delete(ctx, "a/thread"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := v.Get(ctx, "a/thread"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() after Delete() error = %v, want %v", err, ErrNotFound)
+			}
+			if _, err := v.History(ctx, "a/thread"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("History() after Delete() error = %v, want %v", err, ErrNotFound)
+			}
+			if parent {
+				if err := v.Exists(ctx, "a"); err != nil {
+					t.Errorf("Exists(a) after deleting a/thread error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestVersioned_KeepsExistingValue(t *testing.T) {
+	ctx := context.Background()
+
+	m := NewMemory()
+	plain := &Typed[codecTestValue]{Underlying: m, Prefix: "values"}
+	if err := plain.Set(ctx, "thread", codecTestValue{Name: "before"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	v := newTestVersioned(t, m, VersionedOptions{})
+	id, err := v.Set(ctx, "thread", codecTestValue{Name: "after"}, "rerun")
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if id != 2 {
+		t.Errorf("Set() = %d, want 2 after the existing value", id)
+	}
+
+	rev, err := v.Revision(ctx, "thread", 1)
+	if err != nil {
+		t.Fatalf("Revision(1) error = %v", err)
+	}
+	if rev.Value.Name != "before" || rev.Time.IsZero() {
+		t.Errorf("Revision(1) = %+v, want the existing value and when it was written", rev)
+	}
+
+	if keys := storeContents(t, m); len(keys) != 3 {
+		t.Errorf("store holds %v, want the value and 2 revisions", keys)
+	}
+}
+
+// unlistedStore lists no keys, as if every key was written after it listed.
+type unlistedStore struct {
+	*Memory
+}
+
+func (u unlistedStore) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (u unlistedStore) Iterate(ctx context.Context, prefix string, opts ListOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {}
+}
+
+func TestVersioned_Conflict(t *testing.T) {
+	ctx := context.Background()
+
+	m := NewMemory()
+	v := newTestVersioned(t, unlistedStore{m}, VersionedOptions{})
+
+	// Another writer claims revision 1 after this one listed none.
+	if err := m.Set(ctx, "values-history/thread/0000000001", []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if _, err := v.Set(ctx, "thread", codecTestValue{Name: "late"}, ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("Set() error = %v, want %v", err, ErrConflict)
+	}
+	if err := m.Exists(ctx, "values/thread"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists() error = %v, want the current value untouched", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type post struct {
+		Body string   `json:"body"`
+		Tags []string `json:"tags,omitempty"`
+	}
+	type thread struct {
+		Title string            `json:"title"`
+		Posts []post            `json:"posts"`
+		Meta  map[string]string `json:"meta,omitempty"`
+	}
+
+	tests := []struct {
+		name     string
+		from, to thread
+		want     []Change
+	}{
+		{
+			name: "same",
+			from: thread{Title: "a", Posts: []post{{Body: "x"}}},
+			to:   thread{Title: "a", Posts: []post{{Body: "x"}}},
+		},
+		{
+			name: "replaced field",
+			from: thread{Title: "a"},
+			to:   thread{Title: "b"},
+			want: []Change{{Op: ChangeReplace, Path: "/title", From: "a", To: "b"}},
+		},
+		{
+			name: "nested fields",
+			from: thread{Posts: []post{{Body: "x"}, {Body: "y"}}},
+			to:   thread{Posts: []post{{Body: "x", Tags: []string{"t"}}, {Body: "z"}}},
+			want: []Change{
+				{Op: ChangeAdd, Path: "/posts/0/tags", To: []any{"t"}},
+				{Op: ChangeReplace, Path: "/posts/1/body", From: "y", To: "z"},
+			},
+		},
+		{
+			name: "added and removed items",
+			from: thread{Posts: []post{{Body: "x"}}, Meta: map[string]string{"a/b": "1", "c": "2"}},
+			to:   thread{Posts: []post{{Body: "x"}, {Body: "y"}}, Meta: map[string]string{"c": "2", "d~": "3"}},
+			want: []Change{
+				{Op: ChangeRemove, Path: "/meta/a~1b", From: "1"},
+				{Op: ChangeAdd, Path: "/meta/d~0", To: "3"},
+				{Op: ChangeAdd, Path: "/posts/1", To: map[string]any{"body": "y"}},
+			},
+		},
+		{
+			name: "null to array",
+			from: thread{},
+			to:   thread{Posts: []post{}},
+			want: []Change{{Op: ChangeReplace, Path: "/posts", To: []any{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Package tracing sets up OpenTelemetry tracing for the commands in this repo
+// and traces the HTTP requests they make.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrUnknownExporter is returned by Setup for exporters it doesn't know about.
+var ErrUnknownExporter = errors.New("tracing: unknown exporter")
+
+const tracerName = "github.com/tigrisdata-community/glue/internal/tracing"
+
+// Setup makes the global tracer provider send spans for serviceName to
+// exporter, which is one of:
+//
+//   - "" or "none": tracing is disabled.
+//   - "stdout": spans are written to standard output as JSON.
+//   - "otlp": spans are sent with OTLP over HTTP, configured with the standard
+//     OTEL_EXPORTER_OTLP_* environment variables.
+//
+// The returned function flushes any buffered spans and must be called before
+// the program exits.
+func Setup(ctx context.Context, serviceName, exporter string) (func(context.Context) error, error) {
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exp, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExporter, exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't create %s trace exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("can't create trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// Transport wraps a http transport so that every request creates a span.
+// Only the method, host and response status are recorded, since some URLs,
+// like Discord webhook URLs, contain secrets.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return tracingTransport{rt: rt}
+}
+
+type tracingTransport struct {
+	rt http.RoundTripper
+}
+
+func (tt tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "HTTP "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.ServerAddress(r.URL.Hostname()),
+			semconv.URLScheme(r.URL.Scheme),
+		),
+	)
+	defer span.End()
+
+	resp, err := tt.rt.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		exporter string
+		wantErr  error
+	}{
+		{exporter: ""},
+		{exporter: "none"},
+		{exporter: "stdout"},
+		{exporter: "zipkin", wantErr: ErrUnknownExporter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exporter, func(t *testing.T) {
+			defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+			shutdown, err := Setup(ctx, "test", tt.exporter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil {
+				if err := shutdown(ctx); err != nil {
+					t.Errorf("shutdown() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	defer tp.Shutdown(context.Background())
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "oops", http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cli := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	for _, path := range []string{"/ok", "/broken"} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path+"?token=secret", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := cli.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	parent.End()
+
+	spans := exp.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	for i, wantStatus := range []codes.Code{codes.Unset, codes.Error} {
+		span := spans[i]
+
+		if span.Name != "HTTP GET" {
+			t.Errorf("span %d name = %q, want %q", i, span.Name, "HTTP GET")
+		}
+
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d isn't a child of the request context's span", i)
+		}
+
+		if span.Status.Code != wantStatus {
+			t.Errorf("span %d status = %v, want %v", i, span.Status.Code, wantStatus)
+		}
+
+		for _, kv := range span.Attributes {
+			if v := kv.Value.Emit(); v == srv.URL+"/ok?token=secret" || v == "/ok" {
+				t.Errorf("span %d records the URL in %s", i, kv.Key)
+			}
+		}
+	}
+}